@@ -0,0 +1,891 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AuditActionKeyCreate        = "key.create"
+	AuditActionKeyUpdate        = "key.update"
+	AuditActionKeyDelete        = "key.delete"
+	AuditActionKeysClean        = "keys.clean"
+	AuditActionLogin            = "auth.login"
+	AuditActionLoginFailed      = "auth.login_failed"
+	AuditActionLogout           = "auth.logout"
+	AuditActionSessionKill      = "session.disconnect"
+	AuditActionWebhookCreate    = "webhook.create"
+	AuditActionWebhookUpdate    = "webhook.update"
+	AuditActionWebhookDelete    = "webhook.delete"
+	AuditActionWebhookRedeliver = "webhook.redeliver"
+	AuditActionReportFinalize   = "billing.finalize"
+
+	auditGenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+	auditRedacted    = "[REDACTED]"
+
+	auditSpoolFile           = "audit-pending.ndjson"
+	auditSpoolReplayInterval = 30 * time.Second
+	auditQueueSize           = 1024
+	auditEnqueueWait         = 500 * time.Millisecond
+	auditHeadID              = "head"
+
+	auditLoginFailureWindow = time.Minute
+	auditLoginFailureMaxIPs = 10000
+	auditLoginOverflowIP    = "*"
+)
+
+var auditSecretFields = []string{"password", "secret", "token", "customkey", "apikey"}
+
+type AuditFieldChange struct {
+	Field  string          `bson:"field" json:"field"`
+	Before json.RawMessage `bson:"before,omitempty" json:"before,omitempty"`
+	After  json.RawMessage `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// AuditEntry is one link in the audit hash chain. ResourceLabel is a display
+// form of ResourceID, such as a masked key, and is not covered by the hash.
+type AuditEntry struct {
+	Seq           int64              `bson:"_id" json:"seq"`
+	Timestamp     time.Time          `bson:"timestamp" json:"timestamp"`
+	Actor         string             `bson:"actor" json:"actor"`
+	IP            string             `bson:"ip" json:"ip"`
+	RequestID     string             `bson:"requestId" json:"requestId"`
+	Action        string             `bson:"action" json:"action"`
+	ResourceType  string             `bson:"resourceType" json:"resourceType"`
+	ResourceID    string             `bson:"resourceId" json:"resourceId"`
+	ResourceLabel string             `bson:"resourceLabel,omitempty" json:"resourceLabel,omitempty"`
+	Changes       []AuditFieldChange `bson:"changes" json:"changes"`
+	PrevHash      string             `bson:"prevHash" json:"prevHash"`
+	Hash          string             `bson:"hash" json:"hash"`
+}
+
+type AuditChainStatus struct {
+	Valid      bool   `json:"valid"`
+	Checked    int64  `json:"checked"`
+	LastSeq    int64  `json:"lastSeq"`
+	LastHash   string `json:"lastHash"`
+	HeadSeq    int64  `json:"headSeq"`
+	InvalidSeq int64  `json:"invalidSeq,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+type AuditHead struct {
+	ID        string    `bson:"_id" json:"-"`
+	Seq       int64     `bson:"seq" json:"seq"`
+	Hash      string    `bson:"hash" json:"hash"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+	Signature string    `bson:"signature" json:"signature"`
+}
+
+type AuditChain struct {
+	loaded     bool
+	lastSeq    int64
+	lastHash   string
+	mu         sync.Mutex
+	failed     atomic.Int64
+	pending    atomic.Int64
+	overflowed atomic.Int64
+	queue      chan AuditEntry
+	queueMu    sync.RWMutex
+	closed     bool
+	done       chan struct{}
+	failures   map[string]*auditLoginFailures
+	failuresMu sync.Mutex
+}
+
+type auditLoginFailures struct {
+	since time.Time
+	count int
+}
+
+type AuditStats struct {
+	FailedWrites int64 `json:"failedWrites"`
+	Spooled      int64 `json:"spooled"`
+	Overflowed   int64 `json:"overflowed"`
+}
+
+func (e *AuditEntry) computeHash(secret string) string {
+	changes := []byte("[]")
+	if len(e.Changes) > 0 {
+		changes, _ = json.Marshal(e.Changes)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(strconv.FormatInt(e.Seq, 10))
+	for _, part := range []string{
+		e.Timestamp.UTC().Format(time.RFC3339Nano),
+		e.Actor,
+		e.IP,
+		e.RequestID,
+		e.Action,
+		e.ResourceType,
+		e.ResourceID,
+		string(changes),
+		e.PrevHash,
+	} {
+		buf.WriteByte('\n')
+		buf.WriteString(part)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(buf.Bytes())
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *AuditHead) computeSignature(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(h.Seq, 10) + "\n" + h.Hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func isAuditSecretField(field string) bool {
+	field = strings.ToLower(field)
+	for _, secret := range auditSecretFields {
+		if strings.Contains(field, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+func auditValue(field string, value interface{}) json.RawMessage {
+	if isAuditSecretField(field) {
+		value = auditRedacted
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprintf("%v", value))
+	}
+	return data
+}
+
+func auditKeySnapshot(apiKey *APIKey) map[string]interface{} {
+	if apiKey == nil {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"name":          apiKey.Name,
+		"expiration":    apiKey.Expiration.UTC().Format(time.RFC3339),
+		"rpm":           apiKey.RPM,
+		"threadsLimit":  apiKey.ThreadsLimit,
+		"totalRequests": apiKey.TotalRequests,
+		"isActive":      apiKey.IsActive,
+		"scopes":        scopesOrEmpty(apiKey.Scopes),
+		"plan":          apiKey.Plan,
+		"orgId":         apiKey.OrgID,
+		"limits":        limitRulesOrEmpty(apiKey.Limits),
+		"quota":         apiKey.Quota,
+	}
+}
+
+func auditKeyChanges(before, after *APIKey) []AuditFieldChange {
+	beforeSnapshot := auditKeySnapshot(before)
+	afterSnapshot := auditKeySnapshot(after)
+
+	fields := []string{"name", "expiration", "rpm", "threadsLimit", "totalRequests", "isActive", "scopes", "plan", "orgId", "limits", "quota"}
+	changes := []AuditFieldChange{}
+	for _, field := range fields {
+		change := AuditFieldChange{Field: field}
+		if before != nil {
+			change.Before = auditValue(field, beforeSnapshot[field])
+		}
+		if after != nil {
+			change.After = auditValue(field, afterSnapshot[field])
+		}
+		if before != nil && after != nil && bytes.Equal(change.Before, change.After) {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func (m *APIKeyManager) loadAuditHead(ctx context.Context) error {
+	if m.audit.loaded {
+		return nil
+	}
+
+	var last AuditEntry
+	err := m.auditCollection.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})).Decode(&last)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		m.audit.lastSeq = 0
+		m.audit.lastHash = auditGenesisHash
+	case err != nil:
+		return fmt.Errorf("failed to load audit head: %w", err)
+	default:
+		m.audit.lastSeq = last.Seq
+		m.audit.lastHash = last.Hash
+	}
+
+	m.audit.loaded = true
+	return nil
+}
+
+func normalizeAuditEntry(entry AuditEntry) AuditEntry {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	entry.Timestamp = entry.Timestamp.UTC().Truncate(time.Millisecond)
+	if entry.Changes == nil {
+		entry.Changes = []AuditFieldChange{}
+	}
+	return entry
+}
+
+func (m *APIKeyManager) appendAudit(entry AuditEntry) error {
+	m.audit.mu.Lock()
+	defer m.audit.mu.Unlock()
+
+	entry = normalizeAuditEntry(entry)
+
+	var err error
+	if m.audit.pending.Load() > 0 {
+		err = m.replayAuditSpoolLocked()
+	}
+	if err == nil {
+		err = m.insertAuditLocked(entry)
+	}
+	if err != nil {
+		m.audit.failed.Add(1)
+		if spoolErr := m.spoolAuditLocked(entry); spoolErr != nil {
+			return fmt.Errorf("%w (spool failed: %v)", err, spoolErr)
+		}
+		m.Warn("Audit entry spooled to disk", "component", "audit", "action", entry.Action, "pending", m.audit.pending.Load(), "error", err)
+	}
+	return nil
+}
+
+// insertAudit writes entry straight to the store without spooling, for
+// one-shot commands that must not share the server's spool file.
+func (m *APIKeyManager) insertAudit(entry AuditEntry) error {
+	m.audit.mu.Lock()
+	defer m.audit.mu.Unlock()
+	return m.insertAuditLocked(normalizeAuditEntry(entry))
+}
+
+func (m *APIKeyManager) insertAuditLocked(entry AuditEntry) error {
+	if !m.isMongoConnected() || m.auditCollection == nil {
+		return errors.New("database connection unavailable")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := m.loadAuditHead(ctx); err != nil {
+			return err
+		}
+
+		entry.Seq = m.audit.lastSeq + 1
+		entry.PrevHash = m.audit.lastHash
+		entry.Hash = entry.computeHash(m.config.AuditSecret)
+
+		_, err := m.auditCollection.InsertOne(ctx, entry)
+		if err == nil {
+			m.audit.lastSeq = entry.Seq
+			m.audit.lastHash = entry.Hash
+			if err := m.storeAuditHead(ctx, entry); err != nil {
+				m.Warn("Failed to store audit chain head", "component", "audit", "seq", entry.Seq, "error", err)
+			}
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("failed to append audit entry: %w", err)
+		}
+		m.audit.loaded = false
+	}
+
+	return errors.New("failed to append audit entry: concurrent writer detected")
+}
+
+func (m *APIKeyManager) storeAuditHead(ctx context.Context, entry AuditEntry) error {
+	if m.auditHeadCollection == nil {
+		return nil
+	}
+
+	head := AuditHead{ID: auditHeadID, Seq: entry.Seq, Hash: entry.Hash, UpdatedAt: time.Now().UTC()}
+	head.Signature = head.computeSignature(m.config.AuditSecret)
+
+	_, err := m.auditHeadCollection.ReplaceOne(ctx,
+		bson.M{"_id": auditHeadID, "seq": bson.M{"$lt": head.Seq}},
+		head,
+		options.Replace().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another writer already recorded a later head.
+		return nil
+	}
+	return err
+}
+
+func (m *APIKeyManager) loadAuditHeadAnchor(ctx context.Context) (*AuditHead, error) {
+	var head AuditHead
+	err := m.auditHeadCollection.FindOne(ctx, bson.M{"_id": auditHeadID}).Decode(&head)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load audit head: %w", err)
+	}
+	return &head, nil
+}
+
+func (m *APIKeyManager) recordAudit(c *gin.Context, action, resourceType, resourceID string, changes []AuditFieldChange) {
+	m.enqueueAudit(newRequestAuditEntry(c, action, resourceType, resourceID, changes))
+}
+
+// recordKeyAudit records a change to an API key under its keyRef, which is
+// unique per key, keeping the masked key only as a label.
+func (m *APIKeyManager) recordKeyAudit(c *gin.Context, action, keyID string, changes []AuditFieldChange) {
+	entry := newRequestAuditEntry(c, action, "apiKey", m.keyRef(keyID), changes)
+	entry.ResourceLabel = maskAPIKey(keyID)
+	m.enqueueAudit(entry)
+}
+
+func newRequestAuditEntry(c *gin.Context, action, resourceType, resourceID string, changes []AuditFieldChange) AuditEntry {
+	return AuditEntry{
+		Timestamp:    time.Now().UTC(),
+		Actor:        c.GetString("userID"),
+		IP:           c.ClientIP(),
+		RequestID:    c.GetString("requestID"),
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Changes:      changes,
+	}
+}
+
+// enqueueAudit hands an entry to the audit writer. When the queue stays full
+// for auditEnqueueWait the entry is written synchronously instead, so it still
+// reaches the store or the disk spool rather than being lost.
+func (m *APIKeyManager) enqueueAudit(entry AuditEntry) {
+	m.audit.queueMu.RLock()
+	if m.audit.queue == nil || m.audit.closed {
+		m.audit.queueMu.RUnlock()
+		m.writeAudit(entry)
+		return
+	}
+
+	queued := true
+	select {
+	case m.audit.queue <- entry:
+	default:
+		timer := time.NewTimer(auditEnqueueWait)
+		select {
+		case m.audit.queue <- entry:
+		case <-timer.C:
+			queued = false
+		}
+		timer.Stop()
+	}
+	m.audit.queueMu.RUnlock()
+	if queued {
+		return
+	}
+
+	m.audit.overflowed.Add(1)
+	m.Warn("Audit queue full, writing entry synchronously", "component", "audit", "action", entry.Action, "resourceId", entry.ResourceID)
+	m.writeAudit(entry)
+}
+
+func (m *APIKeyManager) writeAudit(entry AuditEntry) {
+	if err := m.appendAudit(entry); err != nil {
+		m.Error("Failed to record audit entry", "action", entry.Action, "resourceId", entry.ResourceID, "error", err)
+	}
+}
+
+func (m *APIKeyManager) auditWriter() {
+	m.audit.queueMu.Lock()
+	m.audit.queue = make(chan AuditEntry, auditQueueSize)
+	m.audit.done = make(chan struct{})
+	m.audit.queueMu.Unlock()
+
+	go func() {
+		defer close(m.audit.done)
+
+		ticker := time.NewTicker(auditLoginFailureWindow)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case entry := <-m.audit.queue:
+				m.writeAudit(entry)
+			case now := <-ticker.C:
+				for _, entry := range m.flushLoginFailures(now, false) {
+					m.writeAudit(entry)
+				}
+			case <-m.ctx.Done():
+				m.audit.queueMu.Lock()
+				m.audit.closed = true
+				m.audit.queueMu.Unlock()
+
+				for {
+					select {
+					case entry := <-m.audit.queue:
+						m.writeAudit(entry)
+					default:
+						for _, entry := range m.flushLoginFailures(time.Now(), true) {
+							m.writeAudit(entry)
+						}
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+func (m *APIKeyManager) waitAuditWriter(timeout time.Duration) {
+	if m.audit.done == nil {
+		return
+	}
+	select {
+	case <-m.audit.done:
+	case <-time.After(timeout):
+		m.Warn("Timed out waiting for audit writer to drain", "component", "audit", "queued", len(m.audit.queue))
+	}
+}
+
+// recordLoginFailure audits the first failed login from an address in each
+// window and folds the rest into a single suppressedAttempts entry.
+func (m *APIKeyManager) recordLoginFailure(c *gin.Context) {
+	ip := c.ClientIP()
+	now := time.Now()
+
+	m.audit.failuresMu.Lock()
+	if m.audit.failures == nil {
+		m.audit.failures = make(map[string]*auditLoginFailures)
+	}
+	window, ok := m.audit.failures[ip]
+	if !ok && len(m.audit.failures) >= auditLoginFailureMaxIPs {
+		ip = auditLoginOverflowIP
+		window, ok = m.audit.failures[ip]
+	}
+	if ok && now.Sub(window.since) < auditLoginFailureWindow {
+		window.count++
+		m.audit.failuresMu.Unlock()
+		return
+	}
+	suppressed := 0
+	if ok {
+		suppressed = window.count
+	}
+	m.audit.failures[ip] = &auditLoginFailures{since: now}
+	m.audit.failuresMu.Unlock()
+
+	var changes []AuditFieldChange
+	if suppressed > 0 {
+		changes = suppressedLoginChanges(suppressed)
+	}
+	m.recordAudit(c, AuditActionLoginFailed, "session", "", changes)
+}
+
+func (m *APIKeyManager) flushLoginFailures(now time.Time, force bool) []AuditEntry {
+	m.audit.failuresMu.Lock()
+	defer m.audit.failuresMu.Unlock()
+
+	var entries []AuditEntry
+	for ip, window := range m.audit.failures {
+		if !force && now.Sub(window.since) < auditLoginFailureWindow {
+			continue
+		}
+		delete(m.audit.failures, ip)
+		if window.count == 0 {
+			continue
+		}
+		entries = append(entries, AuditEntry{
+			Timestamp:    now.UTC(),
+			IP:           ip,
+			Action:       AuditActionLoginFailed,
+			ResourceType: "session",
+			Changes:      suppressedLoginChanges(window.count),
+		})
+	}
+	return entries
+}
+
+func suppressedLoginChanges(count int) []AuditFieldChange {
+	return []AuditFieldChange{{Field: "suppressedAttempts", After: auditValue("suppressedAttempts", count)}}
+}
+
+func (m *APIKeyManager) auditSpoolPath() string {
+	return filepath.Join(m.config.LogJournalDir, auditSpoolFile)
+}
+
+func (m *APIKeyManager) spoolAuditLocked(entry AuditEntry) error {
+	if err := os.MkdirAll(m.config.LogJournalDir, 0755); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(m.auditSpoolPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	m.audit.pending.Add(1)
+	return nil
+}
+
+func (m *APIKeyManager) readAuditSpool() ([]AuditEntry, error) {
+	file, err := os.Open(m.auditSpoolPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("corrupt audit spool entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func (m *APIKeyManager) rewriteAuditSpool(entries []AuditEntry) error {
+	path := m.auditSpoolPath()
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (m *APIKeyManager) replayAuditSpoolLocked() error {
+	entries, err := m.readAuditSpool()
+	if err != nil {
+		return err
+	}
+	m.audit.pending.Store(int64(len(entries)))
+	if len(entries) == 0 {
+		return nil
+	}
+
+	replayed := 0
+	for _, entry := range entries {
+		if err = m.insertAuditLocked(entry); err != nil {
+			break
+		}
+		replayed++
+	}
+
+	if replayed > 0 {
+		if rewriteErr := m.rewriteAuditSpool(entries[replayed:]); rewriteErr != nil {
+			return fmt.Errorf("failed to rewrite audit spool: %w", rewriteErr)
+		}
+		m.audit.pending.Store(int64(len(entries) - replayed))
+		m.Info("Replayed spooled audit entries", "component", "audit", "count", replayed)
+	}
+	if err != nil {
+		return fmt.Errorf("audit spool replay stopped with %d entries pending: %w", len(entries)-replayed, err)
+	}
+	return nil
+}
+
+func (m *APIKeyManager) auditSpoolReplayer() {
+	go func() {
+		ticker := time.NewTicker(auditSpoolReplayInterval)
+		defer ticker.Stop()
+
+		for {
+			m.audit.mu.Lock()
+			err := m.replayAuditSpoolLocked()
+			m.audit.mu.Unlock()
+			if err != nil && m.isMongoConnected() {
+				m.Warn("Audit spool replay failed", "component", "audit", "error", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (m *APIKeyManager) auditStats() AuditStats {
+	return AuditStats{
+		FailedWrites: m.audit.failed.Load(),
+		Spooled:      m.audit.pending.Load(),
+		Overflowed:   m.audit.overflowed.Load(),
+	}
+}
+
+type auditVerifier struct {
+	secret string
+	head   *AuditHead
+	status AuditChainStatus
+}
+
+func newAuditVerifier(secret string, head *AuditHead) *auditVerifier {
+	v := &auditVerifier{secret: secret, head: head, status: AuditChainStatus{Valid: true, LastHash: auditGenesisHash}}
+	if head != nil {
+		v.status.HeadSeq = head.Seq
+		if !hmac.Equal([]byte(head.computeSignature(secret)), []byte(head.Signature)) {
+			v.fail(head.Seq, "chain head signature is invalid")
+		}
+	}
+	return v
+}
+
+func (v *auditVerifier) fail(seq int64, reason string) {
+	v.status.Valid = false
+	v.status.InvalidSeq = seq
+	v.status.Reason = reason
+}
+
+func (v *auditVerifier) check(entry AuditEntry) bool {
+	if !v.status.Valid {
+		return false
+	}
+
+	expectedSeq := v.status.LastSeq + 1
+	switch {
+	case entry.Seq != expectedSeq:
+		v.fail(entry.Seq, fmt.Sprintf("sequence gap: expected %d, found %d", expectedSeq, entry.Seq))
+	case entry.PrevHash != v.status.LastHash:
+		v.fail(entry.Seq, "previous hash does not match the preceding entry")
+	case !hmac.Equal([]byte(entry.computeHash(v.secret)), []byte(entry.Hash)):
+		v.fail(entry.Seq, "entry hash does not match its contents")
+	case v.head != nil && entry.Seq == v.head.Seq && entry.Hash != v.head.Hash:
+		v.fail(entry.Seq, "entry hash does not match the recorded chain head")
+	}
+	if !v.status.Valid {
+		return false
+	}
+
+	v.status.Checked++
+	v.status.LastSeq = entry.Seq
+	v.status.LastHash = entry.Hash
+	return true
+}
+
+func (v *auditVerifier) result() AuditChainStatus {
+	if !v.status.Valid {
+		return v.status
+	}
+	switch {
+	case v.head == nil && v.status.Checked > 0:
+		v.fail(v.status.LastSeq, "chain head record is missing")
+	case v.head != nil && v.status.LastSeq < v.head.Seq:
+		v.fail(v.status.LastSeq+1, fmt.Sprintf("audit log truncated: chain ends at %d, head records %d", v.status.LastSeq, v.head.Seq))
+	}
+	return v.status
+}
+
+func (m *APIKeyManager) verifyAuditChain(ctx context.Context) (AuditChainStatus, error) {
+	head, err := m.loadAuditHeadAnchor(ctx)
+	if err != nil {
+		return AuditChainStatus{}, err
+	}
+	verifier := newAuditVerifier(m.config.AuditSecret, head)
+
+	cursor, err := m.auditCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return verifier.status, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry AuditEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return verifier.status, fmt.Errorf("failed to decode audit entry: %w", err)
+		}
+		if !verifier.check(entry) {
+			return verifier.result(), nil
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return verifier.status, fmt.Errorf("cursor error: %w", err)
+	}
+
+	return verifier.result(), nil
+}
+
+func (m *APIKeyManager) getAuditLogHandler(c *gin.Context) {
+	if !m.isMongoConnected() {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", nil)
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	filter := bson.M{}
+	for param, field := range map[string]string{
+		"actor":        "actor",
+		"action":       "action",
+		"resourceType": "resourceType",
+		"resourceId":   "resourceId",
+		"requestId":    "requestId",
+		"ip":           "ip",
+	} {
+		if value := strings.TrimSpace(c.Query(param)); value != "" {
+			filter[field] = value
+		}
+	}
+
+	timeRange := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lt"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			m.respondWithError(c, http.StatusBadRequest, fmt.Sprintf("Invalid '%s' timestamp", param), "INVALID_RANGE", err)
+			return
+		}
+		timeRange[op] = parsed.UTC()
+	}
+	if len(timeRange) > 0 {
+		filter["timestamp"] = timeRange
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 15*time.Second)
+	defer cancel()
+
+	totalCount, err := m.auditCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to count audit entries", "COUNT_FAILED", err)
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+
+	cursor, err := m.auditCollection.Find(ctx, filter, opts)
+	if err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to retrieve audit entries", "RETRIEVAL_FAILED", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var entries []AuditEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to decode audit entries", "DECODE_FAILED", err)
+		return
+	}
+
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+
+	c.JSON(http.StatusOK, ApiResponse{
+		Data: entries,
+		Pagination: &PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      totalCount,
+			TotalPages: int((totalCount + int64(limit) - 1) / int64(limit)),
+		},
+		Success:   true,
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+func (m *APIKeyManager) verifyAuditChainHandler(c *gin.Context) {
+	if !m.isMongoConnected() {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 5*time.Minute)
+	defer cancel()
+
+	status, err := m.verifyAuditChain(ctx)
+	if err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to verify audit chain", "AUDIT_VERIFY_FAILED", err)
+		return
+	}
+
+	if !status.Valid {
+		m.logMessage(c, "ERROR", "Audit chain verification failed", map[string]interface{}{
+			"component":  "audit",
+			"invalidSeq": status.InvalidSeq,
+			"reason":     status.Reason,
+			"userId":     c.GetString("userID"),
+		})
+	}
+
+	m.respondWithSuccess(c, status, "")
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAppendAuditSpoolsWhileStoreUnavailable(t *testing.T) {
+	m := &APIKeyManager{
+		config: &Config{LogJournalDir: t.TempDir()},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	for _, action := range []string{"key.create", "key.update", "key.delete"} {
+		if err := m.appendAudit(AuditEntry{Action: action}); err != nil {
+			t.Fatalf("appendAudit(%s) error = %v, want spooled", action, err)
+		}
+	}
+
+	if stats := m.auditStats(); stats.FailedWrites != 3 || stats.Spooled != 3 {
+		t.Fatalf("auditStats() = %+v, want 3 failed and 3 spooled", stats)
+	}
+
+	entries, err := m.readAuditSpool()
+	if err != nil {
+		t.Fatalf("readAuditSpool() error = %v", err)
+	}
+	if len(entries) != 3 || entries[0].Action != "key.create" || entries[2].Action != "key.delete" {
+		t.Fatalf("readAuditSpool() = %+v, want entries in write order", entries)
+	}
+
+	if err := m.rewriteAuditSpool(entries[2:]); err != nil {
+		t.Fatalf("rewriteAuditSpool() error = %v", err)
+	}
+	if entries, _ := m.readAuditSpool(); len(entries) != 1 || entries[0].Action != "key.delete" {
+		t.Fatalf("spool after rewrite = %+v, want only the unreplayed entry", entries)
+	}
+}
+
+func buildAuditChain(secret string, n int) ([]AuditEntry, *AuditHead) {
+	entries := make([]AuditEntry, 0, n)
+	prev := auditGenesisHash
+	for i := 1; i <= n; i++ {
+		entry := AuditEntry{
+			Seq:       int64(i),
+			Timestamp: time.Date(2026, 1, 1, 0, 0, i, 0, time.UTC),
+			Actor:     "admin",
+			Action:    AuditActionKeyUpdate,
+			Changes:   []AuditFieldChange{},
+			PrevHash:  prev,
+		}
+		entry.Hash = entry.computeHash(secret)
+		prev = entry.Hash
+		entries = append(entries, entry)
+	}
+
+	head := &AuditHead{ID: auditHeadID, Seq: int64(n), Hash: prev}
+	head.Signature = head.computeSignature(secret)
+	return entries, head
+}
+
+func verifyEntries(secret string, head *AuditHead, entries []AuditEntry) AuditChainStatus {
+	v := newAuditVerifier(secret, head)
+	for _, entry := range entries {
+		if !v.check(entry) {
+			break
+		}
+	}
+	return v.result()
+}
+
+func TestAuditVerifierDetectsTampering(t *testing.T) {
+	const secret = "audit-secret"
+
+	tests := []struct {
+		name       string
+		mutate     func(entries []AuditEntry, head *AuditHead) ([]AuditEntry, *AuditHead)
+		wantValid  bool
+		wantInvSeq int64
+	}{
+		{
+			name: "intact chain",
+			mutate: func(entries []AuditEntry, head *AuditHead) ([]AuditEntry, *AuditHead) {
+				return entries, head
+			},
+			wantValid: true,
+		},
+		{
+			name: "rewrite rehashed without the secret",
+			mutate: func(entries []AuditEntry, head *AuditHead) ([]AuditEntry, *AuditHead) {
+				entries[1].Actor = "mallory"
+				entries[1].Hash = entries[1].computeHash("")
+				return entries, head
+			},
+			wantInvSeq: 2,
+		},
+		{
+			name: "tail truncated",
+			mutate: func(entries []AuditEntry, head *AuditHead) ([]AuditEntry, *AuditHead) {
+				return entries[:3], head
+			},
+			wantInvSeq: 4,
+		},
+		{
+			name: "head rolled back without the secret",
+			mutate: func(entries []AuditEntry, head *AuditHead) ([]AuditEntry, *AuditHead) {
+				head.Seq = 3
+				head.Hash = entries[2].Hash
+				return entries[:3], head
+			},
+			wantInvSeq: 3,
+		},
+		{
+			name: "head removed",
+			mutate: func(entries []AuditEntry, head *AuditHead) ([]AuditEntry, *AuditHead) {
+				return entries, nil
+			},
+			wantInvSeq: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, head := buildAuditChain(secret, 5)
+			entries, head = tt.mutate(entries, head)
+
+			status := verifyEntries(secret, head, entries)
+			if status.Valid != tt.wantValid {
+				t.Fatalf("verify() valid = %v (%s), want %v", status.Valid, status.Reason, tt.wantValid)
+			}
+			if !tt.wantValid && status.InvalidSeq != tt.wantInvSeq {
+				t.Fatalf("verify() invalidSeq = %d (%s), want %d", status.InvalidSeq, status.Reason, tt.wantInvSeq)
+			}
+		})
+	}
+}
+
+func TestAuditVerifierEmptyChainWithoutHead(t *testing.T) {
+	if status := verifyEntries("secret", nil, nil); !status.Valid {
+		t.Fatalf("verify() = %+v, want an empty chain to be valid", status)
+	}
+}
+
+func TestRecordLoginFailureAggregatesPerAddress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := &APIKeyManager{
+		config: &Config{LogJournalDir: t.TempDir()},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	m.audit.queue = make(chan AuditEntry, 16)
+
+	fail := func(ip string) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", "/api/auth/login", nil)
+		c.Request.RemoteAddr = ip + ":1234"
+		m.recordLoginFailure(c)
+	}
+
+	for i := 0; i < 50; i++ {
+		fail("10.0.0.1")
+	}
+	fail("10.0.0.2")
+
+	if got := len(m.audit.queue); got != 2 {
+		t.Fatalf("queued audit entries = %d, want one per address", got)
+	}
+
+	entries := m.flushLoginFailures(time.Now().Add(auditLoginFailureWindow), false)
+	if len(entries) != 1 || entries[0].IP != "10.0.0.1" {
+		t.Fatalf("flushLoginFailures() = %+v, want one aggregate for 10.0.0.1", entries)
+	}
+	if got := string(entries[0].Changes[0].After); got != "49" {
+		t.Fatalf("suppressedAttempts = %s, want 49", got)
+	}
+	if len(m.audit.failures) != 0 {
+		t.Fatalf("failures after flush = %d, want expired windows removed", len(m.audit.failures))
+	}
+}
+
+func TestRecordKeyAuditIdentifiesKeyByRef(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := &APIKeyManager{config: &Config{KeyRefSecret: "audit-secret"}}
+	m.audit.queue = make(chan AuditEntry, 2)
+	a := "sk_live_aaaa0000000000001234"
+	b := "sk_live_aaaa9999999999991234"
+
+	for _, keyID := range []string{a, b} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodDelete, "/api/keys/"+keyID, nil)
+		m.recordKeyAudit(c, AuditActionKeyDelete, keyID, nil)
+	}
+
+	first, second := <-m.audit.queue, <-m.audit.queue
+	if first.ResourceType != "apiKey" || first.ResourceID != m.keyRef(a) || first.ResourceLabel != maskAPIKey(a) {
+		t.Fatalf("audit entry = %+v, want resourceId %s labelled %s", first, m.keyRef(a), maskAPIKey(a))
+	}
+	if first.ResourceLabel != second.ResourceLabel || first.ResourceID == second.ResourceID {
+		t.Fatalf("keys sharing a masked form got resourceIds %q and %q, want distinct", first.ResourceID, second.ResourceID)
+	}
+}
+
+func TestEnqueueAuditWritesSynchronouslyWhenQueueFull(t *testing.T) {
+	m := &APIKeyManager{
+		config: &Config{LogJournalDir: t.TempDir()},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	m.audit.queue = make(chan AuditEntry, 1)
+
+	m.enqueueAudit(AuditEntry{Action: AuditActionKeyCreate})
+	m.enqueueAudit(AuditEntry{Action: AuditActionKeyDelete})
+
+	if got := len(m.audit.queue); got != 1 {
+		t.Fatalf("queued audit entries = %d, want 1", got)
+	}
+	stats := m.auditStats()
+	if stats.Overflowed != 1 || stats.Spooled != 1 {
+		t.Fatalf("auditStats() = %+v, want the overflowing entry spooled rather than dropped", stats)
+	}
+
+	spooled, err := m.readAuditSpool()
+	if err != nil {
+		t.Fatalf("readAuditSpool() error = %v", err)
+	}
+	if len(spooled) != 1 || spooled[0].Action != AuditActionKeyDelete {
+		t.Fatalf("spooled entries = %+v, want the key.delete entry", spooled)
+	}
+}
+
+func TestUpdateAPIKeyFailedSaveLeavesCachedKeyUnchanged(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := &APIKeyManager{
+		ctx:    context.Background(),
+		cache:  &Cache{},
+		config: &Config{MongoURI: "invalid://", LogJournalDir: t.TempDir()},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	m.cache.SetAPIKey(&APIKey{ID: "key-1", Name: "original", RPM: 10, Scopes: []string{"keys:read"}, IsActive: true})
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Params = gin.Params{{Key: "id", Value: "key-1"}}
+	c.Request = httptest.NewRequest(http.MethodPut, "/api/keys/key-1", strings.NewReader(`{"name":"renamed","rpm":99,"isActive":false}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	m.updateAPIKeyHandler(c)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d when the save fails", rec.Code, http.StatusInternalServerError)
+	}
+	cached, ok := m.cache.GetAPIKey("key-1")
+	if !ok {
+		t.Fatal("cached key missing after failed update")
+	}
+	if cached.Name != "original" || cached.RPM != 10 || !cached.IsActive {
+		t.Fatalf("cached key = %+v, want it unchanged after a failed save", cached)
+	}
+	if len(m.audit.queue) != 0 || m.auditStats().Spooled != 0 {
+		t.Fatal("failed update must not be audited")
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	wsAuthProtocol = "bearer"
+
+	CloseAuthFailed   = 4001
+	CloseTokenExpired = 4002
+	CloseTokenRevoked = 4003
+
+	tokenRevocationSyncInterval = 30 * time.Second
+
+	eventsTokenScope = "events"
+	eventsTokenTTL   = time.Minute
+)
+
+var errTokenRevoked = errors.New("token has been revoked")
+
+type RevokedToken struct {
+	TokenID   string    `bson:"_id" json:"tokenId"`
+	UserID    string    `bson:"userId" json:"userId"`
+	RevokedAt time.Time `bson:"revokedAt" json:"revokedAt"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
+type TokenRevocations struct {
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+}
+
+func NewTokenRevocations() *TokenRevocations {
+	return &TokenRevocations{revoked: make(map[string]time.Time)}
+}
+
+func (r *TokenRevocations) Add(tokenID string, expiresAt time.Time) {
+	r.mu.Lock()
+	r.revoked[tokenID] = expiresAt
+	r.mu.Unlock()
+}
+
+func (r *TokenRevocations) IsRevoked(tokenID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.revoked[tokenID]
+	return ok
+}
+
+func (r *TokenRevocations) Prune(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for tokenID, expiresAt := range r.revoked {
+		if now.After(expiresAt) {
+			delete(r.revoked, tokenID)
+		}
+	}
+}
+
+func (m *APIKeyManager) parseToken(tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parsedToken, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(m.config.JWTSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !parsedToken.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if jti, _ := claims["jti"].(string); jti != "" && m.revocations.IsRevoked(jti) {
+		return nil, errTokenRevoked
+	}
+	return claims, nil
+}
+
+func (m *APIKeyManager) parseSessionToken(tokenString string) (jwt.MapClaims, error) {
+	claims, err := m.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if scope, _ := claims["scope"].(string); scope != "" {
+		return nil, fmt.Errorf("%s token cannot be used as a session token", scope)
+	}
+	return claims, nil
+}
+
+func claimsExpiry(claims jwt.MapClaims) time.Time {
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return time.Time{}
+	}
+	return exp.Time.UTC()
+}
+
+func (m *APIKeyManager) revokeToken(claims jwt.MapClaims) error {
+	jti, _ := claims["jti"].(string)
+	userID, _ := claims["sub"].(string)
+	return m.revokeTokenID(jti, userID, claimsExpiry(claims))
+}
+
+func (m *APIKeyManager) revokeTokenID(jti, userID string, expiresAt time.Time) error {
+	if jti == "" {
+		return errors.New("token has no identifier")
+	}
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().UTC().Add(24 * time.Hour)
+	}
+
+	m.revocations.Add(jti, expiresAt)
+	m.closeClientsForToken(jti, CloseTokenRevoked, "token revoked")
+
+	if !m.isMongoConnected() {
+		return nil
+	}
+	return m.withRetry("revokeToken", func() error {
+		ctx, cancel := context.WithTimeout(m.ctx, 5*time.Second)
+		defer cancel()
+
+		_, err := m.revocationsCollection.ReplaceOne(ctx, bson.M{"_id": jti}, RevokedToken{
+			TokenID:   jti,
+			UserID:    userID,
+			RevokedAt: time.Now().UTC(),
+			ExpiresAt: expiresAt,
+		}, options.Replace().SetUpsert(true))
+		return err
+	})
+}
+
+func (m *APIKeyManager) loadRevokedTokens() error {
+	ctx, cancel := context.WithTimeout(m.ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := m.revocationsCollection.Find(ctx, bson.M{"expiresAt": bson.M{"$gt": time.Now().UTC()}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var tokens []RevokedToken
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if !m.revocations.IsRevoked(token.TokenID) {
+			m.revocations.Add(token.TokenID, token.ExpiresAt)
+			m.closeClientsForToken(token.TokenID, CloseTokenRevoked, "token revoked")
+		}
+	}
+	return nil
+}
+
+func (m *APIKeyManager) tokenRevocationJob() {
+	go func() {
+		ticker := time.NewTicker(tokenRevocationSyncInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.revocations.Prune(time.Now().UTC())
+				m.sessions.Prune(time.Now().UTC())
+				if m.isMongoConnected() {
+					if err := m.loadRevokedTokens(); err != nil {
+						m.Warn("Failed to sync revoked tokens", "component", "auth", "error", err)
+					}
+				}
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (m *APIKeyManager) logoutHandler(c *gin.Context) {
+	claims, _ := c.Get("claims")
+	tokenClaims, ok := claims.(jwt.MapClaims)
+	if !ok {
+		m.respondWithError(c, http.StatusUnauthorized, "Invalid or expired token", "AUTH_INVALID", nil)
+		return
+	}
+
+	if err := m.revokeToken(tokenClaims); err != nil {
+		m.ErrorContext(c, "Failed to revoke token", "error", err)
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to revoke token", "REVOKE_FAILED", err)
+		return
+	}
+
+	jti, _ := tokenClaims["jti"].(string)
+	m.recordAudit(c, AuditActionLogout, "session", jti, nil)
+	m.InfoContext(c, "User logout", "ip", c.ClientIP())
+
+	m.respondWithSuccess(c, nil, "Logged out")
+}
+
+// issueEventsTokenHandler hands out a short-lived token that EventSource
+// clients, which cannot set headers, pass to /events as a query parameter.
+func (m *APIKeyManager) issueEventsTokenHandler(c *gin.Context) {
+	claims, _ := c.Get("claims")
+	sessionClaims, ok := claims.(jwt.MapClaims)
+	if !ok {
+		m.respondWithError(c, http.StatusUnauthorized, "Invalid or expired token", "AUTH_INVALID", nil)
+		return
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(eventsTokenTTL)
+	if sessionExpiry := claimsExpiry(sessionClaims); !sessionExpiry.IsZero() && sessionExpiry.Before(expiresAt) {
+		expiresAt = sessionExpiry
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   sessionClaims["sub"],
+		"jti":   sessionClaims["jti"],
+		"scope": eventsTokenScope,
+		"sexp":  sessionClaims["exp"],
+		"iat":   now.Unix(),
+		"exp":   expiresAt.Unix(),
+	})
+	tokenString, err := token.SignedString([]byte(m.config.JWTSecret))
+	if err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to generate events token", "TOKEN_ERROR", err)
+		return
+	}
+
+	m.respondWithSuccess(c, gin.H{"token": tokenString, "expiresAt": expiresAt}, "")
+}
+
+// redactQuery returns the request query with token values masked, for
+// logging.
+func redactQuery(u *url.URL) string {
+	if u.RawQuery == "" {
+		return ""
+	}
+	query := u.Query()
+	if _, ok := query["token"]; ok {
+		query.Set("token", auditRedacted)
+	}
+	return query.Encode()
+}
+
+// eventsAuthMiddleware accepts the regular Authorization header or an events
+// token in the query string, and only on the /events route. The query token
+// is removed from the request URL once read, and request logs mask it, so it
+// does not reach log lines written after this point.
+func (m *APIKeyManager) eventsAuthMiddleware() gin.HandlerFunc {
+	headerAuth := m.authMiddleware()
+
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token != "" {
+			query := c.Request.URL.Query()
+			query.Del("token")
+			c.Request.URL.RawQuery = query.Encode()
+		}
+		if c.GetHeader("Authorization") != "" || token == "" {
+			headerAuth(c)
+			return
+		}
+
+		claims, err := m.parseToken(token)
+		if err != nil {
+			m.respondWithError(c, http.StatusUnauthorized, "Invalid or expired token", "AUTH_INVALID", err)
+			c.Abort()
+			return
+		}
+		if scope, _ := claims["scope"].(string); scope != eventsTokenScope {
+			m.respondWithError(c, http.StatusUnauthorized, "Invalid or expired token", "AUTH_INVALID", errors.New("query token is not an events token"))
+			c.Abort()
+			return
+		}
+
+		sessionClaims := jwt.MapClaims{"sub": claims["sub"], "jti": claims["jti"]}
+		if exp, ok := claims["sexp"]; ok {
+			sessionClaims["exp"] = exp
+		}
+		c.Set("claims", sessionClaims)
+		c.Set("userID", claims["sub"])
+		c.Next()
+	}
+}
+
+func (m *APIKeyManager) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if len(m.config.WSAllowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		if err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+	}
+	for _, allowed := range m.config.WSAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	m.Warn("WebSocket origin rejected", "origin", origin, "host", r.Host)
+	return false
+}
+
+func websocketProtocolToken(r *http.Request) string {
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == wsAuthProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+func (m *APIKeyManager) readWebSocketAuth(conn *websocket.Conn) (jwt.MapClaims, error) {
+	conn.SetReadDeadline(time.Now().Add(time.Duration(m.config.WSAuthTimeout) * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("no auth message received: %w", err)
+	}
+
+	var msg WSClientMessage
+	if err := json.Unmarshal(message, &msg); err != nil || msg.Type != "auth" || msg.Token == "" {
+		return nil, errors.New("first message must be an auth message")
+	}
+	return m.parseSessionToken(msg.Token)
+}
+
+func (wsc *WSClient) authorize(claims jwt.MapClaims, onExpire func()) {
+	wsc.userID, _ = claims["sub"].(string)
+	wsc.tokenID, _ = claims["jti"].(string)
+	wsc.expiresAt = claimsExpiry(claims)
+
+	if !wsc.expiresAt.IsZero() {
+		wsc.expiryTimer = time.AfterFunc(time.Until(wsc.expiresAt), onExpire)
+	}
+}
+
+func (m *APIKeyManager) authorizeClient(wsClient *WSClient, claims jwt.MapClaims) {
+	wsClient.authorize(claims, func() {
+		m.Info("Closing client with expired token", "clientId", wsClient.clientID, "transport", wsClient.transport)
+		m.unregisterClient(wsClient)
+		wsClient.CloseWith(CloseTokenExpired, "token expired")
+	})
+}
+
+func (m *APIKeyManager) closeClientsForToken(tokenID string, code int, reason string) {
+	m.wsClients.Range(func(key, value interface{}) bool {
+		wsClient, ok := value.(*WSClient)
+		if ok && wsClient.tokenID == tokenID {
+			m.Info("Closing client with revoked token", "clientId", key, "transport", wsClient.transport)
+			m.unregisterClient(wsClient)
+			wsClient.CloseWith(code, reason)
+		}
+		return true
+	})
+}
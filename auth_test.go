@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func newAuthTestManager() *APIKeyManager {
+	gin.SetMode(gin.TestMode)
+	return &APIKeyManager{
+		config:      &Config{JWTSecret: "test-secret", WSAuthTimeout: 1},
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		revocations: NewTokenRevocations(),
+	}
+}
+
+func signTestToken(t *testing.T, m *APIKeyManager, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(m.config.JWTSecret))
+	if err != nil {
+		t.Fatalf("SignedString() error = %v", err)
+	}
+	return token
+}
+
+func sessionTestToken(t *testing.T, m *APIKeyManager, jti string) string {
+	return signTestToken(t, m, jwt.MapClaims{
+		"sub": "admin",
+		"jti": jti,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+}
+
+func TestEventsTokenAuthorizesOnlyTheEventsRoute(t *testing.T) {
+	m := newAuthTestManager()
+
+	router := gin.New()
+	api := router.Group("/api", m.authMiddleware())
+	api.POST("/events/token", m.issueEventsTokenHandler)
+	api.GET("/keys", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	router.GET("/events", m.eventsAuthMiddleware(), func(c *gin.Context) {
+		claims := c.MustGet("claims").(jwt.MapClaims)
+		c.JSON(http.StatusOK, claims)
+	})
+
+	session := sessionTestToken(t, m, "session-1")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/events/token", nil)
+	req.Header.Set("Authorization", "Bearer "+session)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /events/token status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var issued struct {
+		Data struct {
+			Token     string    `json:"token"`
+			ExpiresAt time.Time `json:"expiresAt"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &issued); err != nil {
+		t.Fatalf("decode token response: %v", err)
+	}
+	if ttl := time.Until(issued.Data.ExpiresAt); ttl <= 0 || ttl > eventsTokenTTL {
+		t.Fatalf("events token expires in %v, want within %v", ttl, eventsTokenTTL)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events?token="+url.QueryEscape(issued.Data.Token), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /events?token= status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var claims map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &claims)
+	if claims["jti"] != "session-1" || claims["scope"] != nil {
+		t.Fatalf("events claims = %v, want the session identity without the events scope", claims)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		header string
+	}{
+		{name: "session token in query", target: "/events?token=" + url.QueryEscape(session)},
+		{name: "events token on another route", target: "/api/keys", header: "Bearer " + issued.Data.Token},
+		{name: "no credentials", target: "/events"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", tt.name, rec.Code)
+		}
+	}
+
+	m.revocations.Add("session-1", time.Now().Add(time.Hour))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events?token="+url.QueryEscape(issued.Data.Token), nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("events token of a revoked session: status = %d, want 401", rec.Code)
+	}
+}
+
+func TestEventsAuthRemovesQueryTokenFromRequest(t *testing.T) {
+	m := newAuthTestManager()
+	token := signTestToken(t, m, jwt.MapClaims{
+		"sub":   "admin",
+		"jti":   "session-1",
+		"scope": eventsTokenScope,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	})
+
+	var seen string
+	router := gin.New()
+	router.GET("/events", m.eventsAuthMiddleware(), func(c *gin.Context) {
+		seen = c.Request.URL.RawQuery
+		c.Status(http.StatusNoContent)
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events?topics=keys&token="+url.QueryEscape(token), nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204: %s", rec.Code, rec.Body)
+	}
+	if seen != "topics=keys" {
+		t.Fatalf("handler query = %q, want the token removed", seen)
+	}
+}
+
+func TestRedactQueryMasksToken(t *testing.T) {
+	u, _ := url.Parse("/events?token=secret-value&topics=keys")
+	got := redactQuery(u)
+	if strings.Contains(got, "secret-value") || !strings.Contains(got, "topics=keys") {
+		t.Fatalf("redactQuery() = %q, want the token masked and other params kept", got)
+	}
+	if got := redactQuery(&url.URL{Path: "/events"}); got != "" {
+		t.Fatalf("redactQuery() without a query = %q, want empty", got)
+	}
+}
+
+func newWebSocketAuthTestServer(t *testing.T, allowedOrigins ...string) (*APIKeyManager, string) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	m := newAuthTestManager()
+	m.ctx = ctx
+	m.config.WSSendQueueSize = 16
+	m.config.WSWriteTimeout = 5
+	m.config.WSSlowConsumerPolicy = WSPolicyDisconnect
+	m.config.WSAllowedOrigins = allowedOrigins
+	m.tracing = &Tracing{tracer: noop.NewTracerProvider().Tracer(tracerName)}
+	m.metrics = NewMetrics()
+	m.eventChan = make(chan WSMessage, 64)
+	m.eventHistory = NewEventHistory(eventHistorySize)
+	m.upgrader = websocket.Upgrader{Subprotocols: []string{wsAuthProtocol}, CheckOrigin: m.checkWebSocketOrigin}
+
+	router := gin.New()
+	router.GET("/ws", m.wsHandler)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return m, "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+func dialTestWebSocket(t *testing.T, wsURL string, protocols []string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: protocols, HandshakeTimeout: 5 * time.Second}
+	conn, resp, err := dialer.Dial(wsURL, header)
+	if conn != nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+// readUntilClose reads messages until the server closes the connection and
+// returns the close code, or -1 when the connection ends some other way.
+func readUntilClose(t *testing.T, conn *websocket.Conn, timeout time.Duration) int {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				return closeErr.Code
+			}
+			t.Logf("read ended without a close frame: %v", err)
+			return -1
+		}
+	}
+}
+
+func expectAuthenticated(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg WSMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON() error = %v, want an authenticated message", err)
+		}
+		if msg.Type == "authenticated" {
+			return
+		}
+	}
+}
+
+func TestWebSocketSubprotocolAuth(t *testing.T) {
+	m, wsURL := newWebSocketAuthTestServer(t)
+
+	conn, resp, err := dialTestWebSocket(t, wsURL, []string{wsAuthProtocol, sessionTestToken(t, m, "session-1")}, nil)
+	if err != nil {
+		t.Fatalf("Dial() with bearer subprotocol error = %v", err)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != wsAuthProtocol || conn.Subprotocol() != wsAuthProtocol {
+		t.Fatalf("negotiated subprotocol = %q, want %q echoed", got, wsAuthProtocol)
+	}
+	expectAuthenticated(t, conn)
+
+	_, resp, err = dialTestWebSocket(t, wsURL, []string{wsAuthProtocol, "not-a-jwt"}, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Dial() with an invalid token = %v, want 401", err)
+	}
+}
+
+func TestWebSocketFirstMessageAuth(t *testing.T) {
+	m, wsURL := newWebSocketAuthTestServer(t)
+
+	tests := []struct {
+		name      string
+		message   interface{}
+		wantClose int
+	}{
+		{name: "auth message", message: WSClientMessage{Type: "auth", Token: sessionTestToken(t, m, "session-1")}},
+		{name: "timeout", wantClose: CloseAuthFailed},
+		{name: "not an auth message", message: WSClientMessage{Type: "ping"}, wantClose: CloseAuthFailed},
+		{name: "invalid token", message: WSClientMessage{Type: "auth", Token: "not-a-jwt"}, wantClose: CloseAuthFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, _, err := dialTestWebSocket(t, wsURL, nil, nil)
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			if tt.message != nil {
+				if err := conn.WriteJSON(tt.message); err != nil {
+					t.Fatalf("WriteJSON() error = %v", err)
+				}
+			}
+
+			if tt.wantClose == 0 {
+				expectAuthenticated(t, conn)
+				return
+			}
+			wait := time.Duration(m.config.WSAuthTimeout)*time.Second + 2*time.Second
+			if code := readUntilClose(t, conn, wait); code != tt.wantClose {
+				t.Fatalf("close code = %d, want %d", code, tt.wantClose)
+			}
+		})
+	}
+}
+
+func TestCheckWebSocketOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{name: "no origin", origin: "", want: true},
+		{name: "same host without allowlist", origin: "http://dashboard.example.com", want: true},
+		{name: "other host without allowlist", origin: "http://evil.example.com", want: false},
+		{name: "allowlisted", allowed: []string{"https://app.example.com/"}, origin: "https://app.example.com", want: true},
+		{name: "same host not in allowlist", allowed: []string{"https://app.example.com"}, origin: "http://dashboard.example.com", want: false},
+		{name: "wildcard", allowed: []string{"*"}, origin: "http://evil.example.com", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newAuthTestManager()
+			m.config.WSAllowedOrigins = tt.allowed
+			req := httptest.NewRequest(http.MethodGet, "http://dashboard.example.com/ws", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := m.checkWebSocketOrigin(req); got != tt.want {
+				t.Fatalf("checkWebSocketOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebSocketRejectsDisallowedOrigin(t *testing.T) {
+	m, wsURL := newWebSocketAuthTestServer(t)
+	protocols := []string{wsAuthProtocol, sessionTestToken(t, m, "session-1")}
+
+	_, resp, err := dialTestWebSocket(t, wsURL, protocols, http.Header{"Origin": {"http://evil.example.com"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Dial() from another origin = %v, want 403", err)
+	}
+
+	sameHost := "http://" + strings.TrimPrefix(strings.TrimSuffix(wsURL, "/ws"), "ws://")
+	conn, _, err := dialTestWebSocket(t, wsURL, protocols, http.Header{"Origin": {sameHost}})
+	if err != nil {
+		t.Fatalf("Dial() from the same host error = %v", err)
+	}
+	expectAuthenticated(t, conn)
+}
+
+func TestWebSocketClosesOnTokenExpiry(t *testing.T) {
+	m, wsURL := newWebSocketAuthTestServer(t)
+	token := signTestToken(t, m, jwt.MapClaims{
+		"sub": "admin",
+		"jti": "session-short",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(2 * time.Second).Unix(),
+	})
+
+	conn, _, err := dialTestWebSocket(t, wsURL, []string{wsAuthProtocol, token}, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	if code := readUntilClose(t, conn, 5*time.Second); code != CloseTokenExpired {
+		t.Fatalf("close code = %d, want %d", code, CloseTokenExpired)
+	}
+}
+
+func TestWebSocketClosesOnRevocation(t *testing.T) {
+	m, wsURL := newWebSocketAuthTestServer(t)
+	token := sessionTestToken(t, m, "session-revoked")
+
+	conn, _, err := dialTestWebSocket(t, wsURL, []string{wsAuthProtocol, token}, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	expectAuthenticated(t, conn)
+
+	if err := m.revokeTokenID("session-revoked", "admin", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("revokeTokenID() error = %v", err)
+	}
+	if code := readUntilClose(t, conn, 5*time.Second); code != CloseTokenRevoked {
+		t.Fatalf("close code = %d, want %d", code, CloseTokenRevoked)
+	}
+
+	if _, resp, err := dialTestWebSocket(t, wsURL, []string{wsAuthProtocol, token}, nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Dial() with a revoked token = %v, want 401", err)
+	}
+}
+
+func TestRejectingMiddlewareStopsTheChain(t *testing.T) {
+	m := newAuthTestManager()
+	eventsToken := signTestToken(t, m, jwt.MapClaims{
+		"sub":   "admin",
+		"jti":   "session-1",
+		"scope": eventsTokenScope,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	})
+
+	tests := []struct {
+		name          string
+		middleware    gin.HandlerFunc
+		method        string
+		authorization string
+		contentType   string
+		contentLength int64
+		wantStatus    int
+	}{
+		{name: "missing token", middleware: m.authMiddleware(), method: http.MethodGet, wantStatus: http.StatusUnauthorized},
+		{name: "invalid token", middleware: m.authMiddleware(), method: http.MethodGet, authorization: "Bearer not-a-jwt", wantStatus: http.StatusUnauthorized},
+		{name: "events token as session", middleware: m.authMiddleware(), method: http.MethodGet, authorization: "Bearer " + eventsToken, wantStatus: http.StatusUnauthorized},
+		{name: "wrong content type", middleware: m.validationMiddleware(), method: http.MethodPost, contentType: "text/plain", wantStatus: http.StatusBadRequest},
+		{name: "body too large", middleware: m.validationMiddleware(), method: http.MethodPost, contentType: "application/json", contentLength: 2 * 1024 * 1024, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			router := gin.New()
+			router.Handle(tt.method, "/api/keys", tt.middleware, func(c *gin.Context) {
+				reached = true
+				c.Status(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(tt.method, "/api/keys", strings.NewReader("{}"))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.contentLength > 0 {
+				req.ContentLength = tt.contentLength
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if reached {
+				t.Fatal("handler ran after the middleware rejected the request")
+			}
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	reached := false
+	router := gin.New()
+	router.GET("/api/keys", m.authMiddleware(), func(c *gin.Context) { reached = true })
+	req := httptest.NewRequest(http.MethodGet, "/api/keys", nil)
+	req.Header.Set("Authorization", "Bearer "+sessionTestToken(t, m, "session-1"))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if !reached {
+		t.Fatal("handler not reached with a valid session token")
+	}
+}
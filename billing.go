@@ -0,0 +1,618 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	ReportGroupKey = "key"
+	ReportGroupOrg = "org"
+
+	reportPeriodLayout = "2006-01"
+)
+
+var errReportNotClosed = errors.New("billing period has not ended yet")
+
+type UsageReportKeyLine struct {
+	KeyRef      string `bson:"keyRef" json:"keyRef"`
+	MaskedKey   string `bson:"maskedKey" json:"maskedKey"`
+	Name        string `bson:"name,omitempty" json:"name,omitempty"`
+	OrgID       string `bson:"orgId,omitempty" json:"orgId,omitempty"`
+	Plan        string `bson:"plan,omitempty" json:"plan,omitempty"`
+	UsageCounts `bson:",inline"`
+}
+
+type UsageReportOrgLine struct {
+	OrgID       string `bson:"orgId" json:"orgId"`
+	KeyCount    int    `bson:"keyCount" json:"keyCount"`
+	UsageCounts `bson:",inline"`
+}
+
+type UsageReport struct {
+	ID          string               `bson:"_id" json:"period"`
+	PeriodStart time.Time            `bson:"periodStart" json:"periodStart"`
+	PeriodEnd   time.Time            `bson:"periodEnd" json:"periodEnd"`
+	GeneratedAt time.Time            `bson:"generatedAt" json:"generatedAt"`
+	Finalized   bool                 `bson:"finalized" json:"finalized"`
+	FinalizedAt *time.Time           `bson:"finalizedAt,omitempty" json:"finalizedAt,omitempty"`
+	FinalizedBy string               `bson:"finalizedBy,omitempty" json:"finalizedBy,omitempty"`
+	Totals      UsageCounts          `bson:"totals" json:"totals"`
+	Keys        []UsageReportKeyLine `bson:"keys" json:"keys"`
+	Orgs        []UsageReportOrgLine `bson:"orgs" json:"orgs"`
+}
+
+// usageReportTotal is one key's period usage under a single attribution.
+// OrgID and Plan are nil for day buckets written before attribution was
+// recorded; those fall back to the key's current attribution.
+type usageReportTotal struct {
+	ID struct {
+		KeyID string  `bson:"keyId"`
+		OrgID *string `bson:"orgId"`
+		Plan  *string `bson:"plan"`
+	} `bson:"_id"`
+	Name        string `bson:"name"`
+	UsageCounts `bson:",inline"`
+}
+
+func (m *APIKeyManager) keyRef(keyID string) string {
+	mac := hmac.New(sha256.New, []byte(m.config.KeyRefSecret))
+	mac.Write([]byte(keyID))
+	return "kref_" + hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func parseReportPeriod(period string) (time.Time, time.Time, error) {
+	start, err := time.Parse(reportPeriodLayout, strings.TrimSpace(period))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid period '%s': expected format YYYY-MM", period)
+	}
+	start = start.UTC()
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+func (m *APIKeyManager) generateUsageReport(ctx context.Context, period string) (*UsageReport, error) {
+	start, end, err := parseReportPeriod(period)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"granularity": GranularityDay,
+			"start":       bson.M{"$gte": start, "$lt": end},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "start", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           bson.D{{Key: "keyId", Value: "$keyId"}, {Key: "orgId", Value: "$orgId"}, {Key: "plan", Value: "$plan"}},
+			"name":          bson.M{"$last": "$name"},
+			"allowed":       bson.M{"$sum": "$allowed"},
+			"rateLimited":   bson.M{"$sum": "$rateLimited"},
+			"quotaExceeded": bson.M{"$sum": "$quotaExceeded"},
+			"invalid":       bson.M{"$sum": "$invalid"},
+			"total":         bson.M{"$sum": "$total"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.keyId", Value: 1}, {Key: "_id.orgId", Value: 1}, {Key: "_id.plan", Value: 1}}}},
+	}
+
+	cursor, err := m.usageBucketsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate usage: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var totals []usageReportTotal
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, fmt.Errorf("failed to decode usage: %w", err)
+	}
+
+	return m.buildUsageReport(start, end, totals), nil
+}
+
+func (m *APIKeyManager) buildUsageReport(start, end time.Time, totals []usageReportTotal) *UsageReport {
+	report := &UsageReport{
+		ID:          start.Format(reportPeriodLayout),
+		PeriodStart: start,
+		PeriodEnd:   end,
+		GeneratedAt: time.Now().UTC(),
+		Keys:        []UsageReportKeyLine{},
+		Orgs:        []UsageReportOrgLine{},
+	}
+
+	orgs := make(map[string]*UsageReportOrgLine)
+	orgKeys := make(map[string]map[string]bool)
+	for _, usage := range totals {
+		line := UsageReportKeyLine{
+			KeyRef:      m.keyRef(usage.ID.KeyID),
+			MaskedKey:   maskAPIKey(usage.ID.KeyID),
+			Name:        usage.Name,
+			UsageCounts: usage.UsageCounts,
+		}
+		if usage.ID.OrgID != nil && usage.ID.Plan != nil {
+			line.OrgID = *usage.ID.OrgID
+			line.Plan = *usage.ID.Plan
+		} else if apiKey, exists := m.cache.GetAPIKey(usage.ID.KeyID); exists {
+			line.Name = apiKey.Name
+			line.OrgID = apiKey.OrgID
+			line.Plan = apiKey.Plan
+		}
+		report.Keys = append(report.Keys, line)
+		report.Totals.merge(line.UsageCounts)
+
+		org, ok := orgs[line.OrgID]
+		if !ok {
+			org = &UsageReportOrgLine{OrgID: line.OrgID}
+			orgs[line.OrgID] = org
+			orgKeys[line.OrgID] = make(map[string]bool)
+		}
+		if !orgKeys[line.OrgID][usage.ID.KeyID] {
+			orgKeys[line.OrgID][usage.ID.KeyID] = true
+			org.KeyCount++
+		}
+		org.merge(line.UsageCounts)
+	}
+
+	for _, org := range orgs {
+		report.Orgs = append(report.Orgs, *org)
+	}
+	sort.Slice(report.Orgs, func(i, j int) bool {
+		return report.Orgs[i].OrgID < report.Orgs[j].OrgID
+	})
+
+	return report
+}
+
+func (m *APIKeyManager) findUsageReport(ctx context.Context, period string) (*UsageReport, error) {
+	var report UsageReport
+	err := m.usageReportsCollection.FindOne(ctx, bson.M{"_id": period}).Decode(&report)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load usage report: %w", err)
+	}
+	return &report, nil
+}
+
+func (m *APIKeyManager) loadUsageReport(ctx context.Context, period string) (*UsageReport, error) {
+	start, _, err := parseReportPeriod(period)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := m.findUsageReport(ctx, start.Format(reportPeriodLayout))
+	if err != nil {
+		return nil, err
+	}
+	if snapshot != nil {
+		return snapshot, nil
+	}
+
+	if err := m.flushUsage(); err != nil && m.isMongoConnected() {
+		m.Warn("Usage flush before report failed", "error", err)
+	}
+	return m.generateUsageReport(ctx, period)
+}
+
+func (m *APIKeyManager) finalizeUsageReport(ctx context.Context, period, actor string) (*UsageReport, bool, error) {
+	start, end, err := parseReportPeriod(period)
+	if err != nil {
+		return nil, false, err
+	}
+	if time.Now().UTC().Before(end) {
+		return nil, false, errReportNotClosed
+	}
+
+	existing, err := m.findUsageReport(ctx, start.Format(reportPeriodLayout))
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		return existing, false, nil
+	}
+
+	if err := m.flushUsage(); err != nil && m.isMongoConnected() {
+		m.Warn("Usage flush before finalizing report failed", "error", err)
+	}
+
+	report, err := m.generateUsageReport(ctx, period)
+	if err != nil {
+		return nil, false, err
+	}
+
+	finalizedAt := time.Now().UTC()
+	report.Finalized = true
+	report.FinalizedAt = &finalizedAt
+	report.FinalizedBy = actor
+
+	if _, err := m.usageReportsCollection.InsertOne(ctx, report); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			existing, findErr := m.findUsageReport(ctx, report.ID)
+			if findErr != nil {
+				return nil, false, findErr
+			}
+			return existing, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to store usage report: %w", err)
+	}
+
+	return report, true, nil
+}
+
+func writeUsageReport(w io.Writer, report *UsageReport, format, group string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case "csv":
+		writer := csv.NewWriter(w)
+		if group == ReportGroupOrg {
+			writer.Write([]string{"period", "orgId", "keyCount", "allowed", "rateLimited", "quotaExceeded", "invalid", "total"})
+			for _, line := range report.Orgs {
+				writer.Write([]string{
+					report.ID,
+					line.OrgID,
+					strconv.Itoa(line.KeyCount),
+					strconv.FormatInt(line.Allowed, 10),
+					strconv.FormatInt(line.RateLimited, 10),
+					strconv.FormatInt(line.QuotaExceeded, 10),
+					strconv.FormatInt(line.Invalid, 10),
+					strconv.FormatInt(line.Total, 10),
+				})
+			}
+		} else {
+			writer.Write([]string{"period", "keyRef", "maskedKey", "name", "orgId", "plan", "allowed", "rateLimited", "quotaExceeded", "invalid", "total"})
+			for _, line := range report.Keys {
+				writer.Write([]string{
+					report.ID,
+					line.KeyRef,
+					line.MaskedKey,
+					line.Name,
+					line.OrgID,
+					line.Plan,
+					strconv.FormatInt(line.Allowed, 10),
+					strconv.FormatInt(line.RateLimited, 10),
+					strconv.FormatInt(line.QuotaExceeded, 10),
+					strconv.FormatInt(line.Invalid, 10),
+					strconv.FormatInt(line.Total, 10),
+				})
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("unsupported format '%s': supported formats are csv, json", format)
+	}
+}
+
+func parseReportOutput(format, group string) (string, string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		return "", "", fmt.Errorf("unsupported format '%s': supported formats are csv, json", format)
+	}
+
+	group = strings.ToLower(strings.TrimSpace(group))
+	if group == "" {
+		group = ReportGroupKey
+	}
+	if group != ReportGroupKey && group != ReportGroupOrg {
+		return "", "", fmt.Errorf("unsupported group '%s': supported groups are key, org", group)
+	}
+
+	return format, group, nil
+}
+
+func reportFinalizeChanges() []AuditFieldChange {
+	return []AuditFieldChange{
+		{Field: "finalized", Before: auditValue("finalized", false), After: auditValue("finalized", true)},
+	}
+}
+
+func (m *APIKeyManager) sendUsageReport(c *gin.Context, report *UsageReport, format, group string) {
+	if format == "csv" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=usage_%s_%s.csv", report.ID, group))
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := writeUsageReport(c.Writer, report, format, group); err != nil {
+			m.Error("Failed to write usage report", "period", report.ID, "error", err)
+		}
+		return
+	}
+
+	m.respondWithSuccess(c, report, "")
+}
+
+// respondWithReportStoreError reports a failed read or write of usage data.
+// The period is validated before the store is touched, so what is left is
+// either an outage (503) or a server-side failure (500).
+func (m *APIKeyManager) respondWithReportStoreError(c *gin.Context, message, code string, err error) {
+	if !m.isMongoConnected() || mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", err)
+		return
+	}
+	m.respondWithError(c, http.StatusInternalServerError, message, code, err)
+}
+
+func (m *APIKeyManager) getUsageReportHandler(c *gin.Context) {
+	if !m.isMongoConnected() {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", nil)
+		return
+	}
+
+	format, group, err := parseReportOutput(c.Query("format"), c.Query("group"))
+	if err != nil {
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_FORMAT", nil)
+		return
+	}
+
+	if _, _, err := parseReportPeriod(c.Param("period")); err != nil {
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_PERIOD", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 60*time.Second)
+	defer cancel()
+
+	report, err := m.loadUsageReport(ctx, c.Param("period"))
+	if err != nil {
+		m.respondWithReportStoreError(c, "Failed to build usage report", "REPORT_FAILED", err)
+		return
+	}
+
+	m.sendUsageReport(c, report, format, group)
+}
+
+func (m *APIKeyManager) finalizeUsageReportHandler(c *gin.Context) {
+	if !m.isMongoConnected() {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", nil)
+		return
+	}
+
+	if _, _, err := parseReportPeriod(c.Param("period")); err != nil {
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_PERIOD", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 60*time.Second)
+	defer cancel()
+
+	report, created, err := m.finalizeUsageReport(ctx, c.Param("period"), c.GetString("userID"))
+	if errors.Is(err, errReportNotClosed) {
+		m.respondWithError(c, http.StatusConflict, "Billing period has not ended yet", "PERIOD_OPEN", nil)
+		return
+	}
+	if err != nil {
+		m.respondWithReportStoreError(c, "Failed to finalize usage report", "FINALIZE_FAILED", err)
+		return
+	}
+
+	message := "Usage report already finalized"
+	if created {
+		message = "Usage report finalized"
+		m.recordAudit(c, AuditActionReportFinalize, "usageReport", report.ID, reportFinalizeChanges())
+		m.logMessage(c, "INFO", "Usage report finalized", map[string]interface{}{
+			"component": "billing",
+			"period":    report.ID,
+			"keys":      len(report.Keys),
+			"allowed":   report.Totals.Allowed,
+			"total":     report.Totals.Total,
+			"userId":    c.GetString("userID"),
+		})
+	}
+
+	m.respondWithSuccess(c, report, message)
+}
+
+func (m *APIKeyManager) listUsageReportsHandler(c *gin.Context) {
+	if !m.isMongoConnected() {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 15*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetProjection(bson.M{"keys": 0, "orgs": 0})
+
+	cursor, err := m.usageReportsCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to retrieve usage reports", "RETRIEVAL_FAILED", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var reports []UsageReport
+	if err := cursor.All(ctx, &reports); err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to decode usage reports", "DECODE_FAILED", err)
+		return
+	}
+
+	if reports == nil {
+		reports = []UsageReport{}
+	}
+
+	m.respondWithSuccess(c, reports, "")
+}
+
+const reportCLIActor = "cli"
+
+func reportFinalizeAuditEntry(period string) AuditEntry {
+	return AuditEntry{
+		Timestamp:    time.Now().UTC(),
+		Actor:        reportCLIActor,
+		Action:       AuditActionReportFinalize,
+		ResourceType: "usageReport",
+		ResourceID:   period,
+		Changes:      reportFinalizeChanges(),
+	}
+}
+
+// newReportManager builds only what the report command uses: a stderr
+// logger, the shared secrets and the Mongo collections it reads and writes.
+// Unlike NewAPIKeyManager it starts no background workers and opens no log
+// files, so it can run next to a live server.
+func newReportManager(config *Config) (*APIKeyManager, error) {
+	logger, err := newLogger(config, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	if err := resolveConfigSecrets(config, logger); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &APIKeyManager{
+		config: config,
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+		cache:  &Cache{},
+		usage:  NewUsageRecorder(),
+	}, nil
+}
+
+func (m *APIKeyManager) connectReportStore() error {
+	ctx, cancel := context.WithTimeout(m.ctx, 20*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(m.config.MongoURI).SetServerSelectionTimeout(15*time.Second))
+	if err != nil {
+		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	m.mongoClient = client
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		return fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+
+	db := client.Database(m.config.DatabaseName)
+	m.apiKeysCollection = db.Collection(m.config.ApiKeysCollection)
+	m.usageBucketsCollection = db.Collection(m.config.UsageBucketsCollection)
+	m.usageReportsCollection = db.Collection(m.config.UsageReportsCollection)
+	m.auditCollection = db.Collection(m.config.AuditCollection)
+	m.auditHeadCollection = db.Collection(m.config.AuditHeadCollection)
+	m.setMongoStatus(true)
+	return nil
+}
+
+func (m *APIKeyManager) closeReportManager() {
+	m.cancel()
+	if m.mongoClient == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := m.mongoClient.Disconnect(ctx); err != nil {
+		m.Warn("Error disconnecting from MongoDB", "error", err)
+	}
+}
+
+func runReportCommand(args []string) int {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	configPath := flags.String("config", "server.json", "path to the server config file")
+	period := flags.String("period", time.Now().UTC().AddDate(0, -1, 0).Format(reportPeriodLayout), "billing period (YYYY-MM)")
+	format := flags.String("format", "csv", "output format: csv or json")
+	group := flags.String("group", ReportGroupKey, "csv grouping: key or org")
+	output := flags.String("output", "", "output file (defaults to stdout)")
+	finalize := flags.Bool("finalize", false, "store an immutable snapshot of a closed period")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	outFormat, outGroup, err := parseReportOutput(*format, *group)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return 1
+	}
+
+	manager, err := newReportManager(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating report reader: %v\n", err)
+		return 1
+	}
+	defer manager.closeReportManager()
+
+	if err := manager.connectReportStore(); err != nil {
+		fmt.Fprintf(os.Stderr, "MongoDB connection failed: %v\n", err)
+		return 1
+	}
+
+	if err := manager.loadAPIKeysToCache(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load API keys: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	status := 0
+	var report *UsageReport
+	if *finalize {
+		var created bool
+		report, created, err = manager.finalizeUsageReport(ctx, *period, reportCLIActor)
+		if err == nil && !created {
+			fmt.Fprintf(os.Stderr, "Report for %s was already finalized at %s\n", report.ID, report.FinalizedAt.Format(time.RFC3339))
+		}
+		if err == nil && created {
+			if auditErr := manager.insertAudit(reportFinalizeAuditEntry(report.ID)); auditErr != nil {
+				fmt.Fprintf(os.Stderr, "Report for %s was finalized but the audit entry failed: %v\n", report.ID, auditErr)
+				status = 1
+			}
+		}
+	} else {
+		report, err = manager.loadUsageReport(ctx, *period)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to build usage report: %v\n", err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create output file: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := writeUsageReport(w, report, outFormat, outGroup); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write usage report: %v\n", err)
+		return 1
+	}
+
+	return status
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestKeyRefIsStableAndDistinct(t *testing.T) {
+	m := &APIKeyManager{config: &Config{KeyRefSecret: "billing-secret"}}
+	a := "sk_live_aaaa0000000000001234"
+	b := "sk_live_aaaa9999999999991234"
+
+	if maskAPIKey(a) != maskAPIKey(b) {
+		t.Fatal("test keys should share a masked form")
+	}
+	if m.keyRef(a) != m.keyRef(a) {
+		t.Fatal("keyRef() is not stable")
+	}
+	if m.keyRef(a) == m.keyRef(b) {
+		t.Fatal("keyRef() collides for keys with the same masked form")
+	}
+	if strings.Contains(m.keyRef(a), a) {
+		t.Fatal("keyRef() leaks the raw key")
+	}
+	other := &APIKeyManager{config: &Config{KeyRefSecret: "other-secret"}}
+	if other.keyRef(a) == m.keyRef(a) {
+		t.Fatal("keyRef() does not depend on the secret")
+	}
+}
+
+func TestWriteUsageReportCSVIncludesKeyRef(t *testing.T) {
+	report := &UsageReport{
+		ID: "2026-01",
+		Keys: []UsageReportKeyLine{{
+			KeyRef:      "kref_abc",
+			MaskedKey:   "sk_l****1234",
+			Name:        "ci",
+			UsageCounts: UsageCounts{Allowed: 3, Total: 4, Invalid: 1},
+		}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeUsageReport(&buf, report, "csv", ReportGroupKey); err != nil {
+		t.Fatalf("writeUsageReport() error = %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("csv parse error = %v", err)
+	}
+	if len(rows) != 2 || rows[0][1] != "keyRef" || rows[1][1] != "kref_abc" || rows[1][2] != "sk_l****1234" {
+		t.Fatalf("csv rows = %v, want keyRef then maskedKey columns", rows)
+	}
+}
+
+func TestBuildUsageReportUsesRecordedAttribution(t *testing.T) {
+	m := &APIKeyManager{cache: &Cache{}, config: &Config{KeyRefSecret: "billing-secret"}}
+	m.cache.SetAPIKey(&APIKey{ID: "moved", Name: "moved-now", OrgID: "org-b", Plan: "pro"})
+	m.cache.SetAPIKey(&APIKey{ID: "legacy", Name: "legacy-key", OrgID: "org-a", Plan: "free"})
+
+	total := func(keyID string, orgID, plan *string, name string, allowed int64) usageReportTotal {
+		usage := usageReportTotal{Name: name, UsageCounts: UsageCounts{Allowed: allowed, Total: allowed}}
+		usage.ID.KeyID = keyID
+		usage.ID.OrgID = orgID
+		usage.ID.Plan = plan
+		return usage
+	}
+	str := func(s string) *string { return &s }
+
+	start, end, _ := parseReportPeriod("2026-01")
+	report := m.buildUsageReport(start, end, []usageReportTotal{
+		total("deleted", str("org-a"), str("free"), "gone", 4),
+		total("legacy", nil, nil, "", 1),
+		total("moved", str("org-a"), str("free"), "moved-then", 2),
+		total("moved", str("org-b"), str("pro"), "moved-now", 3),
+	})
+
+	want := []struct{ name, orgID, plan string }{
+		{"gone", "org-a", "free"},
+		{"legacy-key", "org-a", "free"},
+		{"moved-then", "org-a", "free"},
+		{"moved-now", "org-b", "pro"},
+	}
+	if len(report.Keys) != len(want) {
+		t.Fatalf("report keys = %+v, want %d lines", report.Keys, len(want))
+	}
+	for i, w := range want {
+		line := report.Keys[i]
+		if line.Name != w.name || line.OrgID != w.orgID || line.Plan != w.plan {
+			t.Fatalf("line %d = %+v, want %+v", i, line, w)
+		}
+	}
+
+	if report.Totals.Allowed != 10 {
+		t.Fatalf("totals.allowed = %d, want 10", report.Totals.Allowed)
+	}
+	if len(report.Orgs) != 2 || report.Orgs[0].KeyCount != 3 || report.Orgs[0].Allowed != 7 || report.Orgs[1].KeyCount != 1 || report.Orgs[1].Allowed != 3 {
+		t.Fatalf("orgs = %+v, want org-a with 3 keys/7 allowed and org-b with 1 key/3 allowed", report.Orgs)
+	}
+}
+
+func TestUsageReportHandlerStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	storeFailed := mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 8000, Message: "internal failure"})
+	networkFailed := mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 6, Message: "host unreachable", Labels: []string{"NetworkError"}})
+
+	tests := []struct {
+		name      string
+		period    string
+		responses []bson.D
+		want      int
+	}{
+		{name: "invalid period", period: "2026-13", want: http.StatusBadRequest},
+		{name: "store error", period: "2020-01", responses: []bson.D{storeFailed}, want: http.StatusInternalServerError},
+		{name: "store unreachable", period: "2020-01", responses: []bson.D{networkFailed, networkFailed}, want: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			m := &APIKeyManager{
+				ctx:                    context.Background(),
+				logger:                 slog.New(slog.NewTextHandler(io.Discard, nil)),
+				usageReportsCollection: mt.Coll,
+			}
+			m.setMongoStatus(true)
+			mt.AddMockResponses(tt.responses...)
+
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodGet, "/reports/"+tt.period, nil)
+			c.Params = gin.Params{{Key: "period", Value: tt.period}}
+			m.getUsageReportHandler(c)
+
+			if rec.Code != tt.want {
+				mt.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func logFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readLogFile(t *testing.T, path string) string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open(%s) error = %v", path, err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatalf("gzip.NewReader(%s) error = %v", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll(%s) error = %v", path, err)
+	}
+	return string(data)
+}
+
+func TestFileLoggerRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	fl, err := NewFileLogger(dir, 100, 10, 0, false)
+	if err != nil {
+		t.Fatalf("NewFileLogger() error = %v", err)
+	}
+
+	line := strings.Repeat("x", 59) + "\n"
+	for i := 0; i < 3; i++ {
+		if _, err := fl.Write([]byte(line)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := fl.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	files := logFiles(t, dir)
+	if len(files) != 3 {
+		t.Fatalf("log files = %v, want the active file and two rotated files", files)
+	}
+	var total int
+	for _, name := range files {
+		content := readLogFile(t, filepath.Join(dir, name))
+		if content != line {
+			t.Fatalf("%s = %q, want a single line per file", name, content)
+		}
+		total += len(content)
+	}
+	if total != 3*len(line) {
+		t.Fatalf("total bytes = %d, want %d", total, 3*len(line))
+	}
+}
+
+func TestFileLoggerRollsOverDailyAndCompresses(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2026, 5, 1, 23, 59, 0, 0, time.UTC)
+	fl := &FileLogger{maxSize: 1 << 20, maxFiles: 10, compress: true, logDir: dir, now: func() time.Time { return day }, done: make(chan struct{})}
+	if err := fl.openLogFile(); err != nil {
+		t.Fatalf("openLogFile() error = %v", err)
+	}
+
+	fl.Write([]byte("first day\n"))
+	day = day.Add(2 * time.Minute)
+	fl.Write([]byte("second day\n"))
+	if err := fl.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	want := []string{"app_2026-05-01.log.gz", "app_2026-05-02.log"}
+	if files := logFiles(t, dir); strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Fatalf("log files = %v, want %v", files, want)
+	}
+	if got := readLogFile(t, filepath.Join(dir, want[0])); got != "first day\n" {
+		t.Fatalf("compressed archive = %q, want the previous day's entries", got)
+	}
+	if got := readLogFile(t, filepath.Join(dir, want[1])); got != "second day\n" {
+		t.Fatalf("active file = %q, want the new day's entries", got)
+	}
+}
+
+func TestFileLoggerRetention(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		maxFiles int
+		maxAge   int
+		ages     map[string]time.Duration
+		want     []string
+	}{
+		{
+			name:     "count",
+			maxFiles: 2,
+			ages: map[string]time.Duration{
+				"app_2020-01-01.log":    4 * time.Hour,
+				"app_2020-01-02.log.gz": 3 * time.Hour,
+				"app_2020-01-03.log":    2 * time.Hour,
+				"app_2020-01-04.log.gz": time.Hour,
+			},
+			want: []string{"app_2020-01-03.log", "app_2020-01-04.log.gz"},
+		},
+		{
+			name:     "age",
+			maxFiles: 10,
+			maxAge:   2,
+			ages: map[string]time.Duration{
+				"app_2020-01-01.log.gz": 72 * time.Hour,
+				"app_2020-01-02.log.gz": 49 * time.Hour,
+				"app_2020-01-03.log.gz": 47 * time.Hour,
+			},
+			want: []string{"app_2020-01-03.log.gz"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, age := range tt.ages {
+				path := filepath.Join(dir, name)
+				if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+					t.Fatalf("WriteFile() error = %v", err)
+				}
+				os.Chtimes(path, now.Add(-age), now.Add(-age))
+			}
+
+			fl := &FileLogger{maxSize: 1 << 20, maxFiles: tt.maxFiles, maxAge: time.Duration(tt.maxAge) * 24 * time.Hour, logDir: dir, now: time.Now, done: make(chan struct{})}
+			if err := fl.openLogFile(); err != nil {
+				t.Fatalf("openLogFile() error = %v", err)
+			}
+			active := filepath.Base(fl.activeFile())
+			fl.cleanup()
+			if err := fl.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			var got []string
+			for _, name := range logFiles(t, dir) {
+				if name != active {
+					got = append(got, name)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("retained files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileLoggerCompressesWhileRotatingConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	fl, err := NewFileLogger(dir, 64, 100, 0, true)
+	if err != nil {
+		t.Fatalf("NewFileLogger() error = %v", err)
+	}
+
+	var written bytes.Buffer
+	line := strings.Repeat("y", 39) + "\n"
+	for i := 0; i < 50; i++ {
+		fl.Write([]byte(line))
+		written.WriteString(line)
+	}
+	if err := fl.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	var total int
+	for _, name := range logFiles(t, dir) {
+		if strings.HasSuffix(name, ".tmp") {
+			t.Fatalf("leftover temporary file %s", name)
+		}
+		total += len(readLogFile(t, filepath.Join(dir, name)))
+	}
+	if total != written.Len() {
+		t.Fatalf("bytes across log files = %d, want %d", total, written.Len())
+	}
+}
@@ -67,7 +67,16 @@ const Logs: React.FC = () => {
   
   const logsEndRef = useRef<HTMLDivElement>(null);
   const containerRef = useRef<HTMLDivElement>(null);
-  const { isConnected } = useWebSocket(handleWebSocketMessage);
+  const { isConnected, subscribeLogs, unsubscribeLogs } = useWebSocket(handleWebSocketMessage);
+
+  useEffect(() => {
+    if (!isConnected) return;
+    if (isStreaming) {
+      subscribeLogs();
+    } else {
+      unsubscribeLogs();
+    }
+  }, [isConnected, isStreaming, subscribeLogs, unsubscribeLogs]);
 
   const logLevels = ['all', 'INFO', 'WARN', 'ERROR', 'DEBUG'];
 
@@ -113,6 +113,10 @@ export const useAuth = create<AuthState>()(
         },
 
         logout: () => {
+          const currentToken = get().token;
+          if (currentToken) {
+            apiService.logout(currentToken).catch(() => undefined);
+          }
           clearAuthData();
           apiService.setAuthToken('');
           set({ isAuthenticated: false, token: null });
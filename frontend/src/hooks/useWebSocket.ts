@@ -1,6 +1,7 @@
 import { useEffect, useRef, useCallback, useState, useMemo } from 'react';
 import { useStore } from '../store/useStore';
-import { WSEvent, APIKey, LogEntry } from '../types';
+import apiService from '../services/api';
+import { WSEvent, APIKey, LogEntry, LogTailFilter, PresenceEvent, PresenceUser, KeyNotification } from '../types';
 
 interface WebSocketMetrics {
   totalConnections: number;
@@ -14,8 +15,22 @@ interface WebSocketMetrics {
 interface MessagePayload {
   type: string;
   timestamp: string;
+  filter?: LogTailFilter;
+  backlog?: number;
+  topics?: string[];
+  resumeFrom?: number;
+  epoch?: string;
 }
 
+const EVENT_TOPICS = ['keys', 'system', 'presence'];
+const SSE_EVENT_TYPES: WSEvent['type'][] = [
+  'key_created', 'key_updated', 'key_deleted', 'subscribed', 'resync_required',
+  'presence', 'notification', 'system_update', 'error'
+];
+
+const WS_AUTH_PROTOCOL = 'bearer';
+const AUTH_CLOSE_CODES = [4001, 4002, 4003, 4004];
+
 interface UseWebSocketOptions {
   maxReconnectAttempts?: number;
   reconnectDelay?: number;
@@ -66,6 +81,8 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
   const config = useMemo(() => ({ ...DEFAULT_OPTIONS, ...options }), [options]);
   
   const ws = useRef<WebSocket | null>(null);
+  const eventSource = useRef<EventSource | null>(null);
+  const wsOpened = useRef(false);
   const reconnectTimeoutRef = useRef<NodeJS.Timeout>();
   const heartbeatInterval = useRef<NodeJS.Timeout>();
   const connectionStartTime = useRef<number>(0);
@@ -73,9 +90,12 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
   const mountedRef = useRef(true);
   const isConnectingRef = useRef(false);
   const reconnectAttempts = useRef(0);
+  const lastSeq = useRef(0);
+  const eventEpoch = useRef<string | null>(null);
   
   const [isConnected, setIsConnected] = useState(false);
   const [connectionState, setConnectionState] = useState<'disconnected' | 'connecting' | 'connected' | 'reconnecting'>('disconnected');
+  const [onlineUsers, setOnlineUsers] = useState<PresenceUser[]>([]);
   const [metrics, setMetrics] = useState<WebSocketMetrics>({
     totalConnections: 0,
     totalReconnections: 0,
@@ -86,6 +106,7 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
   });
 
   const { 
+    setApiKeys,
     addApiKey, 
     updateApiKey, 
     removeApiKey, 
@@ -138,12 +159,56 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
           }
           break;
 
+        case 'log_backlog':
+          if (Array.isArray(event.data)) {
+            (event.data as LogEntry[]).forEach(entry => addLog(entry));
+          }
+          break;
+
+        case 'subscribed':
+          if (event.data && typeof event.data === 'object' && 'epoch' in event.data) {
+            const { epoch, seq } = event.data as { epoch: string; seq: number };
+            eventEpoch.current = epoch;
+            if (lastSeq.current === 0) {
+              lastSeq.current = seq;
+            }
+          }
+          break;
+
+        case 'unsubscribed':
+          break;
+
+        case 'resync_required':
+          if (event.data && typeof event.data === 'object' && 'seq' in event.data) {
+            const { epoch, seq } = event.data as { epoch: string; seq: number };
+            eventEpoch.current = epoch;
+            lastSeq.current = seq;
+          }
+          apiService.getKeys({ limit: 1000 }, false)
+            .then(response => setApiKeys(response.data || []))
+            .catch(error => console.error('Failed to resync keys:', error));
+          break;
+
         case 'system_update':
           if (event.data && typeof event.data === 'object' && 'message' in event.data) {
             showToast((event.data as { message: string }).message, 'success', 'system-update');
           }
           break;
 
+        case 'presence':
+          if (event.data && typeof event.data === 'object' && 'users' in event.data) {
+            setOnlineUsers((event.data as PresenceEvent).users);
+          }
+          break;
+
+        case 'notification':
+          if (event.data && typeof event.data === 'object' && 'message' in event.data) {
+            const notification = event.data as KeyNotification;
+            showToast(notification.message, notification.severity === 'error' ? 'error' : 'info', `notification-${notification.id}`);
+          }
+          break;
+
+        case 'authenticated':
         case 'pong':
           break;
 
@@ -170,7 +235,7 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
         lastError: `Message handling error: ${error}` 
       });
     }
-  }, [addApiKey, updateApiKey, removeApiKey, addLog, config.onMessage, metrics.totalMessages, metrics.totalErrors, updateMetrics, showToast]);
+  }, [setApiKeys, addApiKey, updateApiKey, removeApiKey, addLog, config.onMessage, metrics.totalMessages, metrics.totalErrors, updateMetrics, showToast]);
 
   const processMessageQueue = useCallback(() => {
     if (ws.current?.readyState === WebSocket.OPEN && messageQueue.current.length > 0) {
@@ -221,6 +286,11 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
     }
 
     stopHeartbeat();
+
+    if (eventSource.current) {
+      eventSource.current.close();
+      eventSource.current = null;
+    }
     
     if (ws.current) {
       const currentWs = ws.current;
@@ -251,6 +321,82 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
     isConnectingRef.current = false;
   }, [stopHeartbeat, updateConnectionStatus]);
 
+  const connectSSE = useCallback(async () => {
+    if (!mountedRef.current || eventSource.current) return;
+
+    const scheduleRetry = () => {
+      if (!mountedRef.current || reconnectAttempts.current >= config.maxReconnectAttempts) {
+        showToast('Connection lost. Please refresh the page.', 'error', 'ws-connection-lost');
+        return;
+      }
+      setConnectionState('reconnecting');
+      const delay = Math.min(config.reconnectDelay * Math.pow(2, reconnectAttempts.current), 30000);
+      reconnectTimeoutRef.current = setTimeout(() => {
+        reconnectAttempts.current++;
+        connectSSE();
+      }, delay);
+    };
+
+    try {
+      const { token } = await apiService.getEventsToken();
+      if (!mountedRef.current || eventSource.current) return;
+
+      const params = new URLSearchParams({ topics: EVENT_TOPICS.join(','), token });
+      if (eventEpoch.current && lastSeq.current > 0) {
+        params.set('lastEventId', `${eventEpoch.current}:${lastSeq.current}`);
+      }
+
+      const source = new EventSource(`/server/api/v1/events?${params.toString()}`);
+      eventSource.current = source;
+
+      const onEvent = (message: MessageEvent) => {
+        if (!mountedRef.current) return;
+        try {
+          const data = JSON.parse(message.data) as WSEvent;
+          if (typeof data.seq === 'number') {
+            if (data.seq <= lastSeq.current) {
+              return;
+            }
+            lastSeq.current = data.seq;
+          }
+          handleMessage(data);
+        } catch (error) {
+          console.error('SSE message parse error:', error);
+        }
+      };
+      SSE_EVENT_TYPES.forEach(type => source.addEventListener(type, onEvent as EventListener));
+
+      source.onopen = () => {
+        if (!mountedRef.current) return;
+        console.log('SSE connected');
+        reconnectAttempts.current = 0;
+        setIsConnected(true);
+        setConnectionState('connected');
+        updateConnectionStatus({ websocket: true });
+      };
+
+      source.onerror = () => {
+        if (!mountedRef.current || source.readyState !== EventSource.CLOSED) return;
+        // The events token in the URL is short-lived, so fetch a fresh one
+        // instead of letting the browser retry with the expired URL.
+        source.close();
+        if (eventSource.current === source) {
+          eventSource.current = null;
+        }
+        setIsConnected(false);
+        updateConnectionStatus({ websocket: false });
+        scheduleRetry();
+      };
+    } catch (error) {
+      console.error('SSE connection error:', error);
+      updateMetrics({
+        totalErrors: metrics.totalErrors + 1,
+        lastError: `SSE setup error: ${error}`
+      });
+      scheduleRetry();
+    }
+  }, [handleMessage, updateConnectionStatus, updateMetrics, metrics.totalErrors, showToast, config.maxReconnectAttempts, config.reconnectDelay]);
+
   const connect = useCallback(() => {
     if (!mountedRef.current) return;
     
@@ -272,17 +418,19 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
       setConnectionState('connecting');
 
       const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-      const wsUrl = `${protocol}//${window.location.host}/server/api/v1/ws?token=${encodeURIComponent(token)}`;
+      const wsUrl = `${protocol}//${window.location.host}/server/api/v1/ws`;
       
       console.log('Attempting WebSocket connection');
       
-      ws.current = new WebSocket(wsUrl);
+      wsOpened.current = false;
+      ws.current = new WebSocket(wsUrl, [WS_AUTH_PROTOCOL, token]);
       connectionStartTime.current = Date.now();
       
       ws.current.onopen = () => {
         if (!mountedRef.current) return;
         
         console.log('WebSocket connected successfully');
+        wsOpened.current = true;
         isConnectingRef.current = false;
         setIsConnected(true);
         setConnectionState('connected');
@@ -301,6 +449,18 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
         }
 
         startHeartbeat();
+
+        const subscription: MessagePayload = {
+          type: 'subscribe',
+          timestamp: new Date().toISOString(),
+          topics: EVENT_TOPICS
+        };
+        if (eventEpoch.current && lastSeq.current > 0) {
+          subscription.resumeFrom = lastSeq.current;
+          subscription.epoch = eventEpoch.current;
+        }
+        ws.current?.send(JSON.stringify(subscription));
+
         processMessageQueue();
 
         showToast('Real-time connection established', 'success', 'ws-connection');
@@ -311,6 +471,12 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
         
         try {
           const data = JSON.parse(event.data) as WSEvent;
+          if (typeof data.seq === 'number') {
+            if (data.seq <= lastSeq.current) {
+              return;
+            }
+            lastSeq.current = data.seq;
+          }
           handleMessage(data);
         } catch (error) {
           console.error('WebSocket message parse error:', error);
@@ -331,6 +497,18 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
         stopHeartbeat();
         updateConnectionStatus({ websocket: false });
         
+        if (AUTH_CLOSE_CODES.includes(event.code)) {
+          showToast(`Real-time connection closed: ${event.reason || 'session ended'}`, 'error', 'ws-connection');
+          return;
+        }
+
+        if (!wsOpened.current) {
+          console.log('WebSocket upgrade failed, falling back to SSE');
+          ws.current = null;
+          connectSSE();
+          return;
+        }
+
         if (event.code !== 1000 && reconnectAttempts.current < config.maxReconnectAttempts) {
           setConnectionState('reconnecting');
           const delay = Math.min(
@@ -384,7 +562,7 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
         lastError: `Connection setup error: ${error}` 
       });
     }
-  }, [handleMessage, cleanup, startHeartbeat, processMessageQueue, stopHeartbeat, updateConnectionStatus, metrics.totalConnections, metrics.totalErrors, metrics.totalReconnections, updateMetrics, showToast, config.maxReconnectAttempts, config.reconnectDelay]);
+  }, [handleMessage, cleanup, connectSSE, startHeartbeat, processMessageQueue, stopHeartbeat, updateConnectionStatus, metrics.totalConnections, metrics.totalErrors, metrics.totalReconnections, updateMetrics, showToast, config.maxReconnectAttempts, config.reconnectDelay]);
 
   const disconnect = useCallback(() => {
     console.log('Disconnecting WebSocket');
@@ -418,6 +596,24 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
     }
   }, [metrics.totalErrors, updateMetrics, config.maxQueueSize]);
 
+  const subscribeLogs = useCallback((filter: LogTailFilter = {}, backlog: number = 0) => {
+    return sendMessage({
+      type: 'subscribe',
+      timestamp: new Date().toISOString(),
+      topics: ['logs'],
+      filter,
+      backlog
+    });
+  }, [sendMessage]);
+
+  const unsubscribeLogs = useCallback(() => {
+    return sendMessage({
+      type: 'unsubscribe',
+      timestamp: new Date().toISOString(),
+      topics: ['logs']
+    });
+  }, [sendMessage]);
+
   const forceReconnect = useCallback(() => {
     console.log('Force reconnecting WebSocket');
     reconnectAttempts.current = 0;
@@ -540,9 +736,12 @@ export const useWebSocket = (options: UseWebSocketOptions = {}) => {
     isConnected,
     connectionState,
     sendMessage,
+    subscribeLogs,
+    unsubscribeLogs,
     reconnect: forceReconnect,
     disconnect,
     getConnectionStatus,
-    metrics
-  }), [isConnected, connectionState, sendMessage, forceReconnect, disconnect, getConnectionStatus, metrics]);
+    metrics,
+    onlineUsers
+  }), [isConnected, connectionState, sendMessage, subscribeLogs, unsubscribeLogs, forceReconnect, disconnect, getConnectionStatus, metrics, onlineUsers]);
 };
\ No newline at end of file
@@ -50,6 +50,11 @@ interface GetLogsParams {
   level?: string;
   component?: string;
   search?: string;
+  userId?: string;
+  from?: string;
+  to?: string;
+  sort?: 'asc' | 'desc';
+  cursor?: string;
 }
 
 class ApiService {
@@ -384,6 +389,27 @@ class ApiService {
     }
   }
 
+  async logout(token: string): Promise<void> {
+    try {
+      await this.api.post('/auth/logout', {}, {
+        headers: { Authorization: `Bearer ${token}` }
+      });
+    } catch (error) {
+      throw this.handleError(error as AxiosError);
+    } finally {
+      this.clearCache();
+    }
+  }
+
+  async getEventsToken(): Promise<{ token: string; expiresAt: string }> {
+    try {
+      const response = await this.api.post<ApiResponse<{ token: string; expiresAt: string }>>('/events/token', {});
+      return response.data.data;
+    } catch (error) {
+      throw this.handleError(error as AxiosError);
+    }
+  }
+
   async getHealth(): Promise<HealthResponse> {
     const cacheKey = this.getCacheKey('/health');
     const cached = this.getCache(cacheKey);
@@ -519,7 +545,7 @@ class ApiService {
   async cleanExpiredKeys(): Promise<{ message: string; count?: number; success: boolean; timestamp: string }> {
     try {
       const response = await this.withRetry(() => 
-        this.api.post<{ message: string; count?: number; success: boolean; timestamp: string }>('/keys/clean')
+        this.api.post<{ message: string; count?: number; success: boolean; timestamp: string }>('/keys/clean', {})
       );
       this.invalidateCache('/keys');
       return response.data;
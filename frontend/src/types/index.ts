@@ -1,6 +1,7 @@
 export interface APIKey {
   id: string;
   maskedKey: string;
+  keyRef?: string;
   name?: string;
   expiration: string;
   rpm: number;
@@ -11,9 +12,39 @@ export interface APIKey {
   updatedAt: string;
   isActive: boolean;
   lastUsed?: string;
+  scopes: string[];
+  plan?: string;
+  orgId?: string;
+  limits: LimitRule[];
+  quota?: QuotaConfig;
+  quotaUsage?: QuotaUsage;
   metadata?: Record<string, unknown>;
 }
 
+export interface QuotaConfig {
+  period: 'hourly' | 'daily' | 'monthly';
+  limit: number;
+  timezone?: string;
+}
+
+export interface QuotaUsage {
+  period: 'hourly' | 'daily' | 'monthly';
+  timezone: string;
+  limit: number;
+  used: number;
+  remaining: number;
+  periodStart: string;
+  resetAt: string;
+}
+
+export interface LimitRule {
+  route: string;
+  rpm?: number;
+  dailyQuota?: number;
+  monthlyQuota?: number;
+  concurrency?: number;
+}
+
 export interface CreateKeyRequest {
   customKey?: string;
   name: string;
@@ -21,6 +52,11 @@ export interface CreateKeyRequest {
   threadsLimit: number;
   totalRequests: number;
   expiration: string;
+  scopes?: string[];
+  plan?: string;
+  orgId?: string;
+  limits?: LimitRule[];
+  quota?: QuotaConfig;
 }
 
 export interface UpdateKeyRequest {
@@ -30,6 +66,11 @@ export interface UpdateKeyRequest {
   totalRequests?: number;
   expiration?: string;
   isActive?: boolean;
+  scopes?: string[];
+  plan?: string;
+  orgId?: string;
+  limits?: LimitRule[];
+  quota?: QuotaConfig;
 }
 
 export interface LogEntry {
@@ -42,6 +83,19 @@ export interface LogEntry {
   userId?: string;
 }
 
+export interface LogTailFilter {
+  levels?: LogEntry['level'][];
+  components?: string[];
+  search?: string;
+}
+
+export interface LogArchive {
+  name: string;
+  level: LogEntry['level'];
+  size: number;
+  createdAt: string;
+}
+
 export interface SystemStats {
   uptime: number;
   totalKeys: number;
@@ -56,6 +110,8 @@ export interface PaginationInfo {
   limit: number;
   total: number;
   totalPages: number;
+  hasMore?: boolean;
+  nextCursor?: string;
 }
 
 export interface ApiResponse<T> {
@@ -74,11 +130,42 @@ export interface AuthState {
 }
 
 export interface WSEvent {
-  type: 'key_created' | 'key_updated' | 'key_deleted' | 'log_entry' | 'system_update' | 'error' | 'pong' | 'ping';
+  type: 'key_created' | 'key_updated' | 'key_deleted' | 'log_entry' | 'log_backlog' | 'subscribed' | 'unsubscribed' | 'resync_required' | 'authenticated' | 'presence' | 'notification' | 'system_update' | 'error' | 'pong' | 'ping';
   data?: unknown;
   changes?: string[];
   timestamp?: string;
   id?: string;
+  seq?: number;
+  topic?: string;
+}
+
+export interface PresenceUser {
+  userId: string;
+  sessions: number;
+  clients: number;
+  since: string;
+}
+
+export interface PresenceEvent {
+  action: 'join' | 'leave';
+  clientId: string;
+  userId: string;
+  transport: 'websocket' | 'sse';
+  users: PresenceUser[];
+}
+
+export interface KeyNotification {
+  id: string;
+  type: 'key_expiring' | 'quota_threshold';
+  severity: 'warning' | 'error';
+  keyId: string;
+  keyName?: string;
+  threshold: number;
+  message: string;
+  expiresAt?: string;
+  used?: number;
+  limit?: number;
+  timestamp: string;
 }
 
 export interface AppError {
@@ -113,11 +200,30 @@ export interface KeyStatistics {
   averageUsage: number;
 }
 
+export interface LogStatsBucket {
+  start: string;
+  total: number;
+  byLevel: Record<string, number>;
+}
+
+export interface LogErrorGroup {
+  message: string;
+  count: number;
+  components: string[];
+  firstSeen: string;
+  lastSeen: string;
+}
+
 export interface LogStatistics {
+  from: string;
+  to: string;
+  interval: 'minute' | 'hour' | 'day';
   total: number;
   byLevel: Record<string, number>;
   byComponent: Record<string, number>;
   recentCount: number;
+  timeline: LogStatsBucket[];
+  topErrors: LogErrorGroup[];
 }
 
 export interface ExportData {
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	ProbeStatusOK   = "ok"
+	ProbeStatusFail = "fail"
+
+	readinessPingTimeout  = 2 * time.Second
+	storeRecoveryInterval = 15 * time.Second
+)
+
+type CheckResult struct {
+	Status     string                 `json:"status"`
+	Message    string                 `json:"message,omitempty"`
+	DurationMs float64                `json:"durationMs"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+type ProbeResponse struct {
+	Status    string                 `json:"status"`
+	Checks    map[string]CheckResult `json:"checks,omitempty"`
+	Uptime    float64                `json:"uptime"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+func runCheck(check func() (string, map[string]interface{}, bool)) CheckResult {
+	start := time.Now()
+	message, details, ok := check()
+	result := CheckResult{
+		Status:     ProbeStatusOK,
+		Message:    message,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		Details:    details,
+	}
+	if !ok {
+		result.Status = ProbeStatusFail
+	}
+	return result
+}
+
+func (m *APIKeyManager) checkShutdown() (string, map[string]interface{}, bool) {
+	if m.draining.Load() {
+		return "server is draining for shutdown", nil, false
+	}
+	return "", nil, true
+}
+
+func (m *APIKeyManager) checkCache() (string, map[string]interface{}, bool) {
+	details := map[string]interface{}{"size": m.cache.Size()}
+	if !m.cacheLoaded.Load() {
+		return "API keys have not been loaded from the store", details, false
+	}
+	return "", details, true
+}
+
+func (m *APIKeyManager) checkMongo() (string, map[string]interface{}, bool) {
+	if !m.isMongoConnected() || m.mongoClient == nil {
+		return "not connected", nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, readinessPingTimeout)
+	defer cancel()
+	if err := m.mongoClient.Ping(ctx, readpref.Primary()); err != nil {
+		// Probes repeat every few seconds; warn once per outage.
+		if m.readinessPingFailing.CompareAndSwap(false, true) {
+			m.Warn("Readiness ping to MongoDB failed", "error", err)
+		} else {
+			m.Debug("Readiness ping to MongoDB still failing", "error", err)
+		}
+		return "ping failed: " + err.Error(), nil, false
+	}
+	if m.readinessPingFailing.CompareAndSwap(true, false) {
+		m.Info("Readiness ping to MongoDB recovered")
+	}
+	return "", nil, true
+}
+
+func (m *APIKeyManager) livenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, ProbeResponse{
+		Status:    ProbeStatusOK,
+		Uptime:    time.Since(m.startTime).Seconds(),
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+func (m *APIKeyManager) readinessHandler(c *gin.Context) {
+	checks := map[string]CheckResult{
+		"shutdown": runCheck(m.checkShutdown),
+		"cache":    runCheck(m.checkCache),
+		"mongo":    runCheck(m.checkMongo),
+	}
+
+	status, code := ProbeStatusOK, http.StatusOK
+	for _, check := range checks {
+		if check.Status != ProbeStatusOK {
+			status, code = ProbeStatusFail, http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	c.JSON(code, ProbeResponse{
+		Status:    status,
+		Checks:    checks,
+		Uptime:    time.Since(m.startTime).Seconds(),
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+func (m *APIKeyManager) startDraining() {
+	if m.draining.CompareAndSwap(false, true) {
+		m.Info("Readiness draining started")
+	}
+}
+
+func (m *APIKeyManager) storeRecoveryJob() {
+	go func() {
+		ticker := time.NewTicker(storeRecoveryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if m.draining.Load() {
+					continue
+				}
+				if !m.isMongoConnected() {
+					if err := m.ensureMongoConnection(); err != nil {
+						m.Debug("MongoDB still unreachable", "error", err)
+						continue
+					}
+				}
+				if !m.cacheLoaded.Load() {
+					if err := m.loadAPIKeysToCache(); err != nil {
+						m.Warn("Failed to load API keys to cache", "error", err)
+					}
+				}
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestCheckMongoHasNoSideEffects(t *testing.T) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1").SetServerSelectionTimeout(readinessPingTimeout/4))
+	if err != nil {
+		t.Fatalf("mongo.Connect() error = %v", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	m := &APIKeyManager{
+		ctx:         context.Background(),
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		mongoClient: client,
+	}
+	m.setMongoStatus(true)
+
+	if _, _, ok := m.checkMongo(); ok {
+		t.Fatal("checkMongo() = ok against an unreachable server")
+	}
+	if !m.isMongoConnected() {
+		t.Fatal("checkMongo() changed the shared connection status")
+	}
+}
+
+func TestCheckMongoWarnsOncePerOutage(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("ping failures", func(mt *mtest.T) {
+		var buf bytes.Buffer
+		m := &APIKeyManager{
+			ctx:         context.Background(),
+			logger:      slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+			mongoClient: mt.Client,
+		}
+		m.setMongoStatus(true)
+
+		pingFailed := mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 91, Message: "shutdown in progress"})
+		mt.AddMockResponses(pingFailed, pingFailed, pingFailed, mtest.CreateSuccessResponse(), pingFailed)
+
+		var results []bool
+		for i := 0; i < 5; i++ {
+			_, _, ok := m.checkMongo()
+			results = append(results, ok)
+		}
+		if results[0] || results[1] || results[2] || !results[3] || results[4] {
+			mt.Fatalf("checkMongo() results = %v, want fail, fail, fail, ok, fail", results)
+		}
+
+		logged := buf.String()
+		if got := strings.Count(logged, "level=WARN"); got != 2 {
+			mt.Fatalf("logged %d warnings, want one per outage:\n%s", got, logged)
+		}
+		if got := strings.Count(logged, "level=DEBUG"); got != 2 {
+			mt.Fatalf("logged %d debug lines, want the repeated failures at debug:\n%s", got, logged)
+		}
+		if !strings.Contains(logged, "Readiness ping to MongoDB recovered") {
+			mt.Fatalf("recovery not logged:\n%s", logged)
+		}
+	})
+}
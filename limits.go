@@ -0,0 +1,614 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	LimitRPM          = "rpm"
+	LimitDailyQuota   = "daily_quota"
+	LimitMonthlyQuota = "monthly_quota"
+	LimitConcurrency  = "concurrency"
+	LimitKeyRPM       = "key_rpm"
+
+	defaultLeaseTimeout = 60 * time.Second
+	maxLimitRulesPerKey = 64
+
+	limitUsageScope = "limit"
+)
+
+type LimitRule struct {
+	Route        string `bson:"route" json:"route"`
+	RPM          int    `bson:"rpm,omitempty" json:"rpm,omitempty"`
+	DailyQuota   int64  `bson:"dailyQuota,omitempty" json:"dailyQuota,omitempty"`
+	MonthlyQuota int64  `bson:"monthlyQuota,omitempty" json:"monthlyQuota,omitempty"`
+	Concurrency  int    `bson:"concurrency,omitempty" json:"concurrency,omitempty"`
+}
+
+type Plan struct {
+	ID     string      `json:"id"`
+	Name   string      `json:"name"`
+	Limits []LimitRule `json:"limits"`
+}
+
+type ReleaseLeaseRequest struct {
+	LeaseID string `json:"leaseId"`
+}
+
+type LimitDecision struct {
+	Allowed    bool
+	Rule       *LimitRule
+	LimitHit   string
+	Limit      int64
+	Remaining  int64
+	RetryAfter time.Duration
+	LeaseID    string
+}
+
+type limitCounter struct {
+	minuteStart time.Time
+	minuteCount int
+	dayStart    time.Time
+	dayCount    int64
+	monthStart  time.Time
+	monthCount  int64
+	inFlight    int
+	lastSeen    time.Time
+}
+
+type LimitPeriodUsage struct {
+	ID          string    `bson:"_id" json:"id"`
+	Scope       string    `bson:"scope" json:"scope"`
+	KeyID       string    `bson:"keyId" json:"keyId"`
+	Route       string    `bson:"route" json:"route"`
+	Period      string    `bson:"period" json:"period"`
+	PeriodStart time.Time `bson:"periodStart" json:"periodStart"`
+	PeriodEnd   time.Time `bson:"periodEnd" json:"periodEnd"`
+	Count       int64     `bson:"count" json:"count"`
+	UpdatedAt   time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+type limitLease struct {
+	counterKey string
+	keyID      string
+	expiresAt  time.Time
+}
+
+type KeyLimiter struct {
+	counters     map[string]*limitCounter
+	leases       map[string]*limitLease
+	pending      map[string]*LimitPeriodUsage
+	leaseTimeout time.Duration
+	mu           sync.Mutex
+}
+
+func NewKeyLimiter(leaseTimeout time.Duration) *KeyLimiter {
+	if leaseTimeout <= 0 {
+		leaseTimeout = defaultLeaseTimeout
+	}
+	return &KeyLimiter{
+		counters:     make(map[string]*limitCounter),
+		leases:       make(map[string]*limitLease),
+		pending:      make(map[string]*LimitPeriodUsage),
+		leaseTimeout: leaseTimeout,
+	}
+}
+
+func validateLimitRules(rules []LimitRule) ([]LimitRule, error) {
+	if len(rules) > maxLimitRulesPerKey {
+		return nil, fmt.Errorf("too many limit rules: maximum is %d", maxLimitRulesPerKey)
+	}
+
+	seen := make(map[string]bool, len(rules))
+	validated := make([]LimitRule, 0, len(rules))
+	for _, rule := range rules {
+		rule.Route = strings.TrimSpace(rule.Route)
+		if rule.Route == "" {
+			return nil, errors.New("limit rule route cannot be empty")
+		}
+		if strings.Contains(strings.TrimSuffix(rule.Route, "*"), "*") {
+			return nil, fmt.Errorf("limit rule route '%s' may only use '*' as a trailing wildcard", rule.Route)
+		}
+		if rule.RPM < 0 || rule.DailyQuota < 0 || rule.MonthlyQuota < 0 || rule.Concurrency < 0 {
+			return nil, fmt.Errorf("limit rule '%s' cannot have negative limits", rule.Route)
+		}
+		if seen[rule.Route] {
+			return nil, fmt.Errorf("duplicate limit rule for route '%s'", rule.Route)
+		}
+		seen[rule.Route] = true
+		validated = append(validated, rule)
+	}
+
+	if len(validated) == 0 {
+		return nil, nil
+	}
+	return validated, nil
+}
+
+func equalLimitRules(a, b []LimitRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func limitRulesOrEmpty(rules []LimitRule) []LimitRule {
+	if rules == nil {
+		return []LimitRule{}
+	}
+	return append([]LimitRule(nil), rules...)
+}
+
+func matchLimitRule(rules []LimitRule, route string) *LimitRule {
+	var best *LimitRule
+	bestScore := -1
+
+	for i := range rules {
+		pattern := rules[i].Route
+		score := -1
+		switch {
+		case pattern == route:
+			score = len(pattern) + 1<<16
+		case strings.HasSuffix(pattern, "*") && strings.HasPrefix(route, strings.TrimSuffix(pattern, "*")):
+			score = len(pattern)
+		}
+		if score > bestScore {
+			best = &rules[i]
+			bestScore = score
+		}
+	}
+
+	return best
+}
+
+func (m *APIKeyManager) loadPlans() {
+	m.plans = make(map[string]*Plan, len(m.config.Plans))
+	for i := range m.config.Plans {
+		plan := m.config.Plans[i]
+		plan.ID = strings.TrimSpace(plan.ID)
+		if plan.ID == "" {
+			m.Warn("Skipping plan without id", "index", i)
+			continue
+		}
+		limits, err := validateLimitRules(plan.Limits)
+		if err != nil {
+			m.Warn("Skipping invalid plan", "plan", plan.ID, "error", err)
+			continue
+		}
+		plan.Limits = limitRulesOrEmpty(limits)
+		m.plans[plan.ID] = &plan
+	}
+}
+
+func (m *APIKeyManager) getPlan(planID string) (*Plan, bool) {
+	plan, ok := m.plans[planID]
+	return plan, ok
+}
+
+func (m *APIKeyManager) resolveLimitRule(apiKey *APIKey, route string) *LimitRule {
+	if rule := matchLimitRule(apiKey.Limits, route); rule != nil {
+		return rule
+	}
+	if plan, ok := m.getPlan(apiKey.Plan); ok {
+		return matchLimitRule(plan.Limits, route)
+	}
+	return nil
+}
+
+func startOfMinute(t time.Time) time.Time {
+	return t.Truncate(time.Minute)
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+func limiterCounterKey(keyID, route string) string {
+	return keyID + "\x00" + route
+}
+
+func (l *KeyLimiter) counter(counterKey string, now time.Time) *limitCounter {
+	counter, ok := l.counters[counterKey]
+	if !ok {
+		counter = &limitCounter{}
+		l.counters[counterKey] = counter
+	}
+
+	if minute := startOfMinute(now); !counter.minuteStart.Equal(minute) {
+		counter.minuteStart = minute
+		counter.minuteCount = 0
+	}
+	if day := startOfDay(now); !counter.dayStart.Equal(day) {
+		counter.dayStart = day
+		counter.dayCount = 0
+	}
+	if month := startOfMonth(now); !counter.monthStart.Equal(month) {
+		counter.monthStart = month
+		counter.monthCount = 0
+	}
+	counter.lastSeen = now
+	return counter
+}
+
+func limitUsageID(keyID, route, period string, periodStart time.Time) string {
+	return fmt.Sprintf("%s:%s:%s:%s:%s", limitUsageScope, keyID, route, period, periodStart.UTC().Format(time.RFC3339))
+}
+
+func (l *KeyLimiter) recordPeriodLocked(keyID, route, period string, periodStart time.Time) {
+	id := limitUsageID(keyID, route, period, periodStart)
+	usage, ok := l.pending[id]
+	if !ok {
+		_, end := quotaPeriodBounds(period, periodStart)
+		usage = &LimitPeriodUsage{
+			ID:          id,
+			Scope:       limitUsageScope,
+			KeyID:       keyID,
+			Route:       route,
+			Period:      period,
+			PeriodStart: periodStart.UTC(),
+			PeriodEnd:   end.UTC(),
+		}
+		l.pending[id] = usage
+	}
+	usage.Count++
+}
+
+func (l *KeyLimiter) Restore(usage LimitPeriodUsage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counterKey := limiterCounterKey(usage.KeyID, usage.Route)
+	counter, ok := l.counters[counterKey]
+	if !ok {
+		counter = &limitCounter{}
+		l.counters[counterKey] = counter
+	}
+
+	switch usage.Period {
+	case QuotaPeriodDaily:
+		counter.dayStart = usage.PeriodStart
+		counter.dayCount = usage.Count
+	case QuotaPeriodMonthly:
+		counter.monthStart = usage.PeriodStart
+		counter.monthCount = usage.Count
+	}
+	counter.lastSeen = time.Now().UTC()
+}
+
+func (l *KeyLimiter) drain() []LimitPeriodUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	dirty := make([]LimitPeriodUsage, 0, len(l.pending))
+	for id, usage := range l.pending {
+		dirty = append(dirty, *usage)
+		delete(l.pending, id)
+	}
+	return dirty
+}
+
+func (l *KeyLimiter) requeue(failed []LimitPeriodUsage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, usage := range failed {
+		if existing, ok := l.pending[usage.ID]; ok {
+			existing.Count += usage.Count
+			continue
+		}
+		usage := usage
+		l.pending[usage.ID] = &usage
+	}
+}
+
+func (l *KeyLimiter) expireLeasesLocked(now time.Time) {
+	for leaseID, lease := range l.leases {
+		if now.After(lease.expiresAt) {
+			if counter, ok := l.counters[lease.counterKey]; ok && counter.inFlight > 0 {
+				counter.inFlight--
+			}
+			delete(l.leases, leaseID)
+		}
+	}
+}
+
+func (l *KeyLimiter) Check(apiKey *APIKey, rule *LimitRule, now time.Time) LimitDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expireLeasesLocked(now)
+
+	keyCounter := l.counter(limiterCounterKey(apiKey.ID, ""), now)
+	if apiKey.RPM > 0 && keyCounter.minuteCount >= apiKey.RPM {
+		return LimitDecision{
+			LimitHit:   LimitKeyRPM,
+			Limit:      int64(apiKey.RPM),
+			RetryAfter: keyCounter.minuteStart.Add(time.Minute).Sub(now),
+		}
+	}
+
+	var ruleCounter *limitCounter
+	if rule != nil {
+		ruleCounter = l.counter(limiterCounterKey(apiKey.ID, rule.Route), now)
+
+		switch {
+		case rule.RPM > 0 && ruleCounter.minuteCount >= rule.RPM:
+			return LimitDecision{
+				Rule:       rule,
+				LimitHit:   LimitRPM,
+				Limit:      int64(rule.RPM),
+				RetryAfter: ruleCounter.minuteStart.Add(time.Minute).Sub(now),
+			}
+		case rule.DailyQuota > 0 && ruleCounter.dayCount >= rule.DailyQuota:
+			return LimitDecision{
+				Rule:       rule,
+				LimitHit:   LimitDailyQuota,
+				Limit:      rule.DailyQuota,
+				RetryAfter: ruleCounter.dayStart.AddDate(0, 0, 1).Sub(now),
+			}
+		case rule.MonthlyQuota > 0 && ruleCounter.monthCount >= rule.MonthlyQuota:
+			return LimitDecision{
+				Rule:       rule,
+				LimitHit:   LimitMonthlyQuota,
+				Limit:      rule.MonthlyQuota,
+				RetryAfter: ruleCounter.monthStart.AddDate(0, 1, 0).Sub(now),
+			}
+		case rule.Concurrency > 0 && ruleCounter.inFlight >= rule.Concurrency:
+			return LimitDecision{
+				Rule:       rule,
+				LimitHit:   LimitConcurrency,
+				Limit:      int64(rule.Concurrency),
+				RetryAfter: time.Second,
+			}
+		}
+	}
+
+	keyCounter.minuteCount++
+	decision := LimitDecision{Allowed: true, Rule: rule, Remaining: -1}
+	if apiKey.RPM > 0 {
+		decision.Remaining = int64(apiKey.RPM - keyCounter.minuteCount)
+	}
+
+	if ruleCounter != nil {
+		ruleCounter.minuteCount++
+		ruleCounter.dayCount++
+		ruleCounter.monthCount++
+		if rule.DailyQuota > 0 {
+			l.recordPeriodLocked(apiKey.ID, rule.Route, QuotaPeriodDaily, ruleCounter.dayStart)
+		}
+		if rule.MonthlyQuota > 0 {
+			l.recordPeriodLocked(apiKey.ID, rule.Route, QuotaPeriodMonthly, ruleCounter.monthStart)
+		}
+
+		if rule.RPM > 0 {
+			decision.Remaining = minRemaining(decision.Remaining, int64(rule.RPM-ruleCounter.minuteCount))
+		}
+		if rule.DailyQuota > 0 {
+			decision.Remaining = minRemaining(decision.Remaining, rule.DailyQuota-ruleCounter.dayCount)
+		}
+		if rule.MonthlyQuota > 0 {
+			decision.Remaining = minRemaining(decision.Remaining, rule.MonthlyQuota-ruleCounter.monthCount)
+		}
+
+		if rule.Concurrency > 0 {
+			ruleCounter.inFlight++
+			decision.LeaseID = generateRequestID() + generateRequestID()
+			l.leases[decision.LeaseID] = &limitLease{
+				counterKey: limiterCounterKey(apiKey.ID, rule.Route),
+				keyID:      apiKey.ID,
+				expiresAt:  now.Add(l.leaseTimeout),
+			}
+		}
+	}
+
+	return decision
+}
+
+func minRemaining(current, candidate int64) int64 {
+	if current < 0 || candidate < current {
+		return candidate
+	}
+	return current
+}
+
+func (l *KeyLimiter) Release(leaseID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lease, ok := l.leases[leaseID]
+	if !ok {
+		return false
+	}
+	delete(l.leases, leaseID)
+
+	if counter, ok := l.counters[lease.counterKey]; ok && counter.inFlight > 0 {
+		counter.inFlight--
+	}
+	return true
+}
+
+func (l *KeyLimiter) Forget(keyID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prefix := keyID + "\x00"
+	for counterKey := range l.counters {
+		if strings.HasPrefix(counterKey, prefix) {
+			delete(l.counters, counterKey)
+		}
+	}
+	for leaseID, lease := range l.leases {
+		if lease.keyID == keyID {
+			delete(l.leases, leaseID)
+		}
+	}
+	for id, usage := range l.pending {
+		if usage.KeyID == keyID {
+			delete(l.pending, id)
+		}
+	}
+}
+
+func (l *KeyLimiter) Cleanup(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expireLeasesLocked(now)
+
+	removed := 0
+	month := startOfMonth(now)
+	for counterKey, counter := range l.counters {
+		if counter.inFlight == 0 && counter.lastSeen.Before(month) {
+			delete(l.counters, counterKey)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (m *APIKeyManager) flushLimitUsage() error {
+	if !m.isMongoConnected() || m.usagePeriodsCollection == nil {
+		return errors.New("database connection unavailable")
+	}
+
+	dirty := m.limiter.drain()
+	if len(dirty) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	models := make([]mongo.WriteModel, 0, len(dirty))
+	for _, usage := range dirty {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": usage.ID}).
+			SetUpdate(bson.M{
+				"$inc": bson.M{"count": usage.Count},
+				"$set": bson.M{
+					"scope":       usage.Scope,
+					"keyId":       usage.KeyID,
+					"route":       usage.Route,
+					"period":      usage.Period,
+					"periodStart": usage.PeriodStart,
+					"periodEnd":   usage.PeriodEnd,
+					"updatedAt":   time.Now().UTC(),
+				},
+			}).
+			SetUpsert(true))
+	}
+
+	if _, err := m.usagePeriodsCollection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		m.limiter.requeue(dirty)
+		return fmt.Errorf("failed to flush limit usage: %w", err)
+	}
+	return nil
+}
+
+func (m *APIKeyManager) loadLimitUsage() error {
+	if err := m.ensureMongoConnection(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 30*time.Second)
+	defer cancel()
+
+	cursor, err := m.usagePeriodsCollection.Find(ctx, bson.M{
+		"scope":     limitUsageScope,
+		"periodEnd": bson.M{"$gt": time.Now().UTC()},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to find limit usage: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	restored := 0
+	for cursor.Next(ctx) {
+		var usage LimitPeriodUsage
+		if err := cursor.Decode(&usage); err != nil {
+			m.Warn("Failed to decode limit usage", "error", err)
+			continue
+		}
+		m.limiter.Restore(usage)
+		restored++
+	}
+
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("cursor error: %w", err)
+	}
+
+	m.Info("Restored limit usage", "count", restored)
+	return nil
+}
+
+func (m *APIKeyManager) limiterJanitor() {
+	go func() {
+		ticker := time.NewTicker(5 * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if removed := m.limiter.Cleanup(time.Now().UTC()); removed > 0 {
+					m.Debug("Removed idle limit counters", "count", removed)
+				}
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (m *APIKeyManager) releaseLeaseHandler(c *gin.Context) {
+	var req ReleaseLeaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		m.respondWithError(c, http.StatusBadRequest, "Invalid request data", "INVALID_REQUEST", err)
+		return
+	}
+
+	req.LeaseID = strings.TrimSpace(req.LeaseID)
+	if req.LeaseID == "" {
+		m.respondWithError(c, http.StatusBadRequest, "Lease ID is required", "MISSING_LEASE_ID", nil)
+		return
+	}
+
+	if !m.limiter.Release(req.LeaseID) {
+		m.respondWithError(c, http.StatusNotFound, "Lease not found or already expired", "LEASE_NOT_FOUND", nil)
+		return
+	}
+
+	m.respondWithSuccess(c, gin.H{"leaseId": req.LeaseID}, "Lease released")
+}
+
+func (m *APIKeyManager) listPlansHandler(c *gin.Context) {
+	plans := make([]Plan, 0, len(m.plans))
+	for _, plan := range m.plans {
+		plans = append(plans, *plan)
+	}
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].ID < plans[j].ID
+	})
+
+	m.respondWithSuccess(c, plans, "")
+}
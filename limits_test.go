@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateLimitRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []LimitRule
+		wantErr string
+	}{
+		{name: "empty", rules: nil},
+		{name: "valid wildcard", rules: []LimitRule{{Route: " /v1/* ", RPM: 10}}},
+		{name: "empty route", rules: []LimitRule{{Route: " "}}, wantErr: "route cannot be empty"},
+		{name: "inner wildcard", rules: []LimitRule{{Route: "/v1/*/items"}}, wantErr: "trailing wildcard"},
+		{name: "negative", rules: []LimitRule{{Route: "/v1", DailyQuota: -1}}, wantErr: "negative limits"},
+		{name: "duplicate", rules: []LimitRule{{Route: "/v1"}, {Route: " /v1"}}, wantErr: "duplicate limit rule"},
+		{name: "too many", rules: make([]LimitRule, maxLimitRulesPerKey+1), wantErr: "too many limit rules"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateLimitRules(tt.rules)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("validateLimitRules() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateLimitRules() unexpected error: %v", err)
+			}
+			for _, rule := range got {
+				if rule.Route != strings.TrimSpace(rule.Route) {
+					t.Fatalf("route %q was not trimmed", rule.Route)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveLimitRule(t *testing.T) {
+	m := &APIKeyManager{plans: map[string]*Plan{
+		"pro": {ID: "pro", Limits: []LimitRule{
+			{Route: "/v1/*", RPM: 100},
+			{Route: "/v1/export", DailyQuota: 10},
+		}},
+	}}
+	keyRules := []LimitRule{
+		{Route: "/v1/search*", RPM: 5},
+		{Route: "/v1/search/*", RPM: 4},
+		{Route: "/v1/search/fast", RPM: 3},
+	}
+
+	tests := []struct {
+		name      string
+		limits    []LimitRule
+		plan      string
+		route     string
+		wantRoute string
+	}{
+		{name: "exact beats wildcards", limits: keyRules, route: "/v1/search/fast", wantRoute: "/v1/search/fast"},
+		{name: "longest wildcard wins", limits: keyRules, route: "/v1/search/slow", wantRoute: "/v1/search/*"},
+		{name: "shorter wildcard still matches", limits: keyRules, route: "/v1/searches", wantRoute: "/v1/search*"},
+		{name: "key rule beats plan rule", limits: keyRules, plan: "pro", route: "/v1/search/slow", wantRoute: "/v1/search/*"},
+		{name: "plan rule when key has no match", limits: keyRules, plan: "pro", route: "/v1/export", wantRoute: "/v1/export"},
+		{name: "plan wildcard", plan: "pro", route: "/v1/items", wantRoute: "/v1/*"},
+		{name: "unknown plan", plan: "enterprise", route: "/v1/items"},
+		{name: "no match", limits: keyRules, plan: "pro", route: "/v2/items"},
+		{name: "wildcard prefix is literal", limits: keyRules, route: "/v1/sea"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := m.resolveLimitRule(&APIKey{ID: "key-1", Limits: tt.limits, Plan: tt.plan}, tt.route)
+			switch {
+			case tt.wantRoute == "" && rule != nil:
+				t.Fatalf("resolveLimitRule(%q) = %q, want no rule", tt.route, rule.Route)
+			case tt.wantRoute != "" && (rule == nil || rule.Route != tt.wantRoute):
+				t.Fatalf("resolveLimitRule(%q) = %+v, want rule %q", tt.route, rule, tt.wantRoute)
+			}
+		})
+	}
+}
+
+func TestKeyLimiterRestoresDailyQuota(t *testing.T) {
+	now := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+	apiKey := &APIKey{ID: "key-1"}
+	rule := &LimitRule{Route: "/v1/search", DailyQuota: 3}
+
+	first := NewKeyLimiter(time.Minute)
+	for i := 0; i < 2; i++ {
+		if decision := first.Check(apiKey, rule, now); !decision.Allowed {
+			t.Fatalf("request %d denied: %+v", i, decision)
+		}
+	}
+
+	dirty := first.drain()
+	if len(dirty) != 1 || dirty[0].Count != 2 || dirty[0].Period != QuotaPeriodDaily {
+		t.Fatalf("drain() = %+v, want one daily entry with count 2", dirty)
+	}
+	if again := first.drain(); len(again) != 0 {
+		t.Fatalf("second drain() = %+v, want empty", again)
+	}
+
+	restarted := NewKeyLimiter(time.Minute)
+	restarted.Restore(dirty[0])
+	if decision := restarted.Check(apiKey, rule, now.Add(time.Hour)); !decision.Allowed || decision.Remaining != 0 {
+		t.Fatalf("third request = %+v, want allowed with 0 remaining", decision)
+	}
+	if decision := restarted.Check(apiKey, rule, now.Add(2*time.Hour)); decision.Allowed || decision.LimitHit != LimitDailyQuota {
+		t.Fatalf("fourth request = %+v, want daily quota hit", decision)
+	}
+	if decision := restarted.Check(apiKey, rule, now.AddDate(0, 0, 1)); !decision.Allowed {
+		t.Fatalf("next day request = %+v, want allowed", decision)
+	}
+}
+
+func TestKeyLimiterRequeueMergesCounts(t *testing.T) {
+	now := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+	limiter := NewKeyLimiter(time.Minute)
+	rule := &LimitRule{Route: "/v1", MonthlyQuota: 10}
+
+	limiter.Check(&APIKey{ID: "key-1"}, rule, now)
+	failed := limiter.drain()
+	limiter.Check(&APIKey{ID: "key-1"}, rule, now)
+	limiter.requeue(failed)
+
+	dirty := limiter.drain()
+	if len(dirty) != 1 || dirty[0].Count != 2 {
+		t.Fatalf("drain() after requeue = %+v, want one entry with count 2", dirty)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+var logCorrelationKeys = []struct {
+	contextKey string
+	attrKey    string
+}{
+	{"requestID", "requestId"},
+	{"userID", "userId"},
+	{"spanID", "spanId"},
+}
+
+type logCorrelationContextKey struct{}
+
+// withLogCorrelation carries the request correlation values of c onto ctx, for
+// work that runs under the manager context on behalf of a request.
+func withLogCorrelation(ctx context.Context, c *gin.Context) context.Context {
+	values := make(map[string]string, len(logCorrelationKeys))
+	for _, key := range logCorrelationKeys {
+		if value := c.GetString(key.contextKey); value != "" {
+			values[key.contextKey] = value
+		}
+	}
+	if len(values) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, logCorrelationContextKey{}, values)
+}
+
+func logCorrelationValue(ctx context.Context, contextKey string) string {
+	if value, ok := ctx.Value(contextKey).(string); ok && value != "" {
+		return value
+	}
+	if values, ok := ctx.Value(logCorrelationContextKey{}).(map[string]string); ok {
+		return values[contextKey]
+	}
+	return ""
+}
+
+type LogHandler struct {
+	sinks      []slog.Handler
+	level      slog.Level
+	components map[string]slog.Level
+	component  string
+}
+
+func parseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("invalid log level '%s': supported levels are debug, info, warn, error", value)
+	}
+}
+
+func newSinkHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if format == LogFormatText {
+		return slog.NewTextHandler(w, opts)
+	}
+	return slog.NewJSONHandler(w, opts)
+}
+
+func NewLogHandler(config *Config, writers ...io.Writer) (*LogHandler, error) {
+	format := strings.ToLower(strings.TrimSpace(config.LogFormat))
+	if format == "" {
+		format = LogFormatJSON
+	}
+	if format != LogFormatJSON && format != LogFormatText {
+		return nil, fmt.Errorf("invalid log format '%s': supported formats are json, text", config.LogFormat)
+	}
+
+	level, err := parseLogLevel(config.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	components := make(map[string]slog.Level, len(config.ComponentLogLevels))
+	for component, value := range config.ComponentLogLevels {
+		componentLevel, err := parseLogLevel(value)
+		if err != nil {
+			return nil, fmt.Errorf("component '%s': %w", component, err)
+		}
+		components[component] = componentLevel
+	}
+
+	h := &LogHandler{
+		level:      level,
+		components: components,
+	}
+	for _, w := range writers {
+		h.sinks = append(h.sinks, newSinkHandler(w, format))
+	}
+	return h, nil
+}
+
+func (h *LogHandler) levelFor(component string) slog.Level {
+	if level, ok := h.components[component]; ok {
+		return level
+	}
+	return h.level
+}
+
+func (h *LogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	if h.component != "" {
+		return level >= h.levelFor(h.component)
+	}
+	if level >= h.level {
+		return true
+	}
+	for _, componentLevel := range h.components {
+		if level >= componentLevel {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *LogHandler) Handle(ctx context.Context, record slog.Record) error {
+	component := h.component
+	present := make(map[string]bool)
+	record.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == "component" {
+			component = attr.Value.String()
+		}
+		present[attr.Key] = true
+		return true
+	})
+
+	if record.Level < h.levelFor(component) {
+		return nil
+	}
+
+	if ctx != nil {
+		for _, key := range logCorrelationKeys {
+			if present[key.attrKey] {
+				continue
+			}
+			if value := logCorrelationValue(ctx, key.contextKey); value != "" {
+				record.AddAttrs(slog.String(key.attrKey, value))
+			}
+		}
+	}
+
+	var firstErr error
+	for _, sink := range h.sinks {
+		if err := sink.Handle(ctx, record.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.sinks = make([]slog.Handler, len(h.sinks))
+	for i, sink := range h.sinks {
+		clone.sinks[i] = sink.WithAttrs(attrs)
+	}
+	for _, attr := range attrs {
+		if attr.Key == "component" {
+			clone.component = attr.Value.String()
+		}
+	}
+	return &clone
+}
+
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.sinks = make([]slog.Handler, len(h.sinks))
+	for i, sink := range h.sinks {
+		clone.sinks[i] = sink.WithGroup(name)
+	}
+	return &clone
+}
+
+func newLogger(config *Config, fileLogger *FileLogger, sinks []*LogSink) (*slog.Logger, error) {
+	writers := []io.Writer{os.Stderr}
+	if fileLogger != nil {
+		writers = append(writers, fileLogger)
+	}
+
+	handler, err := NewLogHandler(config, writers...)
+	if err != nil {
+		return nil, err
+	}
+	for _, sink := range sinks {
+		handler.sinks = append(handler.sinks, newSinkLogHandler(sink))
+	}
+	return slog.New(handler), nil
+}
+
+func (m *APIKeyManager) Info(message string, fields ...interface{}) {
+	m.logger.Info(message, fields...)
+}
+
+func (m *APIKeyManager) Error(message string, fields ...interface{}) {
+	m.logger.Error(message, fields...)
+}
+
+func (m *APIKeyManager) Warn(message string, fields ...interface{}) {
+	m.logger.Warn(message, fields...)
+}
+
+func (m *APIKeyManager) Debug(message string, fields ...interface{}) {
+	m.logger.Debug(message, fields...)
+}
+
+func (m *APIKeyManager) InfoContext(ctx context.Context, message string, fields ...interface{}) {
+	m.logger.InfoContext(ctx, message, fields...)
+}
+
+func (m *APIKeyManager) ErrorContext(ctx context.Context, message string, fields ...interface{}) {
+	m.logger.ErrorContext(ctx, message, fields...)
+}
+
+func (m *APIKeyManager) WarnContext(ctx context.Context, message string, fields ...interface{}) {
+	m.logger.WarnContext(ctx, message, fields...)
+}
+
+func (m *APIKeyManager) DebugContext(ctx context.Context, message string, fields ...interface{}) {
+	m.logger.DebugContext(ctx, message, fields...)
+}
+
+func logLevelFromString(level string) slog.Level {
+	parsed, err := parseLogLevel(level)
+	if err != nil {
+		return slog.LevelInfo
+	}
+	return parsed
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("decode log line %q: %v", line, err)
+		}
+		lines = append(lines, record)
+	}
+	return lines
+}
+
+func TestLogHandlerComponentLevels(t *testing.T) {
+	h, err := NewLogHandler(&Config{
+		LogLevel:           "warn",
+		ComponentLogLevels: map[string]string{"billing": "debug", "audit": "error"},
+	})
+	if err != nil {
+		t.Fatalf("NewLogHandler() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		component string
+		level     slog.Level
+		want      bool
+	}{
+		{"root debug enabled for a debug component", "", slog.LevelDebug, true},
+		{"default info", "system", slog.LevelInfo, false},
+		{"default warn", "system", slog.LevelWarn, true},
+		{"component debug", "billing", slog.LevelDebug, true},
+		{"component raised above default", "audit", slog.LevelWarn, false},
+		{"component error", "audit", slog.LevelError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handler slog.Handler = h
+			if tt.component != "" {
+				handler = h.WithAttrs([]slog.Attr{slog.String("component", tt.component)})
+			}
+			if got := handler.Enabled(context.Background(), tt.level); got != tt.want {
+				t.Fatalf("Enabled(%v) = %v, want %v", tt.level, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogHandlerHandleFiltersByComponentAttr(t *testing.T) {
+	var buf bytes.Buffer
+	h, err := NewLogHandler(&Config{
+		LogLevel:           "warn",
+		ComponentLogLevels: map[string]string{"billing": "debug"},
+	}, &buf)
+	if err != nil {
+		t.Fatalf("NewLogHandler() error = %v", err)
+	}
+	logger := slog.New(h)
+
+	logger.Debug("billing detail", "component", "billing")
+	logger.Info("system detail", "component", "system")
+	logger.With("component", "billing").Info("billing with")
+	logger.With("component", "system").Warn("system warning")
+
+	lines := decodeLogLines(t, &buf)
+	var got []string
+	for _, line := range lines {
+		got = append(got, line["msg"].(string))
+	}
+	want := []string{"billing detail", "billing with", "system warning"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("logged messages = %v, want %v", got, want)
+	}
+}
+
+func TestLogHandlerAddsCorrelation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/keys", nil)
+	c.Set("requestID", "req-1")
+	c.Set("userID", "user-1")
+	c.Set("spanID", "span-1")
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		attrs []interface{}
+		want  map[string]string
+	}{
+		{
+			name: "gin context",
+			ctx:  c,
+			want: map[string]string{"requestId": "req-1", "userId": "user-1", "spanId": "span-1"},
+		},
+		{
+			name: "manager context carrying request values",
+			ctx:  withLogCorrelation(context.Background(), c),
+			want: map[string]string{"requestId": "req-1", "userId": "user-1", "spanId": "span-1"},
+		},
+		{
+			name:  "explicit attribute wins",
+			ctx:   c,
+			attrs: []interface{}{"userId", "someone-else"},
+			want:  map[string]string{"requestId": "req-1", "userId": "someone-else", "spanId": "span-1"},
+		},
+		{
+			name: "no request",
+			ctx:  context.Background(),
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h, err := NewLogHandler(&Config{}, &buf)
+			if err != nil {
+				t.Fatalf("NewLogHandler() error = %v", err)
+			}
+			slog.New(h).InfoContext(tt.ctx, "message", tt.attrs...)
+
+			lines := decodeLogLines(t, &buf)
+			if len(lines) != 1 {
+				t.Fatalf("logged %d lines, want 1", len(lines))
+			}
+			for _, key := range []string{"requestId", "userId", "spanId"} {
+				got, _ := lines[0][key].(string)
+				if got != tt.want[key] {
+					t.Fatalf("%s = %q, want %q", key, got, tt.want[key])
+				}
+			}
+		})
+	}
+}
+
+func TestLogMessageCarriesRequestCorrelation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	h, err := NewLogHandler(&Config{}, &buf)
+	if err != nil {
+		t.Fatalf("NewLogHandler() error = %v", err)
+	}
+	m := &APIKeyManager{
+		config: &Config{},
+		ctx:    context.Background(),
+		logger: slog.New(h),
+	}
+	m.logPipeline = NewLogPipeline(m, 10, t.TempDir(), 0)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("DELETE", "/api/keys/1", nil)
+	c.Set("requestID", "req-42")
+	c.Set("userID", "admin")
+
+	m.logMessage(m.traceContext(c), "INFO", "API Key deleted", map[string]interface{}{"component": "api"})
+
+	lines := decodeLogLines(t, &buf)
+	if len(lines) != 1 || lines[0]["requestId"] != "req-42" || lines[0]["userId"] != "admin" {
+		t.Fatalf("logged %v, want requestId req-42 and userId admin", lines)
+	}
+
+	select {
+	case entry := <-m.logPipeline.queue:
+		if entry.UserID != "admin" || entry.Metadata["requestId"] != "req-42" || entry.Component != "api" {
+			t.Fatalf("enqueued %+v, want userId admin, requestId req-42, component api", entry)
+		}
+	default:
+		t.Fatal("logMessage did not enqueue an entry")
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	logBatchSize          = 500
+	logFlushInterval      = time.Second
+	logReplayInterval     = 10 * time.Second
+	logInsertTimeout      = 5 * time.Second
+	logJournalFile        = "pending.ndjson"
+	logJournalReplayFile  = "replaying.ndjson"
+	defaultLogQueueSize   = 10000
+	defaultLogJournalSize = 100 * 1024 * 1024
+)
+
+type LogPipelineStats struct {
+	Queued         int   `json:"queued"`
+	Overflow       int   `json:"overflow"`
+	Capacity       int   `json:"capacity"`
+	Inserted       int64 `json:"inserted"`
+	Journaled      int64 `json:"journaled"`
+	Replayed       int64 `json:"replayed"`
+	Dropped        int64 `json:"dropped"`
+	JournalBytes   int64 `json:"journalBytes"`
+	MaxJournalSize int64 `json:"maxJournalSize"`
+}
+
+type LogPipeline struct {
+	m       *APIKeyManager
+	queue   chan LogEntry
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+
+	// overflow holds entries that found the queue full until the worker
+	// spills them to the journal, so Enqueue never touches the disk.
+	overflowMu    sync.Mutex
+	overflow      []LogEntry
+	overflowReady chan struct{}
+
+	journalDir     string
+	maxJournalSize int64
+	journalMu      sync.Mutex
+	replayMu       sync.Mutex
+
+	inserted  int64
+	journaled int64
+	replayed  int64
+	dropped   int64
+}
+
+func NewLogPipeline(m *APIKeyManager, queueSize int, journalDir string, maxJournalSize int64) *LogPipeline {
+	if queueSize <= 0 {
+		queueSize = defaultLogQueueSize
+	}
+	if maxJournalSize <= 0 {
+		maxJournalSize = defaultLogJournalSize
+	}
+
+	return &LogPipeline{
+		m:              m,
+		queue:          make(chan LogEntry, queueSize),
+		done:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+		overflowReady:  make(chan struct{}, 1),
+		journalDir:     journalDir,
+		maxJournalSize: maxJournalSize,
+	}
+}
+
+func (p *LogPipeline) Enqueue(entry LogEntry) {
+	select {
+	case <-p.done:
+		p.spill([]LogEntry{entry})
+		return
+	default:
+	}
+
+	select {
+	case p.queue <- entry:
+	default:
+		p.addOverflow(entry)
+	}
+}
+
+func (p *LogPipeline) addOverflow(entry LogEntry) {
+	p.overflowMu.Lock()
+	if len(p.overflow) >= cap(p.queue) {
+		p.overflowMu.Unlock()
+		atomic.AddInt64(&p.dropped, 1)
+		return
+	}
+	p.overflow = append(p.overflow, entry)
+	ready := len(p.overflow) >= logBatchSize
+	p.overflowMu.Unlock()
+
+	if ready {
+		select {
+		case p.overflowReady <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *LogPipeline) takeOverflow() []LogEntry {
+	p.overflowMu.Lock()
+	defer p.overflowMu.Unlock()
+	batch := p.overflow
+	p.overflow = nil
+	return batch
+}
+
+// spillOverflow journals the buffered overflow in batches of logBatchSize.
+func (p *LogPipeline) spillOverflow() {
+	batch := p.takeOverflow()
+	for len(batch) > 0 {
+		n := len(batch)
+		if n > logBatchSize {
+			n = logBatchSize
+		}
+		p.spill(batch[:n])
+		batch = batch[n:]
+	}
+}
+
+func (p *LogPipeline) Start() {
+	go p.run()
+}
+
+func (p *LogPipeline) run() {
+	defer close(p.stopped)
+
+	flushTicker := time.NewTicker(logFlushInterval)
+	defer flushTicker.Stop()
+	replayTicker := time.NewTicker(logReplayInterval)
+	defer replayTicker.Stop()
+
+	batch := make([]LogEntry, 0, logBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		p.write(batch)
+		batch = make([]LogEntry, 0, logBatchSize)
+	}
+
+	for {
+		select {
+		case entry := <-p.queue:
+			batch = append(batch, entry)
+			if len(batch) >= logBatchSize {
+				flush()
+			}
+		case <-p.overflowReady:
+			p.spillOverflow()
+		case <-flushTicker.C:
+			flush()
+			p.spillOverflow()
+		case <-replayTicker.C:
+			flush()
+			p.spillOverflow()
+			if err := p.replay(); err != nil {
+				p.m.Warn("Log journal replay interrupted", "component", "logs", "error", err)
+			}
+		case <-p.done:
+			for {
+				select {
+				case entry := <-p.queue:
+					batch = append(batch, entry)
+					if len(batch) >= logBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					p.spillOverflow()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *LogPipeline) write(batch []LogEntry) {
+	if err := p.insert(batch); err != nil {
+		p.spill(batch)
+	} else {
+		atomic.AddInt64(&p.inserted, int64(len(batch)))
+	}
+
+	for _, entry := range batch {
+		p.m.logTail.Add(entry)
+	}
+
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+
+	if !p.m.hasLogSubscribers() {
+		return
+	}
+	for _, entry := range batch {
+		p.m.broadcastEvent(WSMessage{
+			Type:      "log_entry",
+			Data:      entry,
+			Timestamp: time.Now().UTC(),
+			ID:        generateRequestID(),
+			Topic:     TopicLogs,
+		})
+	}
+}
+
+func (p *LogPipeline) insert(batch []LogEntry) error {
+	if !p.m.isMongoConnected() {
+		return errors.New("database connection unavailable")
+	}
+
+	docs := make([]interface{}, len(batch))
+	for i := range batch {
+		docs[i] = batch[i]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), logInsertTimeout)
+	defer cancel()
+
+	_, err := p.m.logsCollection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil && !isOnlyDuplicateKeyError(err) {
+		return err
+	}
+	return nil
+}
+
+func isOnlyDuplicateKeyError(err error) bool {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *LogPipeline) spill(batch []LogEntry) {
+	p.journalMu.Lock()
+	defer p.journalMu.Unlock()
+
+	if err := os.MkdirAll(p.journalDir, 0755); err != nil {
+		atomic.AddInt64(&p.dropped, int64(len(batch)))
+		return
+	}
+
+	path := filepath.Join(p.journalDir, logJournalFile)
+	size := int64(0)
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		atomic.AddInt64(&p.dropped, int64(len(batch)))
+		return
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	written := 0
+	for _, entry := range batch {
+		line, err := json.Marshal(entry)
+		if err != nil || size+int64(len(line))+1 > p.maxJournalSize {
+			atomic.AddInt64(&p.dropped, 1)
+			continue
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+		size += int64(len(line)) + 1
+		written++
+	}
+
+	if err := w.Flush(); err != nil {
+		atomic.AddInt64(&p.dropped, int64(written))
+		return
+	}
+	atomic.AddInt64(&p.journaled, int64(written))
+}
+
+func (p *LogPipeline) replay() error {
+	p.replayMu.Lock()
+	defer p.replayMu.Unlock()
+
+	if p.journalBytes() == 0 || !p.m.isMongoConnected() {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), logInsertTimeout)
+	err := p.m.mongoClient.Ping(ctx, readpref.Primary())
+	cancel()
+	if err != nil {
+		return nil
+	}
+
+	replayPath := filepath.Join(p.journalDir, logJournalReplayFile)
+	if _, err := os.Stat(replayPath); os.IsNotExist(err) {
+		p.journalMu.Lock()
+		err := os.Rename(filepath.Join(p.journalDir, logJournalFile), replayPath)
+		p.journalMu.Unlock()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Open(replayPath)
+	if err != nil {
+		return err
+	}
+
+	var replayed int64
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	batch := make([]LogEntry, 0, logBatchSize)
+	insertBatch := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := p.insert(batch); err != nil {
+			return err
+		}
+		replayed += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			atomic.AddInt64(&p.dropped, 1)
+			continue
+		}
+		batch = append(batch, entry)
+		if len(batch) >= logBatchSize {
+			if err = insertBatch(); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+	if err == nil {
+		err = insertBatch()
+	}
+	file.Close()
+
+	atomic.AddInt64(&p.replayed, replayed)
+	if err != nil {
+		return fmt.Errorf("replayed %d entries before failure: %w", replayed, err)
+	}
+
+	if replayed > 0 {
+		p.m.Info("Replayed journaled log entries", "component", "logs", "count", replayed)
+	}
+	return os.Remove(replayPath)
+}
+
+func (p *LogPipeline) journalBytes() int64 {
+	var total int64
+	for _, name := range []string{logJournalFile, logJournalReplayFile} {
+		if info, err := os.Stat(filepath.Join(p.journalDir, name)); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+func (p *LogPipeline) overflowLen() int {
+	p.overflowMu.Lock()
+	defer p.overflowMu.Unlock()
+	return len(p.overflow)
+}
+
+func (p *LogPipeline) Stats() LogPipelineStats {
+	return LogPipelineStats{
+		Queued:         len(p.queue),
+		Overflow:       p.overflowLen(),
+		Capacity:       cap(p.queue),
+		Inserted:       atomic.LoadInt64(&p.inserted),
+		Journaled:      atomic.LoadInt64(&p.journaled),
+		Replayed:       atomic.LoadInt64(&p.replayed),
+		Dropped:        atomic.LoadInt64(&p.dropped),
+		JournalBytes:   p.journalBytes(),
+		MaxJournalSize: p.maxJournalSize,
+	}
+}
+
+func (p *LogPipeline) Close(timeout time.Duration) {
+	p.once.Do(func() {
+		close(p.done)
+	})
+
+	select {
+	case <-p.stopped:
+	case <-time.After(timeout):
+	}
+	// Entries that overflowed after the worker's last drain.
+	p.spillOverflow()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func newTestLogEntries(n int) []LogEntry {
+	entries := make([]LogEntry, n)
+	for i := range entries {
+		entries[i] = LogEntry{ID: primitive.NewObjectID(), Level: "INFO", Message: "entry", Component: "test"}
+	}
+	return entries
+}
+
+func readJournal(t *testing.T, path string) []LogEntry {
+	t.Helper()
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var entries []LogEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("decode journal line: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func writeJournal(t *testing.T, path string, entries []LogEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create %s: %v", path, err)
+	}
+	defer file.Close()
+	for _, entry := range entries {
+		line, _ := json.Marshal(entry)
+		file.Write(append(line, '\n'))
+	}
+}
+
+func TestLogPipelineEnqueueBuffersOverflow(t *testing.T) {
+	m := &APIKeyManager{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	dir := t.TempDir()
+	p := NewLogPipeline(m, 2, dir, 0)
+	journal := filepath.Join(dir, logJournalFile)
+
+	entries := newTestLogEntries(5)
+	for _, entry := range entries {
+		p.Enqueue(entry)
+	}
+
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Fatalf("Enqueue wrote the journal on the caller goroutine (stat error = %v)", err)
+	}
+	if stats := p.Stats(); stats.Queued != 2 || stats.Overflow != 2 || stats.Dropped != 1 {
+		t.Fatalf("Stats() = %+v, want 2 queued, 2 overflowed and 1 dropped", stats)
+	}
+
+	p.spillOverflow()
+
+	spilled := readJournal(t, journal)
+	if len(spilled) != 2 || spilled[0].ID != entries[2].ID || spilled[1].ID != entries[3].ID {
+		t.Fatalf("journal = %+v, want the two overflowed entries in order", spilled)
+	}
+	if stats := p.Stats(); stats.Overflow != 0 || stats.Journaled != 2 {
+		t.Fatalf("Stats() after spill = %+v, want empty overflow and 2 journaled", stats)
+	}
+}
+
+func TestLogPipelineSpillOverflowBatches(t *testing.T) {
+	m := &APIKeyManager{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	dir := t.TempDir()
+	p := NewLogPipeline(m, logBatchSize+1, dir, 0)
+	for len(p.queue) < cap(p.queue) {
+		p.queue <- LogEntry{}
+	}
+
+	for _, entry := range newTestLogEntries(logBatchSize + 1) {
+		p.Enqueue(entry)
+	}
+
+	select {
+	case <-p.overflowReady:
+	default:
+		t.Fatal("overflow reaching logBatchSize did not signal the worker")
+	}
+
+	p.spillOverflow()
+	if got := len(readJournal(t, filepath.Join(dir, logJournalFile))); got != logBatchSize+1 {
+		t.Fatalf("journaled %d entries, want %d", got, logBatchSize+1)
+	}
+}
+
+func TestLogPipelineReplay(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	newPipeline := func(mt *mtest.T) (*LogPipeline, string) {
+		m := &APIKeyManager{
+			logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+			mongoClient:    mt.Client,
+			logsCollection: mt.Coll,
+		}
+		m.setMongoStatus(true)
+		dir := mt.TempDir()
+		return NewLogPipeline(m, 10, dir, 0), dir
+	}
+
+	mt.Run("spilled entries are replayed", func(mt *mtest.T) {
+		p, dir := newPipeline(mt)
+		p.spill(newTestLogEntries(3))
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 3}))
+		if err := p.replay(); err != nil {
+			mt.Fatalf("replay() error = %v", err)
+		}
+
+		if stats := p.Stats(); stats.Replayed != 3 || stats.JournalBytes != 0 {
+			mt.Fatalf("Stats() = %+v, want 3 replayed and an empty journal", stats)
+		}
+		if _, err := os.Stat(filepath.Join(dir, logJournalReplayFile)); !os.IsNotExist(err) {
+			mt.Fatalf("%s left behind after replay (stat error = %v)", logJournalReplayFile, err)
+		}
+	})
+
+	mt.Run("interrupted replay resumes and tolerates duplicate keys", func(mt *mtest.T) {
+		p, dir := newPipeline(mt)
+		interrupted := newTestLogEntries(2)
+		writeJournal(t, filepath.Join(dir, logJournalReplayFile), interrupted)
+		p.spill(newTestLogEntries(1))
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"}),
+		)
+		if err := p.replay(); err != nil {
+			mt.Fatalf("replay() error = %v, want duplicate keys tolerated", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, logJournalReplayFile)); !os.IsNotExist(err) {
+			mt.Fatalf("%s left behind after replay (stat error = %v)", logJournalReplayFile, err)
+		}
+		if pending := readJournal(t, filepath.Join(dir, logJournalFile)); len(pending) != 1 {
+			mt.Fatalf("%s = %d entries, want the newer spill kept for the next replay", logJournalFile, len(pending))
+		}
+		if stats := p.Stats(); stats.Replayed != 2 {
+			mt.Fatalf("Stats().Replayed = %d, want 2", stats.Replayed)
+		}
+	})
+
+	mt.Run("failed insert keeps the replay file", func(mt *mtest.T) {
+		p, dir := newPipeline(mt)
+		entries := newTestLogEntries(2)
+		p.spill(entries)
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 91, Message: "shutdown in progress"}),
+		)
+		if err := p.replay(); err == nil {
+			mt.Fatal("replay() error = nil, want the insert failure")
+		}
+
+		kept := readJournal(t, filepath.Join(dir, logJournalReplayFile))
+		if len(kept) != 2 || kept[0].ID != entries[0].ID {
+			mt.Fatalf("%s = %+v, want both entries kept for retry", logJournalReplayFile, kept)
+		}
+		if stats := p.Stats(); stats.Replayed != 0 {
+			mt.Fatalf("Stats().Replayed = %d, want 0", stats.Replayed)
+		}
+	})
+}
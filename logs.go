@@ -0,0 +1,588 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var metadataFieldPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*$`)
+
+type logCursor struct {
+	Timestamp time.Time          `json:"t"`
+	ID        primitive.ObjectID `json:"id"`
+}
+
+func queryList(c *gin.Context, name string) []string {
+	var values []string
+	for _, raw := range c.QueryArray(name) {
+		for _, value := range strings.Split(raw, ",") {
+			value = strings.TrimSpace(value)
+			if value != "" && value != "all" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+func parseQueryTime(c *gin.Context, name string) (*time.Time, error) {
+	value := strings.TrimSpace(c.Query(name))
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid '%s' timestamp: expected RFC3339", name)
+	}
+	parsed = parsed.UTC()
+	return &parsed, nil
+}
+
+func parseLogFilter(c *gin.Context) (bson.M, error) {
+	filter := bson.M{}
+
+	if levels := queryList(c, "level"); len(levels) > 0 {
+		for i := range levels {
+			levels[i] = strings.ToUpper(levels[i])
+		}
+		filter["level"] = bson.M{"$in": levels}
+	}
+
+	if components := queryList(c, "component"); len(components) > 0 {
+		filter["component"] = bson.M{"$in": components}
+	}
+
+	if userID := strings.TrimSpace(c.Query("userId")); userID != "" {
+		filter["userId"] = userID
+	}
+
+	from, err := parseQueryTime(c, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseQueryTime(c, "to")
+	if err != nil {
+		return nil, err
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, errors.New("'from' must be before 'to'")
+	}
+	if from != nil || to != nil {
+		timeRange := bson.M{}
+		if from != nil {
+			timeRange["$gte"] = *from
+		}
+		if to != nil {
+			timeRange["$lt"] = *to
+		}
+		filter["timestamp"] = timeRange
+	}
+
+	for param, values := range c.Request.URL.Query() {
+		if !strings.HasPrefix(param, "metadata.") || len(values) == 0 {
+			continue
+		}
+		field := strings.TrimPrefix(param, "metadata.")
+		if !metadataFieldPattern.MatchString(field) {
+			return nil, fmt.Errorf("invalid metadata field '%s'", field)
+		}
+
+		candidates := []interface{}{values[0]}
+		if number, err := strconv.ParseInt(values[0], 10, 64); err == nil {
+			candidates = append(candidates, number)
+		} else if number, err := strconv.ParseFloat(values[0], 64); err == nil {
+			candidates = append(candidates, number)
+		}
+		if flag, err := strconv.ParseBool(values[0]); err == nil {
+			candidates = append(candidates, flag)
+		}
+		filter["metadata."+field] = bson.M{"$in": candidates}
+	}
+
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		pattern := regexp.QuoteMeta(search)
+		filter["$or"] = []bson.M{
+			{"message": bson.M{"$regex": pattern, "$options": "i"}},
+			{"component": bson.M{"$regex": pattern, "$options": "i"}},
+		}
+	}
+
+	return filter, nil
+}
+
+func parseLogSort(c *gin.Context) (int, error) {
+	switch strings.ToLower(c.DefaultQuery("sort", "desc")) {
+	case "desc":
+		return -1, nil
+	case "asc":
+		return 1, nil
+	default:
+		return 0, errors.New("invalid sort: supported values are asc, desc")
+	}
+}
+
+func encodeLogCursor(entry LogEntry) string {
+	data, _ := json.Marshal(logCursor{Timestamp: entry.Timestamp, ID: entry.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeLogCursor(value string) (*logCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var cursor logCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID.IsZero() {
+		return nil, errors.New("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+func applyLogCursor(filter bson.M, cursor *logCursor, sortDir int) bson.M {
+	op := "$lt"
+	if sortDir > 0 {
+		op = "$gt"
+	}
+
+	after := bson.M{"$or": []bson.M{
+		{"timestamp": bson.M{op: cursor.Timestamp}},
+		{"timestamp": cursor.Timestamp, "_id": bson.M{op: cursor.ID}},
+	}}
+
+	if len(filter) == 0 {
+		return after
+	}
+	return bson.M{"$and": []bson.M{filter, after}}
+}
+
+type LogStatsBucket struct {
+	Start   time.Time        `json:"start"`
+	Total   int64            `json:"total"`
+	ByLevel map[string]int64 `json:"byLevel"`
+}
+
+type LogErrorGroup struct {
+	Message    string    `bson:"_id" json:"message"`
+	Count      int64     `bson:"count" json:"count"`
+	Components []string  `bson:"components" json:"components"`
+	FirstSeen  time.Time `bson:"firstSeen" json:"firstSeen"`
+	LastSeen   time.Time `bson:"lastSeen" json:"lastSeen"`
+}
+
+type LogStatistics struct {
+	From        time.Time        `json:"from"`
+	To          time.Time        `json:"to"`
+	Interval    string           `json:"interval"`
+	Total       int64            `json:"total"`
+	ByLevel     map[string]int64 `json:"byLevel"`
+	ByComponent map[string]int64 `json:"byComponent"`
+	RecentCount int64            `json:"recentCount"`
+	Timeline    []LogStatsBucket `json:"timeline"`
+	TopErrors   []LogErrorGroup  `json:"topErrors"`
+}
+
+type logStatsCount struct {
+	Key   string `bson:"_id"`
+	Count int64  `bson:"count"`
+}
+
+type logStatsTimelineCount struct {
+	Key struct {
+		Start time.Time `bson:"start"`
+		Level string    `bson:"level"`
+	} `bson:"_id"`
+	Count int64 `bson:"count"`
+}
+
+type logStatsFacets struct {
+	Total       []logStatsCount         `bson:"total"`
+	Recent      []logStatsCount         `bson:"recent"`
+	ByLevel     []logStatsCount         `bson:"byLevel"`
+	ByComponent []logStatsCount         `bson:"byComponent"`
+	Timeline    []logStatsTimelineCount `bson:"timeline"`
+	TopErrors   []LogErrorGroup         `bson:"topErrors"`
+}
+
+func logStatsInterval(from, to time.Time) string {
+	span := to.Sub(from)
+	switch {
+	case span <= 6*time.Hour:
+		return GranularityMinute
+	case span <= 30*24*time.Hour:
+		return GranularityHour
+	default:
+		return GranularityDay
+	}
+}
+
+func (m *APIKeyManager) getLogStatsHandler(c *gin.Context) {
+	if !m.isMongoConnected() {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", nil)
+		return
+	}
+
+	filter, err := parseLogFilter(c)
+	if err != nil {
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_FILTER", err)
+		return
+	}
+
+	from, to, err := parseUsageRange(c, 24*time.Hour)
+	if err != nil {
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_RANGE", nil)
+		return
+	}
+	filter["timestamp"] = bson.M{"$gte": from, "$lt": to}
+
+	interval := c.Query("interval")
+	if interval == "" {
+		interval = logStatsInterval(from, to)
+	}
+	if _, err := parseGranularity(interval); err != nil {
+		m.respondWithError(c, http.StatusBadRequest, strings.Replace(err.Error(), "granularity", "interval", 1), "INVALID_INTERVAL", nil)
+		return
+	}
+
+	step := granularityStep(interval)
+	start := truncateToGranularity(from, interval)
+	if int(to.Sub(start)/step) > maxUsageBuckets {
+		m.respondWithError(c, http.StatusBadRequest, fmt.Sprintf("Requested range exceeds %d %s buckets", maxUsageBuckets, interval), "RANGE_TOO_LARGE", nil)
+		return
+	}
+
+	topErrors, _ := strconv.Atoi(c.DefaultQuery("topErrors", "10"))
+	if topErrors < 1 || topErrors > 100 {
+		topErrors = 10
+	}
+
+	recentSince := to.Add(-time.Hour)
+	if recentSince.Before(from) {
+		recentSince = from
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$facet", Value: bson.M{
+			"total": bson.A{
+				bson.M{"$group": bson.M{"_id": nil, "count": bson.M{"$sum": 1}}},
+			},
+			"recent": bson.A{
+				bson.M{"$match": bson.M{"timestamp": bson.M{"$gte": recentSince}}},
+				bson.M{"$group": bson.M{"_id": nil, "count": bson.M{"$sum": 1}}},
+			},
+			"byLevel": bson.A{
+				bson.M{"$group": bson.M{"_id": "$level", "count": bson.M{"$sum": 1}}},
+			},
+			"byComponent": bson.A{
+				bson.M{"$group": bson.M{"_id": "$component", "count": bson.M{"$sum": 1}}},
+			},
+			"timeline": bson.A{
+				bson.M{"$group": bson.M{
+					"_id": bson.M{
+						"start": bson.M{"$dateTrunc": bson.M{"date": "$timestamp", "unit": interval}},
+						"level": "$level",
+					},
+					"count": bson.M{"$sum": 1},
+				}},
+			},
+			"topErrors": bson.A{
+				bson.M{"$match": bson.M{"level": "ERROR"}},
+				bson.M{"$group": bson.M{
+					"_id":        "$message",
+					"count":      bson.M{"$sum": 1},
+					"components": bson.M{"$addToSet": "$component"},
+					"firstSeen":  bson.M{"$min": "$timestamp"},
+					"lastSeen":   bson.M{"$max": "$timestamp"},
+				}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "lastSeen", Value: -1}}},
+				bson.M{"$limit": topErrors},
+			},
+		}}},
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 30*time.Second)
+	defer cancel()
+
+	cursor, err := m.logsCollection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		m.ErrorContext(c, "Error aggregating logs", "error", err)
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to aggregate logs", "AGGREGATION_FAILED", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var facets logStatsFacets
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&facets); err != nil {
+			m.respondWithError(c, http.StatusInternalServerError, "Failed to decode log statistics", "DECODE_FAILED", err)
+			return
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to aggregate logs", "AGGREGATION_FAILED", err)
+		return
+	}
+
+	m.respondWithSuccess(c, buildLogStatistics(facets, from, to, interval), "")
+}
+
+// buildLogStatistics shapes the aggregation facets into the response, filling
+// the timeline with empty buckets so every interval in the range is present.
+func buildLogStatistics(facets logStatsFacets, from, to time.Time, interval string) LogStatistics {
+	step := granularityStep(interval)
+	start := truncateToGranularity(from, interval)
+
+	stats := LogStatistics{
+		From:        from,
+		To:          to,
+		Interval:    interval,
+		ByLevel:     make(map[string]int64),
+		ByComponent: make(map[string]int64),
+		TopErrors:   facets.TopErrors,
+	}
+	if len(facets.Total) > 0 {
+		stats.Total = facets.Total[0].Count
+	}
+	if len(facets.Recent) > 0 {
+		stats.RecentCount = facets.Recent[0].Count
+	}
+	for _, count := range facets.ByLevel {
+		stats.ByLevel[count.Key] = count.Count
+	}
+	for _, count := range facets.ByComponent {
+		stats.ByComponent[count.Key] = count.Count
+	}
+
+	buckets := make(map[time.Time]*LogStatsBucket)
+	for t := start; t.Before(to); t = t.Add(step) {
+		stats.Timeline = append(stats.Timeline, LogStatsBucket{Start: t, ByLevel: make(map[string]int64)})
+	}
+	for i := range stats.Timeline {
+		buckets[stats.Timeline[i].Start] = &stats.Timeline[i]
+	}
+	for _, count := range facets.Timeline {
+		bucket, ok := buckets[count.Key.Start.UTC()]
+		if !ok {
+			continue
+		}
+		bucket.Total += count.Count
+		bucket.ByLevel[count.Key.Level] = count.Count
+	}
+
+	if stats.Timeline == nil {
+		stats.Timeline = []LogStatsBucket{}
+	}
+	if stats.TopErrors == nil {
+		stats.TopErrors = []LogErrorGroup{}
+	}
+	for i := range stats.TopErrors {
+		stats.TopErrors[i].FirstSeen = stats.TopErrors[i].FirstSeen.UTC()
+		stats.TopErrors[i].LastSeen = stats.TopErrors[i].LastSeen.UTC()
+	}
+
+	return stats
+}
+
+const (
+	LogExportNDJSON = "ndjson"
+	LogExportCSV    = "csv"
+
+	logExportFlushEvery = 1000
+)
+
+var logExportCSVHeader = []string{"id", "timestamp", "level", "component", "message", "userId", "metadata"}
+
+func logEntryCSVRecord(entry LogEntry) []string {
+	metadata := ""
+	if len(entry.Metadata) > 0 {
+		if data, err := json.Marshal(entry.Metadata); err == nil {
+			metadata = string(data)
+		}
+	}
+	return []string{
+		entry.ID.Hex(),
+		entry.Timestamp.UTC().Format(time.RFC3339Nano),
+		entry.Level,
+		entry.Component,
+		entry.Message,
+		entry.UserID,
+		metadata,
+	}
+}
+
+func (m *APIKeyManager) exportLogsHandler(c *gin.Context) {
+	if !m.isMongoConnected() {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", nil)
+		return
+	}
+
+	filter, err := parseLogFilter(c)
+	if err != nil {
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_FILTER", err)
+		return
+	}
+
+	sortDir, err := parseLogSort(c)
+	if err != nil {
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_SORT", err)
+		return
+	}
+
+	format := strings.ToLower(c.DefaultQuery("format", LogExportNDJSON))
+	contentType := "application/x-ndjson"
+	switch format {
+	case LogExportNDJSON:
+	case LogExportCSV:
+		contentType = "text/csv; charset=utf-8"
+	default:
+		m.respondWithError(c, http.StatusBadRequest, fmt.Sprintf("Invalid format '%s': supported formats are ndjson, csv", format), "INVALID_FORMAT", nil)
+		return
+	}
+
+	compress := false
+	if value := c.Query("gzip"); value != "" {
+		if compress, err = strconv.ParseBool(value); err != nil {
+			m.respondWithError(c, http.StatusBadRequest, "Invalid gzip flag", "INVALID_GZIP", err)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: sortDir}, {Key: "_id", Value: sortDir}}).
+		SetBatchSize(logExportFlushEvery)
+
+	cursor, err := m.logsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		m.ErrorContext(c, "Error finding logs for export", "error", err)
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to retrieve logs", "RETRIEVAL_FAILED", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	count, err := writeLogExport(ctx, c, cursor, format, contentType, compress)
+	if err != nil {
+		m.ErrorContext(c, "Log export interrupted", "format", format, "gzip", compress, "count", count, "error", err)
+		return
+	}
+
+	m.InfoContext(c, "Logs exported", "format", format, "gzip", compress, "count", count)
+}
+
+// writeLogExport streams the cursor as an attachment. The response is already
+// committed when a row fails, so the row count and any error are reported in
+// the X-Export-Count and X-Export-Error trailers.
+func writeLogExport(ctx context.Context, c *gin.Context, cursor *mongo.Cursor, format, contentType string, compress bool) (int64, error) {
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	filename := fmt.Sprintf("logs-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	if compress {
+		filename += ".gz"
+		contentType = "application/gzip"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("Trailer", "X-Export-Count, X-Export-Error")
+	c.Status(http.StatusOK)
+
+	var out io.Writer = c.Writer
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(c.Writer)
+		out = gz
+	}
+	buffered := bufio.NewWriterSize(out, 64*1024)
+
+	var (
+		encoder   *json.Encoder
+		csvWriter *csv.Writer
+	)
+	if format == LogExportCSV {
+		csvWriter = csv.NewWriter(buffered)
+		csvWriter.Write(logExportCSVHeader)
+	} else {
+		encoder = json.NewEncoder(buffered)
+	}
+
+	flush := func() error {
+		if csvWriter != nil {
+			csvWriter.Flush()
+			if err := csvWriter.Error(); err != nil {
+				return err
+			}
+		}
+		if err := buffered.Flush(); err != nil {
+			return err
+		}
+		if gz != nil {
+			if err := gz.Flush(); err != nil {
+				return err
+			}
+		}
+		c.Writer.Flush()
+		return nil
+	}
+
+	var count int64
+	var exportErr error
+	for cursor.Next(ctx) {
+		var entry LogEntry
+		if exportErr = cursor.Decode(&entry); exportErr != nil {
+			break
+		}
+
+		if csvWriter != nil {
+			exportErr = csvWriter.Write(logEntryCSVRecord(entry))
+		} else {
+			exportErr = encoder.Encode(entry)
+		}
+		if exportErr != nil {
+			break
+		}
+
+		count++
+		if count%logExportFlushEvery == 0 {
+			if exportErr = flush(); exportErr != nil {
+				break
+			}
+		}
+	}
+	if exportErr == nil {
+		exportErr = cursor.Err()
+	}
+
+	if err := flush(); err != nil && exportErr == nil {
+		exportErr = err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil && exportErr == nil {
+			exportErr = err
+		}
+	}
+
+	c.Writer.Header().Set("X-Export-Count", strconv.FormatInt(count, 10))
+	if exportErr != nil {
+		c.Writer.Header().Set("X-Export-Error", exportErr.Error())
+	}
+	return count, exportErr
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func logTestContext(rawQuery string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/logs?"+rawQuery, nil)
+	return c, rec
+}
+
+func TestParseLogFilter(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		query   url.Values
+		want    bson.M
+		wantErr string
+	}{
+		{
+			name:  "empty",
+			query: url.Values{},
+			want:  bson.M{},
+		},
+		{
+			name:  "levels are upper-cased and all is ignored",
+			query: url.Values{"level": {"error,warn", "all"}, "component": {"http"}},
+			want: bson.M{
+				"level":     bson.M{"$in": []string{"ERROR", "WARN"}},
+				"component": bson.M{"$in": []string{"http"}},
+			},
+		},
+		{
+			name:  "search is regex escaped",
+			query: url.Values{"search": {"a.b(c*"}},
+			want: bson.M{"$or": []bson.M{
+				{"message": bson.M{"$regex": `a\.b\(c\*`, "$options": "i"}},
+				{"component": bson.M{"$regex": `a\.b\(c\*`, "$options": "i"}},
+			}},
+		},
+		{
+			name:  "metadata values match as string, number and bool",
+			query: url.Values{"metadata.status": {"1"}, "metadata.req.ip": {"10.0.0.1"}},
+			want: bson.M{
+				"metadata.status": bson.M{"$in": []interface{}{"1", int64(1), true}},
+				"metadata.req.ip": bson.M{"$in": []interface{}{"10.0.0.1"}},
+			},
+		},
+		{
+			name:    "metadata operator field rejected",
+			query:   url.Values{"metadata.$where": {"1"}},
+			wantErr: "invalid metadata field",
+		},
+		{
+			name:    "metadata empty path segment rejected",
+			query:   url.Values{"metadata.a..b": {"1"}},
+			wantErr: "invalid metadata field",
+		},
+		{
+			name:  "from and to",
+			query: url.Values{"from": {from.Format(time.RFC3339)}, "to": {to.Format(time.RFC3339)}},
+			want:  bson.M{"timestamp": bson.M{"$gte": from, "$lt": to}},
+		},
+		{
+			name:  "from only",
+			query: url.Values{"from": {"2026-01-01T02:00:00+02:00"}},
+			want:  bson.M{"timestamp": bson.M{"$gte": from}},
+		},
+		{
+			name:    "from equal to to",
+			query:   url.Values{"from": {from.Format(time.RFC3339)}, "to": {from.Format(time.RFC3339)}},
+			wantErr: "'from' must be before 'to'",
+		},
+		{
+			name:    "invalid to",
+			query:   url.Values{"to": {"yesterday"}},
+			wantErr: "invalid 'to' timestamp",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := logTestContext(tt.query.Encode())
+			got, err := parseLogFilter(c)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseLogFilter() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseLogFilter() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parseLogFilter() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogCursorRoundTrip(t *testing.T) {
+	entry := LogEntry{ID: primitive.NewObjectID(), Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 678000000, time.UTC)}
+
+	cursor, err := decodeLogCursor(encodeLogCursor(entry))
+	if err != nil {
+		t.Fatalf("decodeLogCursor() error = %v", err)
+	}
+	if cursor.ID != entry.ID || !cursor.Timestamp.Equal(entry.Timestamp) {
+		t.Fatalf("decodeLogCursor() = %+v, want %v/%v", cursor, entry.ID, entry.Timestamp)
+	}
+
+	for _, value := range []string{"not base64!", "bm90IGpzb24", encodeLogCursor(LogEntry{Timestamp: entry.Timestamp})} {
+		if _, err := decodeLogCursor(value); err == nil {
+			t.Errorf("decodeLogCursor(%q) error = nil, want invalid cursor", value)
+		}
+	}
+}
+
+func TestApplyLogCursor(t *testing.T) {
+	cursor := &logCursor{Timestamp: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC), ID: primitive.NewObjectID()}
+	after := func(op string) bson.M {
+		return bson.M{"$or": []bson.M{
+			{"timestamp": bson.M{op: cursor.Timestamp}},
+			{"timestamp": cursor.Timestamp, "_id": bson.M{op: cursor.ID}},
+		}}
+	}
+	filter := bson.M{"level": "ERROR"}
+
+	tests := []struct {
+		name    string
+		filter  bson.M
+		sortDir int
+		want    bson.M
+	}{
+		{name: "descending pages backwards", filter: bson.M{}, sortDir: -1, want: after("$lt")},
+		{name: "ascending pages forwards", filter: bson.M{}, sortDir: 1, want: after("$gt")},
+		{name: "combined with filter", filter: filter, sortDir: -1, want: bson.M{"$and": []bson.M{filter, after("$lt")}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := applyLogCursor(tt.filter, cursor, tt.sortDir); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("applyLogCursor() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildLogStatisticsFillsTimeline(t *testing.T) {
+	from := time.Date(2026, 1, 1, 10, 30, 0, 0, time.UTC)
+	to := time.Date(2026, 1, 1, 13, 0, 0, 0, time.UTC)
+
+	timeline := func(start time.Time, level string, count int64) logStatsTimelineCount {
+		var tc logStatsTimelineCount
+		tc.Key.Start = start
+		tc.Key.Level = level
+		tc.Count = count
+		return tc
+	}
+	facets := logStatsFacets{
+		Total:   []logStatsCount{{Count: 7}},
+		ByLevel: []logStatsCount{{Key: "INFO", Count: 3}, {Key: "ERROR", Count: 1}, {Key: "WARN", Count: 2}},
+		Timeline: []logStatsTimelineCount{
+			timeline(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC), "INFO", 3),
+			timeline(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC), "ERROR", 1),
+			timeline(time.Date(2026, 1, 1, 14, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60)), "WARN", 2),
+			timeline(time.Date(2026, 1, 1, 15, 0, 0, 0, time.UTC), "INFO", 1),
+		},
+	}
+
+	stats := buildLogStatistics(facets, from, to, GranularityHour)
+
+	wantTotals := []int64{4, 0, 2}
+	if len(stats.Timeline) != len(wantTotals) {
+		t.Fatalf("timeline = %+v, want %d hourly buckets", stats.Timeline, len(wantTotals))
+	}
+	for i, want := range wantTotals {
+		bucket := stats.Timeline[i]
+		if wantStart := time.Date(2026, 1, 1, 10+i, 0, 0, 0, time.UTC); !bucket.Start.Equal(wantStart) {
+			t.Fatalf("bucket %d start = %v, want %v", i, bucket.Start, wantStart)
+		}
+		if bucket.Total != want || bucket.ByLevel == nil {
+			t.Fatalf("bucket %d = %+v, want total %d and a non-nil byLevel", i, bucket, want)
+		}
+	}
+	if stats.Timeline[0].ByLevel["ERROR"] != 1 || stats.Timeline[2].ByLevel["WARN"] != 2 {
+		t.Fatalf("timeline levels = %+v", stats.Timeline)
+	}
+	if stats.Total != 7 || stats.ByLevel["WARN"] != 2 || stats.TopErrors == nil {
+		t.Fatalf("stats = %+v, want facet totals and an empty topErrors list", stats)
+	}
+}
+
+func logExportCursor(t *testing.T, docs ...interface{}) *mongo.Cursor {
+	t.Helper()
+	cursor, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+	if err != nil {
+		t.Fatalf("NewCursorFromDocuments() error = %v", err)
+	}
+	return cursor
+}
+
+func TestWriteLogExport(t *testing.T) {
+	at := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	entries := []LogEntry{
+		{ID: primitive.NewObjectID(), Level: "INFO", Message: "started", Component: "server", Timestamp: at},
+		{ID: primitive.NewObjectID(), Level: "ERROR", Message: "failed, \"badly\"", Component: "http", Timestamp: at.Add(time.Second), Metadata: bson.M{"status": 500}},
+	}
+	docs := []interface{}{entries[0], entries[1]}
+
+	tests := []struct {
+		name        string
+		format      string
+		contentType string
+		compress    bool
+		check       func(t *testing.T, body string)
+	}{
+		{
+			name:        "ndjson",
+			format:      LogExportNDJSON,
+			contentType: "application/x-ndjson",
+			check: func(t *testing.T, body string) {
+				scanner := bufio.NewScanner(strings.NewReader(body))
+				var got []LogEntry
+				for scanner.Scan() {
+					var entry LogEntry
+					if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+						t.Fatalf("line %q is not JSON: %v", scanner.Text(), err)
+					}
+					got = append(got, entry)
+				}
+				if len(got) != 2 || got[0].ID != entries[0].ID || got[1].Message != entries[1].Message {
+					t.Fatalf("ndjson entries = %+v", got)
+				}
+			},
+		},
+		{
+			name:        "csv",
+			format:      LogExportCSV,
+			contentType: "text/csv; charset=utf-8",
+			check: func(t *testing.T, body string) {
+				rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+				if err != nil {
+					t.Fatalf("csv parse error = %v", err)
+				}
+				if len(rows) != 3 || !reflect.DeepEqual(rows[0], logExportCSVHeader) {
+					t.Fatalf("csv rows = %v, want a header and two rows", rows)
+				}
+				if rows[2][0] != entries[1].ID.Hex() || rows[2][4] != entries[1].Message || rows[2][6] != `{"status":500}` {
+					t.Fatalf("csv row = %v", rows[2])
+				}
+			},
+		},
+		{
+			name:        "gzip csv",
+			format:      LogExportCSV,
+			contentType: "text/csv; charset=utf-8",
+			compress:    true,
+			check: func(t *testing.T, body string) {
+				rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+				if err != nil || len(rows) != 3 {
+					t.Fatalf("decompressed csv = %v (%v), want a header and two rows", rows, err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := logTestContext("")
+			count, err := writeLogExport(context.Background(), c, logExportCursor(t, docs...), tt.format, tt.contentType, tt.compress)
+			if err != nil || count != 2 {
+				t.Fatalf("writeLogExport() = %d, %v, want 2 rows", count, err)
+			}
+
+			res := rec.Result()
+			body, _ := io.ReadAll(res.Body)
+			wantType := tt.contentType
+			if tt.compress {
+				wantType = "application/gzip"
+				zr, err := gzip.NewReader(strings.NewReader(string(body)))
+				if err != nil {
+					t.Fatalf("gzip.NewReader() error = %v", err)
+				}
+				body, _ = io.ReadAll(zr)
+			}
+			if got := res.Header.Get("Content-Type"); got != wantType {
+				t.Fatalf("Content-Type = %q, want %q", got, wantType)
+			}
+			if got := res.Trailer.Get("X-Export-Count"); got != "2" {
+				t.Fatalf("X-Export-Count trailer = %q, want 2", got)
+			}
+			if got := res.Trailer.Get("X-Export-Error"); got != "" {
+				t.Fatalf("X-Export-Error trailer = %q, want none", got)
+			}
+			tt.check(t, string(body))
+		})
+	}
+}
+
+func TestWriteLogExportReportsErrorInTrailer(t *testing.T) {
+	c, rec := logTestContext("")
+	cursor := logExportCursor(t,
+		LogEntry{ID: primitive.NewObjectID(), Level: "INFO", Message: "ok", Timestamp: time.Now().UTC()},
+		bson.M{"_id": primitive.NewObjectID(), "timestamp": "not a date"},
+		LogEntry{ID: primitive.NewObjectID(), Level: "INFO", Message: "never written", Timestamp: time.Now().UTC()},
+	)
+
+	count, err := writeLogExport(context.Background(), c, cursor, LogExportNDJSON, "application/x-ndjson", false)
+	if err == nil || count != 1 {
+		t.Fatalf("writeLogExport() = %d, %v, want 1 row and a decode error", count, err)
+	}
+
+	res := rec.Result()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || strings.Count(string(body), "\n") != 1 || strings.Contains(string(body), "never written") {
+		t.Fatalf("status %d body %q, want the rows before the failure", res.StatusCode, body)
+	}
+	if got := res.Trailer.Get("X-Export-Count"); got != "1" {
+		t.Fatalf("X-Export-Count trailer = %q, want 1", got)
+	}
+	if got := res.Trailer.Get("X-Export-Error"); got == "" {
+		t.Fatal("X-Export-Error trailer is empty, want the decode error")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logTailBufferSize = 1000
+	maxLogTailBacklog = logTailBufferSize
+)
+
+type LogTailFilter struct {
+	Levels     []string `json:"levels,omitempty"`
+	Components []string `json:"components,omitempty"`
+	Search     string   `json:"search,omitempty"`
+}
+
+func (f *LogTailFilter) normalize() {
+	levels := f.Levels[:0]
+	for _, level := range f.Levels {
+		level = strings.ToUpper(strings.TrimSpace(level))
+		if level != "" && level != "ALL" {
+			levels = append(levels, level)
+		}
+	}
+	f.Levels = levels
+
+	components := f.Components[:0]
+	for _, component := range f.Components {
+		component = strings.TrimSpace(component)
+		if component != "" && !strings.EqualFold(component, "all") {
+			components = append(components, component)
+		}
+	}
+	f.Components = components
+
+	f.Search = strings.ToLower(strings.TrimSpace(f.Search))
+}
+
+func (m *APIKeyManager) hasLogSubscribers() bool {
+	subscribed := false
+	m.wsClients.Range(func(_, value interface{}) bool {
+		if wsClient, ok := value.(*WSClient); ok {
+			wsClient.subMu.RLock()
+			subscribed = wsClient.subscribedTo(TopicLogs)
+			wsClient.subMu.RUnlock()
+		}
+		return !subscribed
+	})
+	return subscribed
+}
+
+func (f *LogTailFilter) Matches(entry LogEntry) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.Levels) > 0 && !containsString(f.Levels, entry.Level) {
+		return false
+	}
+	if len(f.Components) > 0 && !containsString(f.Components, entry.Component) {
+		return false
+	}
+	if f.Search != "" &&
+		!strings.Contains(strings.ToLower(entry.Message), f.Search) &&
+		!strings.Contains(strings.ToLower(entry.Component), f.Search) {
+		return false
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type LogTailBuffer struct {
+	mu      sync.RWMutex
+	entries []LogEntry
+	next    int
+	full    bool
+}
+
+func NewLogTailBuffer(size int) *LogTailBuffer {
+	return &LogTailBuffer{entries: make([]LogEntry, size)}
+}
+
+func (b *LogTailBuffer) Add(entry LogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+func (b *LogTailBuffer) Recent(filter *LogTailFilter, limit int) []LogEntry {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	count := b.next
+	if b.full {
+		count = len(b.entries)
+	}
+
+	matches := make([]LogEntry, 0)
+	for i := 0; i < count && len(matches) < limit; i++ {
+		idx := (b.next - 1 - i + len(b.entries)) % len(b.entries)
+		if filter.Matches(b.entries[idx]) {
+			matches = append(matches, b.entries[idx])
+		}
+	}
+
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+	return matches
+}
+
+func (wsc *WSClient) setLogFilter(filter *LogTailFilter) {
+	if filter != nil {
+		filter.normalize()
+	}
+
+	wsc.subMu.Lock()
+	wsc.logFilter = filter
+	wsc.subMu.Unlock()
+}
+
+func (m *APIKeyManager) sendLogBacklog(wsClient *WSClient, backlog int) int {
+	if backlog <= 0 {
+		return 0
+	}
+	if backlog > maxLogTailBacklog {
+		backlog = maxLogTailBacklog
+	}
+
+	wsClient.subMu.RLock()
+	filter := wsClient.logFilter
+	wsClient.subMu.RUnlock()
+
+	entries := m.logTail.Recent(filter, backlog)
+	if len(entries) == 0 {
+		return 0
+	}
+
+	wsClient.Send(WSMessage{
+		Type:      "log_backlog",
+		Data:      entries,
+		Timestamp: time.Now().UTC(),
+		ID:        generateRequestID(),
+		Topic:     TopicLogs,
+	})
+	return len(entries)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestHasLogSubscribers(t *testing.T) {
+	m := &APIKeyManager{}
+	if m.hasLogSubscribers() {
+		t.Fatal("hasLogSubscribers() = true with no clients")
+	}
+
+	client := &WSClient{clientID: "c1"}
+	client.updateTopics([]string{TopicKeys}, nil)
+	m.wsClients.Store(client.clientID, client)
+	if m.hasLogSubscribers() {
+		t.Fatal("hasLogSubscribers() = true for a keys-only client")
+	}
+
+	client.updateTopics([]string{TopicLogs}, nil)
+	if !m.hasLogSubscribers() {
+		t.Fatal("hasLogSubscribers() = false after subscribing to logs")
+	}
+}
+
+func TestLogTailFilterNormalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter LogTailFilter
+		want   LogTailFilter
+	}{
+		{
+			name:   "levels are upper-cased and ALL is dropped",
+			filter: LogTailFilter{Levels: []string{" error ", "all", "Warn", ""}},
+			want:   LogTailFilter{Levels: []string{"ERROR", "WARN"}, Components: []string{}},
+		},
+		{
+			name:   "components keep their case and all is dropped in any case",
+			filter: LogTailFilter{Components: []string{"auth", " all", "ALL", "Billing", " "}},
+			want:   LogTailFilter{Levels: []string{}, Components: []string{"auth", "Billing"}},
+		},
+		{
+			name:   "search is trimmed and lower-cased",
+			filter: LogTailFilter{Search: "  Key Created "},
+			want:   LogTailFilter{Levels: []string{}, Components: []string{}, Search: "key created"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := tt.filter
+			filter.normalize()
+			if filter.Levels == nil {
+				filter.Levels = []string{}
+			}
+			if filter.Components == nil {
+				filter.Components = []string{}
+			}
+			if !reflect.DeepEqual(filter, tt.want) {
+				t.Fatalf("normalize() = %+v, want %+v", filter, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogTailFilterMatches(t *testing.T) {
+	entry := LogEntry{Level: "ERROR", Component: "auth", Message: "Invalid API Key presented"}
+
+	tests := []struct {
+		name   string
+		filter *LogTailFilter
+		want   bool
+	}{
+		{name: "nil filter", filter: nil, want: true},
+		{name: "empty filter", filter: &LogTailFilter{}, want: true},
+		{name: "ALL level", filter: &LogTailFilter{Levels: []string{"ALL"}}, want: true},
+		{name: "all component", filter: &LogTailFilter{Components: []string{"all"}}, want: true},
+		{name: "matching level", filter: &LogTailFilter{Levels: []string{"warn", "error"}}, want: true},
+		{name: "other level", filter: &LogTailFilter{Levels: []string{"INFO"}}, want: false},
+		{name: "matching component", filter: &LogTailFilter{Components: []string{"billing", "auth"}}, want: true},
+		{name: "other component", filter: &LogTailFilter{Components: []string{"billing"}}, want: false},
+		{name: "component case differs", filter: &LogTailFilter{Components: []string{"Auth"}}, want: false},
+		{name: "search message ignoring case", filter: &LogTailFilter{Search: "INVALID api"}, want: true},
+		{name: "search component", filter: &LogTailFilter{Search: "AUTH"}, want: true},
+		{name: "search misses", filter: &LogTailFilter{Search: "quota"}, want: false},
+		{name: "all conditions", filter: &LogTailFilter{Levels: []string{"error"}, Components: []string{"auth"}, Search: "key"}, want: true},
+		{name: "one condition fails", filter: &LogTailFilter{Levels: []string{"error"}, Components: []string{"auth"}, Search: "quota"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.filter != nil {
+				tt.filter.normalize()
+			}
+			if got := tt.filter.Matches(entry); got != tt.want {
+				t.Fatalf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestLogTail(size, added int) *LogTailBuffer {
+	buf := NewLogTailBuffer(size)
+	for i := 0; i < added; i++ {
+		level := "INFO"
+		if i%2 == 1 {
+			level = "ERROR"
+		}
+		buf.Add(LogEntry{Level: level, Message: fmt.Sprintf("entry %d", i)})
+	}
+	return buf
+}
+
+func logTailMessages(entries []LogEntry) []string {
+	messages := make([]string, len(entries))
+	for i, entry := range entries {
+		messages[i] = entry.Message
+	}
+	return messages
+}
+
+func TestLogTailBufferRecent(t *testing.T) {
+	errorsOnly := &LogTailFilter{Levels: []string{"ERROR"}}
+
+	tests := []struct {
+		name   string
+		added  int
+		filter *LogTailFilter
+		limit  int
+		want   []string
+	}{
+		{name: "empty", added: 0, limit: 5, want: []string{}},
+		{name: "partly filled", added: 3, limit: 5, want: []string{"entry 0", "entry 1", "entry 2"}},
+		{name: "limit keeps the newest", added: 3, limit: 2, want: []string{"entry 1", "entry 2"}},
+		{name: "exactly full", added: 4, limit: 10, want: []string{"entry 0", "entry 1", "entry 2", "entry 3"}},
+		{name: "wrapped", added: 6, limit: 10, want: []string{"entry 2", "entry 3", "entry 4", "entry 5"}},
+		{name: "wrapped twice", added: 9, limit: 3, want: []string{"entry 6", "entry 7", "entry 8"}},
+		{name: "wrapped and filtered", added: 7, filter: errorsOnly, limit: 10, want: []string{"entry 3", "entry 5"}},
+		{name: "filter and limit", added: 7, filter: errorsOnly, limit: 1, want: []string{"entry 5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := newTestLogTail(4, tt.added)
+			got := logTailMessages(buf.Recent(tt.filter, tt.limit))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Recent() = %v, want %v oldest first", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendLogBacklogClampsLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		backlog int
+		want    int
+	}{
+		{name: "negative", backlog: -1, want: 0},
+		{name: "zero", backlog: 0, want: 0},
+		{name: "within the buffer", backlog: 10, want: 10},
+		{name: "above the maximum", backlog: maxLogTailBacklog * 5, want: maxLogTailBacklog},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &APIKeyManager{logTail: newTestLogTail(logTailBufferSize, logTailBufferSize+50)}
+			wsClient := NewWSClient(nil, "c1", &Config{WSSendQueueSize: 1})
+
+			if got := m.sendLogBacklog(wsClient, tt.backlog); got != tt.want {
+				t.Fatalf("sendLogBacklog(%d) = %d, want %d", tt.backlog, got, tt.want)
+			}
+			if tt.want == 0 {
+				if queued := wsClient.Stats().Queued; queued != 0 {
+					t.Fatalf("sendLogBacklog(%d) queued %d messages, want none", tt.backlog, queued)
+				}
+				return
+			}
+
+			message := <-wsClient.send
+			entries, _ := message.Data.([]LogEntry)
+			if message.Type != "log_backlog" || len(entries) != tt.want {
+				t.Fatalf("sent %q with %d entries, want log_backlog with %d", message.Type, len(entries), tt.want)
+			}
+			if last := entries[len(entries)-1].Message; last != fmt.Sprintf("entry %d", logTailBufferSize+49) {
+				t.Fatalf("last backlog entry = %q, want the newest", last)
+			}
+		})
+	}
+}
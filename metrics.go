@@ -0,0 +1,167 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpLatencyBuckets  = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	mongoLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 5}
+)
+
+type Metrics struct {
+	registry      *prometheus.Registry
+	httpRequests  *prometheus.CounterVec
+	httpDuration  *prometheus.HistogramVec
+	mongoDuration *prometheus.HistogramVec
+	mongoErrors   *prometheus.CounterVec
+	mongoRetries  *prometheus.CounterVec
+	eventsDropped *prometheus.CounterVec
+	verifications *prometheus.CounterVec
+}
+
+func NewMetrics() *Metrics {
+	mt := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apikeys_http_requests_total",
+			Help: "Total HTTP requests by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "apikeys_http_request_duration_seconds",
+			Help:    "HTTP request latency by route, method and status.",
+			Buckets: httpLatencyBuckets,
+		}, []string{"route", "method", "status"}),
+		mongoDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "apikeys_mongo_operation_duration_seconds",
+			Help:    "MongoDB operation latency per attempt.",
+			Buckets: mongoLatencyBuckets,
+		}, []string{"operation", "result"}),
+		mongoErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apikeys_mongo_operation_errors_total",
+			Help: "MongoDB operations that failed after all retries.",
+		}, []string{"operation"}),
+		mongoRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apikeys_mongo_operation_retries_total",
+			Help: "MongoDB operation attempts that were retried.",
+		}, []string{"operation"}),
+		eventsDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apikeys_events_dropped_total",
+			Help: "Events dropped before reaching a client.",
+		}, []string{"reason"}),
+		verifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apikeys_verifications_total",
+			Help: "API key verification outcomes by plan.",
+		}, []string{"plan", "outcome"}),
+	}
+	mt.registry.MustRegister(
+		mt.httpRequests,
+		mt.httpDuration,
+		mt.mongoDuration,
+		mt.mongoErrors,
+		mt.mongoRetries,
+		mt.eventsDropped,
+		mt.verifications,
+	)
+	return mt
+}
+
+func metricsRoute(c *gin.Context) string {
+	if route := c.FullPath(); route != "" {
+		return route
+	}
+	return "unmatched"
+}
+
+func (m *APIKeyManager) observeRequest(c *gin.Context, latency time.Duration) {
+	route := metricsRoute(c)
+	status := strconv.Itoa(c.Writer.Status())
+	m.metrics.httpRequests.WithLabelValues(route, c.Request.Method, status).Inc()
+	m.metrics.httpDuration.WithLabelValues(route, c.Request.Method, status).Observe(latency.Seconds())
+}
+
+func (m *APIKeyManager) observeVerification(result VerifyKeyResponse) {
+	plan := ""
+	if result.apiKey != nil {
+		plan = result.apiKey.Plan
+	}
+	if plan == "" {
+		plan = "none"
+	}
+	m.metrics.verifications.WithLabelValues(plan, verificationOutcome(result)).Inc()
+}
+
+func (m *APIKeyManager) registerMetrics() {
+	gauge := func(name, help string, labels prometheus.Labels, value func() float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help, ConstLabels: labels}, value)
+	}
+	counter := func(name, help string, value func() float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, value)
+	}
+
+	m.metrics.registry.MustRegister(
+		counter("apikeys_cache_hits_total", "API key cache hits.", func() float64 {
+			return float64(atomic.LoadInt64(&m.cache.metrics.hits))
+		}),
+		counter("apikeys_cache_misses_total", "API key cache misses.", func() float64 {
+			return float64(atomic.LoadInt64(&m.cache.metrics.misses))
+		}),
+		counter("apikeys_audit_write_failures_total", "Audit entries that could not be written to the store directly.", func() float64 {
+			return float64(m.auditStats().FailedWrites)
+		}),
+		counter("apikeys_audit_overflow_total", "Audit entries written synchronously because the write queue was full.", func() float64 {
+			return float64(m.auditStats().Overflowed)
+		}),
+		gauge("apikeys_audit_spooled", "Audit entries spooled on disk awaiting replay.", nil, func() float64 {
+			return float64(m.auditStats().Spooled)
+		}),
+		gauge("apikeys_cache_size", "API keys held in the cache.", nil, func() float64 {
+			return float64(m.cache.Size())
+		}),
+		gauge("apikeys_event_queue_length", "Events waiting in the broadcaster queue.", nil, func() float64 {
+			return float64(len(m.eventChan))
+		}),
+		gauge("apikeys_mongo_up", "Whether the MongoDB connection is healthy.", nil, func() float64 {
+			if m.isMongoConnected() {
+				return 1
+			}
+			return 0
+		}),
+		gauge("apikeys_goroutines", "Number of goroutines.", nil, func() float64 {
+			return float64(runtime.NumGoroutine())
+		}),
+		gauge("apikeys_memory_alloc_bytes", "Bytes of allocated heap objects.", nil, func() float64 {
+			var memStats runtime.MemStats
+			runtime.ReadMemStats(&memStats)
+			return float64(memStats.Alloc)
+		}),
+		gauge("apikeys_uptime_seconds", "Seconds since the server started.", nil, func() float64 {
+			return time.Since(m.startTime).Seconds()
+		}),
+	)
+
+	for _, transport := range []string{TransportSSE, TransportWebSocket} {
+		transport := transport
+		m.metrics.registry.MustRegister(gauge("apikeys_realtime_clients", "Connected realtime clients by transport.", prometheus.Labels{"transport": transport}, func() float64 {
+			clients := 0
+			m.wsClients.Range(func(_, value interface{}) bool {
+				if wsClient, ok := value.(*WSClient); ok && wsClient.transport == transport {
+					clients++
+				}
+				return true
+			})
+			return float64(clients)
+		}))
+	}
+}
+
+func (m *APIKeyManager) metricsHandler(c *gin.Context) {
+	promhttp.HandlerFor(m.metrics.registry, promhttp.HandlerOpts{}).ServeHTTP(c.Writer, c.Request)
+}
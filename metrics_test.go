@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetricsHandlerExposition(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := &APIKeyManager{
+		cache:     &Cache{},
+		eventChan: make(chan WSMessage, 1),
+		metrics:   NewMetrics(),
+		startTime: time.Now(),
+	}
+	m.registerMetrics()
+
+	m.metrics.httpRequests.WithLabelValues("/api/keys", "GET", "200").Inc()
+	m.metrics.mongoDuration.WithLabelValues("getAPIKey", "success").Observe(0.002)
+	m.metrics.eventsDropped.WithLabelValues("queue_full").Inc()
+
+	router := gin.New()
+	router.GET("/metrics", m.metricsHandler)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /metrics status = %d, want 200", recorder.Code)
+	}
+	body, _ := io.ReadAll(recorder.Body)
+	for _, want := range []string{
+		`apikeys_http_requests_total{method="GET",route="/api/keys",status="200"} 1`,
+		`apikeys_mongo_operation_duration_seconds_bucket{operation="getAPIKey",result="success",le="0.0025"} 1`,
+		`apikeys_events_dropped_total{reason="queue_full"} 1`,
+		`apikeys_realtime_clients{transport="websocket"} 0`,
+		"# TYPE apikeys_cache_hits_total counter",
+		"apikeys_mongo_up 0",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
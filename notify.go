@@ -0,0 +1,697 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	NotifyChannelWebhook   = "webhook"
+	NotifyChannelSMTP      = "smtp"
+	NotifyChannelWebSocket = "websocket"
+
+	NotificationKeyExpiring  = "key_expiring"
+	NotificationQuotaReached = "quota_threshold"
+
+	defaultNotifyScanInterval = 300
+	defaultNotifyCollection   = "notifications"
+	notifySendTimeout         = 10 * time.Second
+	notifyHistorySize         = 100
+	notifyQueueSize           = 100
+	notifyMaxAttempts         = 4
+	notifyRetryBackoff        = time.Second
+)
+
+var (
+	defaultExpiryDays      = []int{7, 1}
+	defaultQuotaThresholds = []int{80, 100}
+)
+
+type NotifyConfig struct {
+	ExpiryDays      []int                 `json:"expiryDays"`
+	QuotaThresholds []int                 `json:"quotaThresholds"`
+	ScanInterval    int                   `json:"scanInterval"`
+	Collection      string                `json:"collection"`
+	Channels        []NotifyChannelConfig `json:"channels"`
+}
+
+type NotifyChannelConfig struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Events   []string `json:"events"`
+	URL      string   `json:"url"`
+	Secret   string   `json:"secret"`
+	Address  string   `json:"address"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+}
+
+type Notification struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Severity  string    `json:"severity"`
+	KeyID     string    `json:"keyId"`
+	KeyName   string    `json:"keyName,omitempty"`
+	Threshold int       `json:"threshold"`
+	Message   string    `json:"message"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	Used      int64     `json:"used,omitempty"`
+	Limit     int64     `json:"limit,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type notificationRecord struct {
+	ID        string    `bson:"_id"`
+	Type      string    `bson:"type"`
+	KeyID     string    `bson:"keyId"`
+	Threshold int       `bson:"threshold"`
+	FiredAt   time.Time `bson:"firedAt"`
+	ExpireAt  time.Time `bson:"expireAt"`
+}
+
+type NotificationChannel interface {
+	Send(ctx context.Context, n Notification) error
+	Target() string
+}
+
+type notificationStore interface {
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+}
+
+type NotificationChannelStats struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Target    string   `json:"target"`
+	Events    []string `json:"events,omitempty"`
+	Queued    int      `json:"queued"`
+	Sent      int64    `json:"sent"`
+	Failed    int64    `json:"failed"`
+	Dropped   int64    `json:"dropped"`
+	LastError string   `json:"lastError,omitempty"`
+}
+
+type notifyChannel struct {
+	name    string
+	kind    string
+	events  []string
+	channel NotificationChannel
+	queue   chan Notification
+
+	sent      int64
+	failed    int64
+	dropped   int64
+	errMu     sync.Mutex
+	lastError string
+}
+
+type Notifier struct {
+	m          *APIKeyManager
+	config     NotifyConfig
+	channels   []*notifyChannel
+	collection notificationStore
+
+	mu      sync.Mutex
+	fired   map[string]time.Time
+	history []Notification
+}
+
+type webhookNotifyChannel struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func (w *webhookNotifyChannel) Target() string {
+	return w.url
+}
+
+func (w *webhookNotifyChannel) Send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", n.Type)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if w.secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+signWebhookPayload(w.secret, timestamp, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type smtpNotifyChannel struct {
+	address string
+	from    string
+	to      []string
+	auth    smtp.Auth
+}
+
+func (s *smtpNotifyChannel) Target() string {
+	return s.address
+}
+
+// stripLineBreaks keeps key names and messages from starting new header or
+// body lines in outgoing mail.
+func stripLineBreaks(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+func (s *smtpNotifyChannel) message(n Notification) []byte {
+	subject := fmt.Sprintf("[%s] %s", strings.ToUpper(n.Severity), stripLineBreaks(n.Message))
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.Timestamp.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\n", stripLineBreaks(n.Message))
+	fmt.Fprintf(&msg, "Key: %s\r\n", n.KeyID)
+	if n.KeyName != "" {
+		fmt.Fprintf(&msg, "Name: %s\r\n", stripLineBreaks(n.KeyName))
+	}
+	if !n.ExpiresAt.IsZero() {
+		fmt.Fprintf(&msg, "Expires: %s\r\n", n.ExpiresAt.Format(time.RFC3339))
+	}
+	if n.Limit > 0 {
+		fmt.Fprintf(&msg, "Usage: %d / %d\r\n", n.Used, n.Limit)
+	}
+	return msg.Bytes()
+}
+
+func (s *smtpNotifyChannel) Send(ctx context.Context, n Notification) error {
+	msg := s.message(n)
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	host, _, err := net.SplitHostPort(s.address)
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := client.Auth(s.auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(s.from); err != nil {
+		return err
+	}
+	for _, to := range s.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+type websocketNotifyChannel struct {
+	m *APIKeyManager
+}
+
+func (w *websocketNotifyChannel) Target() string {
+	return "dashboard"
+}
+
+func (w *websocketNotifyChannel) Send(ctx context.Context, n Notification) error {
+	w.m.broadcastEvent(WSMessage{
+		Type:      "notification",
+		Data:      n,
+		Timestamp: n.Timestamp,
+		ID:        n.ID,
+		Topic:     TopicSystem,
+	})
+	return nil
+}
+
+func normalizeThresholds(values []int, defaults []int, max int) ([]int, error) {
+	if len(values) == 0 {
+		values = defaults
+	}
+	seen := make(map[int]bool)
+	thresholds := make([]int, 0, len(values))
+	for _, value := range values {
+		if value <= 0 || (max > 0 && value > max) {
+			return nil, fmt.Errorf("invalid threshold %d", value)
+		}
+		if !seen[value] {
+			seen[value] = true
+			thresholds = append(thresholds, value)
+		}
+	}
+	sort.Ints(thresholds)
+	return thresholds, nil
+}
+
+func newNotificationChannel(m *APIKeyManager, config NotifyChannelConfig) (NotificationChannel, error) {
+	switch strings.ToLower(config.Type) {
+	case NotifyChannelWebhook:
+		if _, err := validateWebhookURL(config.URL, m.webhooks.targets); err != nil {
+			return nil, err
+		}
+		return &webhookNotifyChannel{
+			url:    config.URL,
+			secret: config.Secret,
+			client: m.webhooks.targets.client(notifySendTimeout),
+		}, nil
+	case NotifyChannelSMTP:
+		if config.Address == "" || config.From == "" || len(config.To) == 0 {
+			return nil, errors.New("smtp channel requires address, from and to")
+		}
+		channel := &smtpNotifyChannel{address: config.Address, from: config.From, to: config.To}
+		if config.Username != "" {
+			host := config.Address
+			if i := strings.LastIndex(host, ":"); i >= 0 {
+				host = host[:i]
+			}
+			channel.auth = smtp.PlainAuth("", config.Username, config.Password, host)
+		}
+		return channel, nil
+	case NotifyChannelWebSocket:
+		return &websocketNotifyChannel{m: m}, nil
+	default:
+		return nil, fmt.Errorf("unsupported channel type '%s': supported types are webhook, smtp, websocket", config.Type)
+	}
+}
+
+func NewNotifier(m *APIKeyManager, config NotifyConfig) (*Notifier, error) {
+	var err error
+	if config.ExpiryDays, err = normalizeThresholds(config.ExpiryDays, defaultExpiryDays, 0); err != nil {
+		return nil, fmt.Errorf("expiryDays: %w", err)
+	}
+	if config.QuotaThresholds, err = normalizeThresholds(config.QuotaThresholds, defaultQuotaThresholds, 100); err != nil {
+		return nil, fmt.Errorf("quotaThresholds: %w", err)
+	}
+	if config.ScanInterval <= 0 {
+		config.ScanInterval = defaultNotifyScanInterval
+	}
+	if config.Collection == "" {
+		config.Collection = defaultNotifyCollection
+	}
+	if len(config.Channels) == 0 {
+		config.Channels = []NotifyChannelConfig{{Name: NotifyChannelWebSocket, Type: NotifyChannelWebSocket}}
+	}
+
+	n := &Notifier{
+		m:      m,
+		config: config,
+		fired:  make(map[string]time.Time),
+	}
+
+	names := make(map[string]bool, len(config.Channels))
+	for i, channelConfig := range config.Channels {
+		if channelConfig.Name == "" {
+			channelConfig.Name = fmt.Sprintf("%s-%d", channelConfig.Type, i+1)
+		}
+		if names[channelConfig.Name] {
+			return nil, fmt.Errorf("duplicate notification channel name '%s'", channelConfig.Name)
+		}
+		names[channelConfig.Name] = true
+
+		for _, event := range channelConfig.Events {
+			if event != NotificationKeyExpiring && event != NotificationQuotaReached {
+				return nil, fmt.Errorf("notification channel '%s': unsupported event '%s'", channelConfig.Name, event)
+			}
+		}
+
+		channel, err := newNotificationChannel(m, channelConfig)
+		if err != nil {
+			return nil, fmt.Errorf("notification channel '%s': %w", channelConfig.Name, err)
+		}
+		n.channels = append(n.channels, &notifyChannel{
+			name:    channelConfig.Name,
+			kind:    strings.ToLower(channelConfig.Type),
+			events:  channelConfig.Events,
+			channel: channel,
+			queue:   make(chan Notification, notifyQueueSize),
+		})
+	}
+
+	return n, nil
+}
+
+func (n *Notifier) Start() {
+	for _, channel := range n.channels {
+		go n.deliver(channel)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(n.config.ScanInterval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			n.Scan(time.Now().UTC())
+
+			select {
+			case <-ticker.C:
+			case <-n.m.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (n *Notifier) Scan(now time.Time) {
+	for _, apiKey := range n.m.cache.ListKeys() {
+		if !apiKey.IsActive {
+			continue
+		}
+		n.checkExpiry(&apiKey, now)
+		n.checkQuota(&apiKey, now)
+	}
+	n.pruneFired(now)
+}
+
+func (n *Notifier) checkExpiry(apiKey *APIKey, now time.Time) {
+	remaining := apiKey.Expiration.Sub(now)
+	if apiKey.Expiration.IsZero() || remaining <= 0 {
+		return
+	}
+
+	var crossed []int
+	for _, days := range n.config.ExpiryDays {
+		if remaining <= time.Duration(days)*24*time.Hour {
+			crossed = append(crossed, days)
+		}
+	}
+	if len(crossed) == 0 {
+		return
+	}
+
+	scope := strconv.FormatInt(apiKey.Expiration.Unix(), 10)
+	threshold := crossed[0]
+	if !n.claim(NotificationKeyExpiring, apiKey.ID, scope, crossed, apiKey.Expiration.Add(24*time.Hour)) {
+		return
+	}
+
+	severity := "warning"
+	if threshold <= 1 {
+		severity = "error"
+	}
+	n.notify(Notification{
+		Type:      NotificationKeyExpiring,
+		Severity:  severity,
+		KeyID:     maskAPIKey(apiKey.ID),
+		KeyName:   apiKey.Name,
+		Threshold: threshold,
+		Message:   fmt.Sprintf("API key %s expires in less than %d day(s)", keyLabel(apiKey), threshold),
+		ExpiresAt: apiKey.Expiration,
+		Timestamp: now,
+	})
+}
+
+func (n *Notifier) checkQuota(apiKey *APIKey, now time.Time) {
+	usage := n.m.quotas.Usage(apiKey, now)
+	if usage == nil || usage.Limit <= 0 {
+		return
+	}
+
+	percent := usage.Used * 100 / usage.Limit
+	var crossed []int
+	for i := len(n.config.QuotaThresholds) - 1; i >= 0; i-- {
+		if percent >= int64(n.config.QuotaThresholds[i]) {
+			crossed = append(crossed, n.config.QuotaThresholds[i])
+		}
+	}
+	if len(crossed) == 0 {
+		return
+	}
+
+	scope := strconv.FormatInt(usage.PeriodStart.Unix(), 10)
+	threshold := crossed[0]
+	if !n.claim(NotificationQuotaReached, apiKey.ID, scope, crossed, usage.ResetAt) {
+		return
+	}
+
+	severity := "warning"
+	if threshold >= 100 {
+		severity = "error"
+	}
+	n.notify(Notification{
+		Type:      NotificationQuotaReached,
+		Severity:  severity,
+		KeyID:     maskAPIKey(apiKey.ID),
+		KeyName:   apiKey.Name,
+		Threshold: threshold,
+		Message:   fmt.Sprintf("API key %s has reached %d%% of its %s quota (%d/%d)", keyLabel(apiKey), threshold, usage.Period, usage.Used, usage.Limit),
+		Used:      usage.Used,
+		Limit:     usage.Limit,
+		Timestamp: now,
+	})
+}
+
+func keyLabel(apiKey *APIKey) string {
+	if apiKey.Name != "" {
+		return fmt.Sprintf("'%s' (%s)", apiKey.Name, maskAPIKey(apiKey.ID))
+	}
+	return maskAPIKey(apiKey.ID)
+}
+
+func notificationDedupID(kind, keyID, scope string, threshold int) string {
+	sum := sha256.Sum256([]byte(keyID))
+	return fmt.Sprintf("%s:%s:%s:%d", kind, hex.EncodeToString(sum[:16]), scope, threshold)
+}
+
+func (n *Notifier) claim(kind, keyID, scope string, crossed []int, expireAt time.Time) bool {
+	id := notificationDedupID(kind, keyID, scope, crossed[0])
+
+	n.mu.Lock()
+	if _, ok := n.fired[id]; ok {
+		n.mu.Unlock()
+		return false
+	}
+	for _, threshold := range crossed {
+		n.fired[notificationDedupID(kind, keyID, scope, threshold)] = expireAt
+	}
+	n.mu.Unlock()
+
+	if n.collection == nil || !n.m.isMongoConnected() {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(n.m.ctx, 5*time.Second)
+	defer cancel()
+
+	var claimed bool
+	for _, threshold := range crossed {
+		_, err := n.collection.InsertOne(ctx, notificationRecord{
+			ID:        notificationDedupID(kind, keyID, scope, threshold),
+			Type:      kind,
+			KeyID:     maskAPIKey(keyID),
+			Threshold: threshold,
+			FiredAt:   time.Now().UTC(),
+			ExpireAt:  expireAt,
+		})
+		switch {
+		case err == nil:
+			claimed = claimed || threshold == crossed[0]
+		case mongo.IsDuplicateKeyError(err):
+		default:
+			n.m.Warn("Failed to record notification", "component", "notify", "keyId", maskAPIKey(keyID), "error", err)
+			claimed = claimed || threshold == crossed[0]
+		}
+	}
+	return claimed
+}
+
+func (n *Notifier) pruneFired(now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for id, expireAt := range n.fired {
+		if now.After(expireAt) {
+			delete(n.fired, id)
+		}
+	}
+}
+
+func (n *Notifier) notify(notification Notification) {
+	notification.ID = generateRequestID()
+
+	n.mu.Lock()
+	n.history = append(n.history, notification)
+	if len(n.history) > notifyHistorySize {
+		n.history = n.history[len(n.history)-notifyHistorySize:]
+	}
+	n.mu.Unlock()
+
+	n.m.Info("Sending notification", "component", "notify", "type", notification.Type, "keyId", notification.KeyID, "threshold", notification.Threshold)
+
+	for _, channel := range n.channels {
+		if len(channel.events) > 0 && !containsString(channel.events, notification.Type) {
+			continue
+		}
+
+		select {
+		case channel.queue <- notification:
+		default:
+			atomic.AddInt64(&channel.dropped, 1)
+			n.m.Warn("Notification queue full, dropping notification", "component", "notify", "channel", channel.name, "type", notification.Type)
+		}
+	}
+}
+
+func (n *Notifier) deliver(channel *notifyChannel) {
+	for {
+		select {
+		case notification := <-channel.queue:
+			n.send(channel, notification)
+		case <-n.m.ctx.Done():
+			return
+		}
+	}
+}
+
+// send delivers one notification, retrying with backoff. The threshold is
+// already claimed, so a notification that gives up here never fires again.
+func (n *Notifier) send(channel *notifyChannel, notification Notification) {
+	backoff := notifyRetryBackoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(n.m.ctx, notifySendTimeout)
+		err := channel.channel.Send(ctx, notification)
+		cancel()
+
+		if err == nil {
+			atomic.AddInt64(&channel.sent, 1)
+			return
+		}
+
+		channel.errMu.Lock()
+		channel.lastError = err.Error()
+		channel.errMu.Unlock()
+
+		if attempt >= notifyMaxAttempts {
+			atomic.AddInt64(&channel.failed, 1)
+			n.m.Error("Notification delivery failed", "component", "notify", "channel", channel.name, "type", notification.Type, "attempts", attempt, "error", err)
+			return
+		}
+		n.m.Warn("Notification delivery failed, retrying", "component", "notify", "channel", channel.name, "type", notification.Type, "attempt", attempt, "retryIn", backoff.String(), "error", err)
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-n.m.ctx.Done():
+			atomic.AddInt64(&channel.failed, 1)
+			return
+		}
+	}
+}
+
+func (n *Notifier) Stats() []NotificationChannelStats {
+	stats := make([]NotificationChannelStats, 0, len(n.channels))
+	for _, channel := range n.channels {
+		channel.errMu.Lock()
+		lastError := channel.lastError
+		channel.errMu.Unlock()
+
+		stats = append(stats, NotificationChannelStats{
+			Name:      channel.name,
+			Type:      channel.kind,
+			Target:    channel.channel.Target(),
+			Events:    channel.events,
+			Queued:    len(channel.queue),
+			Sent:      atomic.LoadInt64(&channel.sent),
+			Failed:    atomic.LoadInt64(&channel.failed),
+			Dropped:   atomic.LoadInt64(&channel.dropped),
+			LastError: lastError,
+		})
+	}
+	return stats
+}
+
+func (n *Notifier) Recent() []Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	recent := make([]Notification, len(n.history))
+	for i := range n.history {
+		recent[i] = n.history[len(n.history)-1-i]
+	}
+	return recent
+}
+
+func (m *APIKeyManager) listNotificationsHandler(c *gin.Context) {
+	m.respondWithSuccess(c, gin.H{
+		"notifications":   m.notifier.Recent(),
+		"channels":        m.notifier.Stats(),
+		"expiryDays":      m.notifier.config.ExpiryDays,
+		"quotaThresholds": m.notifier.config.QuotaThresholds,
+	}, "")
+}
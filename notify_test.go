@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestNotificationDedupIDOmitsRawKey(t *testing.T) {
+	const rawKey = "sk_live_0123456789abcdef"
+	id := notificationDedupID("quota", rawKey, "daily:2026-01-01", 80)
+	if strings.Contains(id, rawKey) {
+		t.Fatalf("notificationDedupID() = %q contains the raw key", id)
+	}
+	if id != notificationDedupID("quota", rawKey, "daily:2026-01-01", 80) {
+		t.Fatal("notificationDedupID() is not deterministic")
+	}
+	if id == notificationDedupID("quota", rawKey+"x", "daily:2026-01-01", 80) {
+		t.Fatal("notificationDedupID() collides for different keys")
+	}
+	if id == notificationDedupID("quota", rawKey, "daily:2026-01-01", 90) {
+		t.Fatal("notificationDedupID() ignores the threshold")
+	}
+}
+
+type fakeNotificationChannel struct {
+	sent     chan Notification
+	failures int
+}
+
+func (f *fakeNotificationChannel) Send(ctx context.Context, n Notification) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("temporary failure")
+	}
+	f.sent <- n
+	return nil
+}
+
+func (f *fakeNotificationChannel) Target() string {
+	return "fake"
+}
+
+type fakeNotificationStore struct {
+	mu      sync.Mutex
+	records map[string]notificationRecord
+}
+
+func (f *fakeNotificationStore) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	record := document.(notificationRecord)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.records[record.ID]; ok {
+		return nil, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}}}
+	}
+	f.records[record.ID] = record
+	return &mongo.InsertOneResult{InsertedID: record.ID}, nil
+}
+
+func newScanTestNotifier(t *testing.T, store *fakeNotificationStore, apiKey *APIKey) (*Notifier, *fakeNotificationChannel) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	m := &APIKeyManager{
+		ctx:    ctx,
+		cache:  &Cache{},
+		quotas: NewQuotaTracker(),
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	m.setMongoStatus(true)
+	m.cache.SetAPIKey(apiKey)
+
+	fake := &fakeNotificationChannel{sent: make(chan Notification, 10)}
+	n := &Notifier{
+		m:          m,
+		config:     NotifyConfig{ExpiryDays: []int{1, 7}},
+		collection: store,
+		fired:      make(map[string]time.Time),
+		channels: []*notifyChannel{{
+			name:    "fake",
+			kind:    "fake",
+			channel: fake,
+			queue:   make(chan Notification, notifyQueueSize),
+		}},
+	}
+	go n.deliver(n.channels[0])
+	return n, fake
+}
+
+func expectNotifications(t *testing.T, fake *fakeNotificationChannel, thresholds ...int) {
+	t.Helper()
+
+	for _, want := range thresholds {
+		select {
+		case got := <-fake.sent:
+			if got.Threshold != want {
+				t.Fatalf("notification threshold = %d, want %d", got.Threshold, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for notification at threshold %d", want)
+		}
+	}
+
+	select {
+	case extra := <-fake.sent:
+		t.Fatalf("unexpected notification %+v", extra)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNotifierScanClaimsEachThresholdOnce(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	apiKey := &APIKey{ID: "sk_live_expiring", Name: "ci", IsActive: true, Expiration: now.Add(12 * time.Hour)}
+	store := &fakeNotificationStore{records: make(map[string]notificationRecord)}
+
+	n, fake := newScanTestNotifier(t, store, apiKey)
+
+	n.Scan(now)
+	expectNotifications(t, fake, 1)
+	if len(store.records) != 2 {
+		t.Fatalf("stored %d claims, want both crossed thresholds", len(store.records))
+	}
+
+	n.Scan(now.Add(time.Hour))
+	n.Scan(now.Add(2 * time.Hour))
+	expectNotifications(t, fake)
+
+	restarted, restartedFake := newScanTestNotifier(t, store, apiKey)
+	restarted.Scan(now.Add(3 * time.Hour))
+	expectNotifications(t, restartedFake)
+}
+
+func TestNotifierScanFiresLaterThresholdAfterRestart(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	apiKey := &APIKey{ID: "sk_live_expiring", IsActive: true, Expiration: now.Add(3 * 24 * time.Hour)}
+	store := &fakeNotificationStore{records: make(map[string]notificationRecord)}
+
+	n, fake := newScanTestNotifier(t, store, apiKey)
+	n.Scan(now)
+	expectNotifications(t, fake, 7)
+
+	restarted, restartedFake := newScanTestNotifier(t, store, apiKey)
+	restarted.Scan(now.Add(time.Hour))
+	expectNotifications(t, restartedFake)
+
+	restarted.Scan(now.Add(60 * time.Hour))
+	expectNotifications(t, restartedFake, 1)
+}
+
+func TestSMTPSendStopsAtDeadline(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	channel := &smtpNotifyChannel{address: listener.Addr().String(), from: "alerts@example.com", to: []string{"ops@example.com"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := channel.Send(ctx, Notification{Severity: "warning", Message: "test", Timestamp: start}); err == nil {
+		t.Fatal("Send() error = nil, want a timeout from a silent server")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Send() returned after %v, want it bounded by the context deadline", elapsed)
+	}
+}
+
+func TestSMTPMessageKeepsKeyNameOutOfHeaders(t *testing.T) {
+	channel := &smtpNotifyChannel{from: "alerts@example.com", to: []string{"ops@example.com"}}
+	name := "ci\r\nBcc: attacker@example.com"
+	msg := string(channel.message(Notification{
+		Severity:  "warning",
+		KeyName:   name,
+		Message:   "API key '" + name + "' expires in 1 day",
+		Timestamp: time.Now(),
+	}))
+
+	headers, _, ok := strings.Cut(msg, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("message has no header/body separator:\n%s", msg)
+	}
+	for _, line := range strings.Split(headers, "\r\n") {
+		if strings.HasPrefix(line, "Bcc:") {
+			t.Fatalf("key name injected a header: %q", line)
+		}
+	}
+	if strings.Count(msg, "\r\nBcc:") != 0 {
+		t.Fatalf("key name started a new line:\n%s", msg)
+	}
+}
+
+func TestNotifierRetriesFailedSend(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	apiKey := &APIKey{ID: "sk_live_expiring", IsActive: true, Expiration: now.Add(12 * time.Hour)}
+	store := &fakeNotificationStore{records: make(map[string]notificationRecord)}
+
+	n, fake := newScanTestNotifier(t, store, apiKey)
+	fake.failures = 1
+
+	n.Scan(now)
+	expectNotifications(t, fake, 1)
+
+	stats := n.Stats()[0]
+	if stats.Sent != 1 || stats.Failed != 0 || stats.LastError == "" {
+		t.Fatalf("channel stats = %+v, want one send after a retried failure", stats)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	CloseAdminDisconnect = 4004
+
+	PresenceJoin  = "join"
+	PresenceLeave = "leave"
+)
+
+type PresenceUser struct {
+	UserID   string    `json:"userId"`
+	Sessions int       `json:"sessions"`
+	Clients  int       `json:"clients"`
+	Since    time.Time `json:"since"`
+}
+
+// IssuedSession records a session token handed out at login, so sessions
+// are listed whether or not they hold a socket open.
+type IssuedSession struct {
+	TokenID   string    `bson:"_id" json:"tokenId"`
+	UserID    string    `bson:"userId" json:"userId"`
+	IP        string    `bson:"ip" json:"ip,omitempty"`
+	UserAgent string    `bson:"userAgent" json:"userAgent,omitempty"`
+	IssuedAt  time.Time `bson:"issuedAt" json:"issuedAt"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
+type SessionInfo struct {
+	TokenID     string          `json:"tokenId"`
+	UserID      string          `json:"userId"`
+	IP          string          `json:"ip,omitempty"`
+	UserAgent   string          `json:"userAgent,omitempty"`
+	IssuedAt    time.Time       `json:"issuedAt"`
+	ExpiresAt   time.Time       `json:"expiresAt"`
+	ConnectedAt time.Time       `json:"connectedAt"`
+	Clients     []WSClientStats `json:"clients"`
+}
+
+// IssuedSessions keeps the sessions issued by this instance, for listing
+// while the store is unavailable.
+type IssuedSessions struct {
+	mu       sync.RWMutex
+	sessions map[string]IssuedSession
+}
+
+func NewIssuedSessions() *IssuedSessions {
+	return &IssuedSessions{sessions: make(map[string]IssuedSession)}
+}
+
+func (s *IssuedSessions) Add(session IssuedSession) {
+	s.mu.Lock()
+	s.sessions[session.TokenID] = session
+	s.mu.Unlock()
+}
+
+func (s *IssuedSessions) List(now time.Time) []IssuedSession {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sessions := make([]IssuedSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		if now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+func (s *IssuedSessions) Prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for tokenID, session := range s.sessions {
+		if !now.Before(session.ExpiresAt) {
+			delete(s.sessions, tokenID)
+		}
+	}
+}
+
+// recordSession registers the session token issued by a login. A failed
+// store write is logged but does not fail the login.
+func (m *APIKeyManager) recordSession(c *gin.Context, tokenID, userID string, expiresAt time.Time) {
+	session := IssuedSession{
+		TokenID:   tokenID,
+		UserID:    userID,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		IssuedAt:  time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+	m.sessions.Add(session)
+
+	if !m.isMongoConnected() {
+		return
+	}
+	err := m.withRetry("recordSession", func() error {
+		ctx, cancel := context.WithTimeout(m.ctx, 5*time.Second)
+		defer cancel()
+
+		_, err := m.sessionsCollection.ReplaceOne(ctx, bson.M{"_id": tokenID}, session, options.Replace().SetUpsert(true))
+		return err
+	})
+	if err != nil {
+		m.WarnContext(c, "Failed to store session", "component", "auth", "error", err)
+	}
+}
+
+// issuedSessions returns the unexpired, unrevoked session tokens, from the
+// store when it is reachable and from this instance's registry otherwise.
+func (m *APIKeyManager) issuedSessions() []IssuedSession {
+	now := time.Now().UTC()
+	sessions := m.sessions.List(now)
+	if m.isMongoConnected() {
+		stored, err := m.loadIssuedSessions(now)
+		if err == nil {
+			sessions = stored
+		} else {
+			m.Warn("Failed to load sessions", "component", "auth", "error", err)
+		}
+	}
+
+	active := make([]IssuedSession, 0, len(sessions))
+	for _, session := range sessions {
+		if !m.revocations.IsRevoked(session.TokenID) {
+			active = append(active, session)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].IssuedAt.Before(active[j].IssuedAt)
+	})
+	return active
+}
+
+func (m *APIKeyManager) loadIssuedSessions(now time.Time) ([]IssuedSession, error) {
+	ctx, cancel := context.WithTimeout(m.ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := m.sessionsCollection.Find(ctx, bson.M{"expiresAt": bson.M{"$gt": now}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := make([]IssuedSession, 0)
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (m *APIKeyManager) activeClients() []WSClientStats {
+	clients := make([]WSClientStats, 0)
+	m.wsClients.Range(func(_, value interface{}) bool {
+		if wsClient, ok := value.(*WSClient); ok {
+			clients = append(clients, wsClient.Stats())
+		}
+		return true
+	})
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ConnectedAt.Before(clients[j].ConnectedAt)
+	})
+	return clients
+}
+
+// activeSessions lists the issued sessions with the sockets each holds open.
+// Sockets whose token is not in the list, such as one issued before the
+// registry existed, are listed as sessions of their own.
+func (m *APIKeyManager) activeSessions() []SessionInfo {
+	sessions := make([]SessionInfo, 0)
+	index := make(map[string]int)
+	for _, issued := range m.issuedSessions() {
+		index[issued.TokenID] = len(sessions)
+		sessions = append(sessions, SessionInfo{
+			TokenID:   issued.TokenID,
+			UserID:    issued.UserID,
+			IP:        issued.IP,
+			UserAgent: issued.UserAgent,
+			IssuedAt:  issued.IssuedAt,
+			ExpiresAt: issued.ExpiresAt,
+			Clients:   make([]WSClientStats, 0),
+		})
+	}
+
+	for _, client := range m.activeClients() {
+		i, ok := index[client.TokenID]
+		if !ok {
+			i = len(sessions)
+			index[client.TokenID] = i
+			sessions = append(sessions, SessionInfo{
+				TokenID:   client.TokenID,
+				UserID:    client.UserID,
+				ExpiresAt: client.ExpiresAt,
+			})
+		}
+		if len(sessions[i].Clients) == 0 {
+			sessions[i].ConnectedAt = client.ConnectedAt
+		}
+		sessions[i].Clients = append(sessions[i].Clients, client)
+	}
+	return sessions
+}
+
+func (m *APIKeyManager) presenceUsers() []PresenceUser {
+	users := make([]PresenceUser, 0)
+	index := make(map[string]int)
+	tokens := make(map[string]map[string]bool)
+	for _, client := range m.activeClients() {
+		i, ok := index[client.UserID]
+		if !ok {
+			i = len(users)
+			index[client.UserID] = i
+			tokens[client.UserID] = make(map[string]bool)
+			users = append(users, PresenceUser{UserID: client.UserID, Since: client.ConnectedAt})
+		}
+		if !tokens[client.UserID][client.TokenID] {
+			tokens[client.UserID][client.TokenID] = true
+			users[i].Sessions++
+		}
+		users[i].Clients++
+	}
+	return users
+}
+
+func (m *APIKeyManager) registerClient(wsClient *WSClient) {
+	m.wsClients.Store(wsClient.clientID, wsClient)
+	m.broadcastPresence(PresenceJoin, wsClient)
+}
+
+func (m *APIKeyManager) unregisterClient(wsClient *WSClient) {
+	if _, loaded := m.wsClients.LoadAndDelete(wsClient.clientID); loaded {
+		m.broadcastPresence(PresenceLeave, wsClient)
+	}
+}
+
+func (m *APIKeyManager) broadcastPresence(action string, wsClient *WSClient) {
+	m.broadcastEvent(WSMessage{
+		Type: "presence",
+		Data: map[string]interface{}{
+			"action":    action,
+			"clientId":  wsClient.clientID,
+			"userId":    wsClient.userID,
+			"transport": wsClient.transport,
+			"users":     m.presenceUsers(),
+		},
+		Timestamp: time.Now().UTC(),
+		ID:        generateRequestID(),
+		Topic:     TopicPresence,
+	})
+}
+
+func (m *APIKeyManager) listSessionsHandler(c *gin.Context) {
+	m.respondWithSuccess(c, m.activeSessions(), "")
+}
+
+func (m *APIKeyManager) disconnectSessionHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	var targets []*WSClient
+	m.wsClients.Range(func(key, value interface{}) bool {
+		wsClient, ok := value.(*WSClient)
+		if ok && (key == id || wsClient.tokenID == id) {
+			targets = append(targets, wsClient)
+		}
+		return true
+	})
+
+	tokens := make(map[string]IssuedSession)
+	for _, issued := range m.issuedSessions() {
+		if issued.TokenID == id {
+			tokens[issued.TokenID] = issued
+		}
+	}
+	if len(targets) == 0 && len(tokens) == 0 {
+		m.respondWithError(c, http.StatusNotFound, "Session not found", "SESSION_NOT_FOUND", nil)
+		return
+	}
+
+	revoke := c.Query("revoke") == "true"
+	if revoke {
+		for _, wsClient := range targets {
+			if _, ok := tokens[wsClient.tokenID]; !ok && wsClient.tokenID != "" {
+				tokens[wsClient.tokenID] = IssuedSession{TokenID: wsClient.tokenID, UserID: wsClient.userID, ExpiresAt: wsClient.expiresAt}
+			}
+		}
+		for _, session := range tokens {
+			if err := m.revokeTokenID(session.TokenID, session.UserID, session.ExpiresAt); err != nil {
+				m.ErrorContext(c, "Failed to revoke session token", "tokenId", session.TokenID, "error", err)
+				m.respondWithError(c, http.StatusInternalServerError, "Failed to revoke session", "REVOKE_FAILED", err)
+				return
+			}
+		}
+	}
+
+	for _, wsClient := range targets {
+		m.unregisterClient(wsClient)
+		wsClient.CloseWith(CloseAdminDisconnect, "disconnected by administrator")
+		m.recordAudit(c, AuditActionSessionKill, "session", wsClient.clientID, nil)
+	}
+	if len(targets) == 0 && revoke {
+		m.recordAudit(c, AuditActionSessionKill, "session", id, nil)
+	}
+
+	m.InfoContext(c, "Sessions disconnected by administrator", "id", id, "count", len(targets), "revoked", revoke)
+	m.respondWithSuccess(c, map[string]interface{}{
+		"disconnected": len(targets),
+		"revoked":      revoke,
+	}, "Session disconnected")
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func newPresenceTestManager() *APIKeyManager {
+	m := &APIKeyManager{
+		ctx:          context.Background(),
+		config:       &Config{},
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		tracing:      &Tracing{tracer: noop.NewTracerProvider().Tracer(tracerName)},
+		metrics:      NewMetrics(),
+		eventChan:    make(chan WSMessage, 16),
+		eventHistory: NewEventHistory(eventHistorySize),
+		revocations:  NewTokenRevocations(),
+		sessions:     NewIssuedSessions(),
+	}
+	m.audit.queue = make(chan AuditEntry, 16)
+	return m
+}
+
+func newPresenceTestClient(clientID, userID, tokenID string) *WSClient {
+	return &WSClient{
+		clientID:    clientID,
+		transport:   TransportWebSocket,
+		userID:      userID,
+		tokenID:     tokenID,
+		expiresAt:   time.Now().UTC().Add(time.Hour),
+		connectedAt: time.Now().UTC(),
+		done:        make(chan struct{}),
+	}
+}
+
+func nextPresenceEvent(t *testing.T, m *APIKeyManager) map[string]interface{} {
+	t.Helper()
+	select {
+	case event := <-m.eventChan:
+		if event.Type != "presence" || event.Topic != TopicPresence {
+			t.Fatalf("event = %s on %s, want presence on %s", event.Type, event.Topic, TopicPresence)
+		}
+		return event.Data.(map[string]interface{})
+	default:
+		t.Fatal("no presence event broadcast")
+		return nil
+	}
+}
+
+func TestPresenceJoinAndLeave(t *testing.T) {
+	m := newPresenceTestManager()
+	first := newPresenceTestClient("c1", "admin", "tok-1")
+	second := newPresenceTestClient("c2", "admin", "tok-1")
+	other := newPresenceTestClient("c3", "ops", "tok-2")
+
+	m.registerClient(first)
+	data := nextPresenceEvent(t, m)
+	if data["action"] != PresenceJoin || data["clientId"] != "c1" || data["userId"] != "admin" {
+		t.Fatalf("join event = %v, want c1 joining as admin", data)
+	}
+
+	m.registerClient(second)
+	m.registerClient(other)
+	nextPresenceEvent(t, m)
+	data = nextPresenceEvent(t, m)
+	users := data["users"].([]PresenceUser)
+	if len(users) != 2 || users[0].UserID != "admin" || users[0].Sessions != 1 || users[0].Clients != 2 || users[1].UserID != "ops" {
+		t.Fatalf("users = %+v, want admin with one session over two clients, then ops", users)
+	}
+
+	m.unregisterClient(first)
+	data = nextPresenceEvent(t, m)
+	if data["action"] != PresenceLeave || data["clientId"] != "c1" {
+		t.Fatalf("leave event = %v, want c1 leaving", data)
+	}
+	if users := data["users"].([]PresenceUser); len(users) != 2 || users[0].Clients != 1 {
+		t.Fatalf("users after leave = %+v, want admin down to one client", users)
+	}
+
+	m.unregisterClient(first)
+	select {
+	case event := <-m.eventChan:
+		t.Fatalf("second unregister broadcast %+v, want nothing", event)
+	default:
+	}
+}
+
+func TestActiveSessionsListsIssuedTokens(t *testing.T) {
+	m := newPresenceTestManager()
+	now := time.Now().UTC()
+	m.sessions.Add(IssuedSession{TokenID: "tok-idle", UserID: "admin", IssuedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour)})
+	m.sessions.Add(IssuedSession{TokenID: "tok-live", UserID: "admin", IssuedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)})
+	m.sessions.Add(IssuedSession{TokenID: "tok-revoked", UserID: "admin", IssuedAt: now, ExpiresAt: now.Add(time.Hour)})
+	m.sessions.Add(IssuedSession{TokenID: "tok-expired", UserID: "admin", IssuedAt: now.Add(-25 * time.Hour), ExpiresAt: now.Add(-time.Hour)})
+	m.revocations.Add("tok-revoked", now.Add(time.Hour))
+	m.wsClients.Store("c1", newPresenceTestClient("c1", "admin", "tok-live"))
+	m.wsClients.Store("c2", newPresenceTestClient("c2", "ops", "tok-unknown"))
+
+	sessions := m.activeSessions()
+
+	var got []string
+	for _, session := range sessions {
+		got = append(got, session.TokenID)
+	}
+	if len(sessions) != 3 || got[0] != "tok-idle" || got[1] != "tok-live" || got[2] != "tok-unknown" {
+		t.Fatalf("activeSessions() tokens = %v, want [tok-idle tok-live tok-unknown]", got)
+	}
+	if len(sessions[0].Clients) != 0 || len(sessions[1].Clients) != 1 || sessions[1].Clients[0].ClientID != "c1" {
+		t.Fatalf("activeSessions() = %+v, want the idle session socketless and c1 under tok-live", sessions)
+	}
+}
+
+func TestRecordSessionRegistersLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := newPresenceTestManager()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/api/auth/login", nil)
+	c.Request.Header.Set("User-Agent", "dashboard")
+
+	expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	m.recordSession(c, "tok-1", "admin", expiresAt)
+
+	sessions := m.issuedSessions()
+	if len(sessions) != 1 || sessions[0].TokenID != "tok-1" || sessions[0].UserAgent != "dashboard" || !sessions[0].ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("issuedSessions() = %+v, want the login's token", sessions)
+	}
+}
+
+func TestDisconnectSessionHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		id          string
+		revoke      bool
+		wantStatus  int
+		wantClosed  []string
+		wantRevoked []string
+	}{
+		{name: "client", id: "c1", wantStatus: http.StatusOK, wantClosed: []string{"c1"}},
+		{name: "token with revoke", id: "tok-1", revoke: true, wantStatus: http.StatusOK, wantClosed: []string{"c1", "c2"}, wantRevoked: []string{"tok-1"}},
+		{name: "socketless session with revoke", id: "tok-idle", revoke: true, wantStatus: http.StatusOK, wantRevoked: []string{"tok-idle"}},
+		{name: "unknown", id: "missing", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newPresenceTestManager()
+			now := time.Now().UTC()
+			m.sessions.Add(IssuedSession{TokenID: "tok-1", UserID: "admin", IssuedAt: now, ExpiresAt: now.Add(time.Hour)})
+			m.sessions.Add(IssuedSession{TokenID: "tok-idle", UserID: "admin", IssuedAt: now, ExpiresAt: now.Add(time.Hour)})
+			clients := map[string]*WSClient{
+				"c1": newPresenceTestClient("c1", "admin", "tok-1"),
+				"c2": newPresenceTestClient("c2", "admin", "tok-1"),
+				"c3": newPresenceTestClient("c3", "ops", "tok-2"),
+			}
+			for id, client := range clients {
+				m.wsClients.Store(id, client)
+			}
+
+			target := "/api/sessions/" + tt.id
+			if tt.revoke {
+				target += "?revoke=true"
+			}
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("DELETE", target, nil)
+			c.Params = gin.Params{{Key: "id", Value: tt.id}}
+			c.Set("userID", "admin")
+
+			m.disconnectSessionHandler(c)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			closed := make(map[string]bool)
+			for _, id := range tt.wantClosed {
+				closed[id] = true
+			}
+			for id, client := range clients {
+				select {
+				case <-client.done:
+					if !closed[id] {
+						t.Fatalf("client %s closed, want open", id)
+					}
+					if _, ok := m.wsClients.Load(id); ok {
+						t.Fatalf("client %s closed but still registered", id)
+					}
+				default:
+					if closed[id] {
+						t.Fatalf("client %s open, want closed", id)
+					}
+				}
+			}
+			for _, tokenID := range tt.wantRevoked {
+				if !m.revocations.IsRevoked(tokenID) {
+					t.Fatalf("token %s not revoked", tokenID)
+				}
+			}
+			if !tt.revoke && m.revocations.IsRevoked("tok-1") {
+				t.Fatal("token revoked without revoke=true")
+			}
+
+			wantAudits := len(tt.wantClosed)
+			if len(tt.wantClosed) == 0 && len(tt.wantRevoked) > 0 {
+				wantAudits = 1
+			}
+			if got := len(m.audit.queue); got != wantAudits {
+				t.Fatalf("audit entries = %d, want %d", got, wantAudits)
+			}
+			for i := 0; i < wantAudits; i++ {
+				if entry := <-m.audit.queue; entry.Action != AuditActionSessionKill || entry.Actor != "admin" {
+					t.Fatalf("audit entry = %+v, want %s by admin", entry, AuditActionSessionKill)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
 	"crypto/rand"
 	"embed"
@@ -10,6 +11,7 @@ import (
 	"io"
 	"io/fs"
 	"log"
+	"log/slog"
 	"mime"
 	"net/http"
 	"os"
@@ -18,12 +20,14 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -36,27 +40,70 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //go:embed frontend/dist
 var staticFiles embed.FS
 
 type Config struct {
-	ServerPort        string `json:"serverPort"`
-	MongoURI          string `json:"mongoURI"`
-	DatabaseName      string `json:"databaseName"`
-	ApiKeysCollection string `json:"apiKeysCollection"`
-	LogsCollection    string `json:"logsCollection"`
-	ReadTimeout       int    `json:"readTimeout"`
-	WriteTimeout      int    `json:"writeTimeout"`
-	IdleTimeout       int    `json:"idleTimeout"`
-	JWTSecret         string `json:"jwtSecret"`
-	AdminPassword     string `json:"adminPassword"`
-	MaxRetries        int    `json:"maxRetries"`
-	RetryDelay        int    `json:"retryDelay"`
-	LogDir            string `json:"logDir"`
-	MaxLogSize        int64  `json:"maxLogSize"`
-	MaxLogFiles       int    `json:"maxLogFiles"`
+	ServerPort             string            `json:"serverPort"`
+	MongoURI               string            `json:"mongoURI"`
+	DatabaseName           string            `json:"databaseName"`
+	ApiKeysCollection      string            `json:"apiKeysCollection"`
+	LogsCollection         string            `json:"logsCollection"`
+	ReadTimeout            int               `json:"readTimeout"`
+	WriteTimeout           int               `json:"writeTimeout"`
+	IdleTimeout            int               `json:"idleTimeout"`
+	JWTSecret              string            `json:"jwtSecret"`
+	AdminPassword          string            `json:"adminPassword"`
+	MaxRetries             int               `json:"maxRetries"`
+	RetryDelay             int               `json:"retryDelay"`
+	LogDir                 string            `json:"logDir"`
+	MaxLogSize             int64             `json:"maxLogSize"`
+	MaxLogFiles            int               `json:"maxLogFiles"`
+	MaxLogAge              int               `json:"maxLogAge"`
+	CompressLogs           bool              `json:"compressLogs"`
+	LogFormat              string            `json:"logFormat"`
+	LogLevel               string            `json:"logLevel"`
+	ComponentLogLevels     map[string]string `json:"componentLogLevels"`
+	LeaseTimeout           int               `json:"leaseTimeout"`
+	Plans                  []Plan            `json:"plans"`
+	QuotaTimezone          string            `json:"quotaTimezone"`
+	UsagePeriodsCollection string            `json:"usagePeriodsCollection"`
+	UsageBucketsCollection string            `json:"usageBucketsCollection"`
+	UsageReportsCollection string            `json:"usageReportsCollection"`
+	KeyRefSecret           string            `json:"keyRefSecret"`
+	AuditCollection        string            `json:"auditCollection"`
+	AuditHeadCollection    string            `json:"auditHeadCollection"`
+	AuditSecret            string            `json:"auditSecret"`
+	LogRetention           map[string]int    `json:"logRetention"`
+	LogArchive             bool              `json:"logArchive"`
+	LogArchiveDir          string            `json:"logArchiveDir"`
+	LogSinks               []LogSinkConfig   `json:"logSinks"`
+	LogQueueSize           int               `json:"logQueueSize"`
+	LogJournalDir          string            `json:"logJournalDir"`
+	LogJournalMaxSize      int64             `json:"logJournalMaxSize"`
+	WSSendQueueSize        int               `json:"wsSendQueueSize"`
+	WSSlowConsumerPolicy   string            `json:"wsSlowConsumerPolicy"`
+	WSWriteTimeout         int               `json:"wsWriteTimeout"`
+	SSEHeartbeatInterval   int               `json:"sseHeartbeatInterval"`
+	WSAllowedOrigins       []string          `json:"wsAllowedOrigins"`
+	WSAuthTimeout          int               `json:"wsAuthTimeout"`
+	RevocationsCollection  string            `json:"revocationsCollection"`
+	SessionsCollection     string            `json:"sessionsCollection"`
+	WebhooksCollection     string            `json:"webhooksCollection"`
+	DeliveriesCollection   string            `json:"deliveriesCollection"`
+	WebhookMaxAttempts     int               `json:"webhookMaxAttempts"`
+	WebhookTimeout         int               `json:"webhookTimeout"`
+	WebhookAllowedHosts    []string          `json:"webhookAllowedHosts"`
+	Notifications          NotifyConfig      `json:"notifications"`
+	Tracing                TracingConfig     `json:"tracing"`
+	ShutdownDrainDelay     int               `json:"shutdownDrainDelay"`
 }
 
 type APIKey struct {
@@ -71,22 +118,34 @@ type APIKey struct {
 	UpdatedAt     time.Time              `bson:"updatedAt" json:"updatedAt"`
 	IsActive      bool                   `bson:"isActive" json:"isActive"`
 	LastUsed      *time.Time             `bson:"lastUsed,omitempty" json:"lastUsed,omitempty"`
+	Scopes        []string               `bson:"scopes,omitempty" json:"scopes,omitempty"`
+	Plan          string                 `bson:"plan,omitempty" json:"plan,omitempty"`
+	OrgID         string                 `bson:"orgId,omitempty" json:"orgId,omitempty"`
+	Limits        []LimitRule            `bson:"limits,omitempty" json:"limits,omitempty"`
+	Quota         *QuotaConfig           `bson:"quota,omitempty" json:"quota,omitempty"`
 	Metadata      map[string]interface{} `bson:"metadata,omitempty" json:"metadata,omitempty"`
 }
 
 type APIKeyResponse struct {
-	ID            string     `json:"id"`
-	MaskedKey     string     `json:"maskedKey"`
-	Name          string     `json:"name,omitempty"`
-	Expiration    time.Time  `json:"expiration"`
-	RPM           int        `json:"rpm"`
-	ThreadsLimit  int        `json:"threadsLimit"`
-	TotalRequests int64      `json:"totalRequests"`
-	UsageCount    int64      `json:"usageCount"`
-	CreatedAt     time.Time  `json:"createdAt"`
-	UpdatedAt     time.Time  `json:"updatedAt"`
-	IsActive      bool       `json:"isActive"`
-	LastUsed      *time.Time `json:"lastUsed,omitempty"`
+	ID            string          `json:"id"`
+	MaskedKey     string          `json:"maskedKey"`
+	KeyRef        string          `json:"keyRef"`
+	Name          string          `json:"name,omitempty"`
+	Expiration    time.Time       `json:"expiration"`
+	RPM           int             `json:"rpm"`
+	ThreadsLimit  int             `json:"threadsLimit"`
+	TotalRequests int64           `json:"totalRequests"`
+	UsageCount    int64           `json:"usageCount"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	UpdatedAt     time.Time       `json:"updatedAt"`
+	IsActive      bool            `json:"isActive"`
+	LastUsed      *time.Time      `json:"lastUsed,omitempty"`
+	Scopes        []string        `json:"scopes"`
+	Plan          string          `json:"plan,omitempty"`
+	OrgID         string          `json:"orgId,omitempty"`
+	Limits        []LimitRule     `json:"limits"`
+	Quota         *QuotaConfig    `json:"quota,omitempty"`
+	QuotaUsage    *QuotaUsageInfo `json:"quotaUsage,omitempty"`
 }
 
 type LogEntry struct {
@@ -100,21 +159,31 @@ type LogEntry struct {
 }
 
 type CreateKeyRequest struct {
-	CustomKey     string `json:"customKey"`
-	Name          string `json:"name"`
-	RPM           int    `json:"rpm"`
-	ThreadsLimit  int    `json:"threadsLimit"`
-	TotalRequests int64  `json:"totalRequests"`
-	Expiration    string `json:"expiration"`
+	CustomKey     string       `json:"customKey"`
+	Name          string       `json:"name"`
+	RPM           int          `json:"rpm"`
+	ThreadsLimit  int          `json:"threadsLimit"`
+	TotalRequests int64        `json:"totalRequests"`
+	Expiration    string       `json:"expiration"`
+	Scopes        []string     `json:"scopes"`
+	Plan          string       `json:"plan"`
+	OrgID         string       `json:"orgId"`
+	Limits        []LimitRule  `json:"limits"`
+	Quota         *QuotaConfig `json:"quota"`
 }
 
 type UpdateKeyRequest struct {
-	Name          *string `json:"name,omitempty"`
-	RPM           *int    `json:"rpm,omitempty"`
-	ThreadsLimit  *int    `json:"threadsLimit,omitempty"`
-	TotalRequests *int64  `json:"totalRequests,omitempty"`
-	Expiration    *string `json:"expiration,omitempty"`
-	IsActive      *bool   `json:"isActive,omitempty"`
+	Name          *string      `json:"name,omitempty"`
+	RPM           *int         `json:"rpm,omitempty"`
+	ThreadsLimit  *int         `json:"threadsLimit,omitempty"`
+	TotalRequests *int64       `json:"totalRequests,omitempty"`
+	Expiration    *string      `json:"expiration,omitempty"`
+	IsActive      *bool        `json:"isActive,omitempty"`
+	Scopes        *[]string    `json:"scopes,omitempty"`
+	Plan          *string      `json:"plan,omitempty"`
+	OrgID         *string      `json:"orgId,omitempty"`
+	Limits        *[]LimitRule `json:"limits,omitempty"`
+	Quota         *QuotaConfig `json:"quota,omitempty"`
 }
 
 type LoginRequest struct {
@@ -131,13 +200,29 @@ type WSMessage struct {
 	Data      interface{} `json:"data"`
 	Timestamp time.Time   `json:"timestamp"`
 	ID        string      `json:"id,omitempty"`
+	Seq       uint64      `json:"seq,omitempty"`
+	Topic     string      `json:"topic,omitempty"`
+	span      trace.SpanContext
+	replay    *wsReplay
+}
+
+type WSClientMessage struct {
+	Type       string         `json:"type"`
+	Topics     []string       `json:"topics,omitempty"`
+	Filter     *LogTailFilter `json:"filter,omitempty"`
+	Backlog    int            `json:"backlog,omitempty"`
+	ResumeFrom *uint64        `json:"resumeFrom,omitempty"`
+	Epoch      string         `json:"epoch,omitempty"`
+	Token      string         `json:"token,omitempty"`
 }
 
 type PaginationInfo struct {
-	Page       int   `json:"page"`
-	Limit      int   `json:"limit"`
-	Total      int64 `json:"total"`
-	TotalPages int   `json:"totalPages"`
+	Page       int    `json:"page"`
+	Limit      int    `json:"limit"`
+	Total      int64  `json:"total"`
+	TotalPages int    `json:"totalPages"`
+	HasMore    bool   `json:"hasMore,omitempty"`
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 type ApiResponse struct {
@@ -188,10 +273,21 @@ func (c *Cache) GetAPIKey(key string) (*APIKey, bool) {
 }
 
 func (c *Cache) SetAPIKey(apiKey *APIKey) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if value, exists := c.keyToAPIKey.Load(apiKey.ID); exists {
+		if current, ok := value.(*APIKey); ok && current != apiKey {
+			mergeUsage(apiKey, current)
+		}
+	}
 	c.keyToAPIKey.Store(apiKey.ID, apiKey)
 }
 
 func (c *Cache) DeleteAPIKey(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	c.keyToAPIKey.Delete(key)
 }
 
@@ -226,6 +322,9 @@ func (c *Cache) Size() int {
 }
 
 func (c *Cache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	c.keyToAPIKey.Range(func(key, value interface{}) bool {
 		c.keyToAPIKey.Delete(key)
 		return true
@@ -237,13 +336,21 @@ func (c *Cache) Clear() {
 type FileLogger struct {
 	logFile     *os.File
 	currentSize int64
+	currentDay  string
 	maxSize     int64
 	maxFiles    int
+	maxAge      time.Duration
+	compress    bool
 	logDir      string
+	now         func() time.Time
 	mu          sync.Mutex
+	archiveMu   sync.Mutex
+	pending     sync.WaitGroup
+	done        chan struct{}
+	closeOnce   sync.Once
 }
 
-func NewFileLogger(logDir string, maxSize int64, maxFiles int) (*FileLogger, error) {
+func NewFileLogger(logDir string, maxSize int64, maxFiles int, maxAgeDays int, compress bool) (*FileLogger, error) {
 	if logDir == "" {
 		logDir = "logs"
 	}
@@ -261,19 +368,25 @@ func NewFileLogger(logDir string, maxSize int64, maxFiles int) (*FileLogger, err
 	fl := &FileLogger{
 		maxSize:  maxSize,
 		maxFiles: maxFiles,
+		maxAge:   time.Duration(maxAgeDays) * 24 * time.Hour,
+		compress: compress,
 		logDir:   logDir,
+		now:      time.Now,
+		done:     make(chan struct{}),
 	}
 
 	if err := fl.openLogFile(); err != nil {
 		return nil, fmt.Errorf("failed to open log file: %w", err)
 	}
 
+	fl.pending.Add(1)
 	go fl.cleanupRoutine()
 	return fl, nil
 }
 
 func (fl *FileLogger) openLogFile() error {
-	filename := filepath.Join(fl.logDir, fmt.Sprintf("app_%s.log", time.Now().UTC().Format("2006-01-02")))
+	day := fl.now().UTC().Format("2006-01-02")
+	filename := filepath.Join(fl.logDir, fmt.Sprintf("app_%s.log", day))
 
 	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
@@ -285,6 +398,8 @@ func (fl *FileLogger) openLogFile() error {
 	}
 
 	fl.logFile = file
+	fl.currentDay = day
+	fl.currentSize = 0
 
 	if stat, err := file.Stat(); err == nil {
 		fl.currentSize = stat.Size()
@@ -297,50 +412,179 @@ func (fl *FileLogger) Write(p []byte) (n int, err error) {
 	fl.mu.Lock()
 	defer fl.mu.Unlock()
 
-	if fl.currentSize+int64(len(p)) > fl.maxSize {
+	if fl.logFile == nil {
+		return 0, os.ErrClosed
+	}
+
+	if day := fl.now().UTC().Format("2006-01-02"); day != fl.currentDay {
+		previous := fl.logFile.Name()
+		if err := fl.openLogFile(); err != nil {
+			return 0, err
+		}
+		fl.archive(previous)
+	} else if fl.currentSize > 0 && fl.currentSize+int64(len(p)) > fl.maxSize {
 		if err := fl.rotateLog(); err != nil {
 			return 0, err
 		}
 	}
 
 	n, err = fl.logFile.Write(p)
-	if err == nil {
-		fl.currentSize += int64(n)
-	}
+	fl.currentSize += int64(n)
 	return
 }
 
 func (fl *FileLogger) rotateLog() error {
-	fl.logFile.Close()
-
-	timestamp := time.Now().UTC().Format("2006-01-02_15-04-05")
 	oldName := fl.logFile.Name()
-	newName := strings.Replace(oldName, ".log", fmt.Sprintf("_%s.log", timestamp), 1)
+	stamp := fl.now().UTC().Format("15-04-05.000")
+	newName := filepath.Join(fl.logDir, fmt.Sprintf("app_%s_%s.log", fl.currentDay, stamp))
+	for i := 1; fileExists(newName) || fileExists(newName+".gz"); i++ {
+		newName = filepath.Join(fl.logDir, fmt.Sprintf("app_%s_%s-%d.log", fl.currentDay, stamp, i))
+	}
+
+	fl.logFile.Close()
+	fl.logFile = nil
 
-	if err := os.Rename(oldName, newName); err != nil {
+	renameErr := os.Rename(oldName, newName)
+	if err := fl.openLogFile(); err != nil {
 		return err
 	}
+	if renameErr != nil {
+		return renameErr
+	}
 
-	fl.currentSize = 0
+	fl.archive(newName)
+	return nil
+}
+
+func (fl *FileLogger) Reopen() error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.logFile == nil {
+		return os.ErrClosed
+	}
 	return fl.openLogFile()
 }
 
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// archive compresses a closed log file and applies retention in the
+// background. Compression and cleanup share archiveMu so cleanup never
+// removes a file that is still being compressed.
+func (fl *FileLogger) archive(path string) {
+	fl.pending.Add(1)
+	go func() {
+		defer fl.pending.Done()
+
+		fl.archiveMu.Lock()
+		defer fl.archiveMu.Unlock()
+
+		if fl.compress {
+			fl.compressFile(path)
+		}
+		fl.cleanup()
+	}()
+}
+
+func (fl *FileLogger) compressFile(path string) {
+	if err := compressLogFile(path); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "failed to compress log file %s: %v\n", path, err)
+	}
+}
+
+func compressLogFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz.tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	if stat, err := src.Stat(); err == nil {
+		os.Chtimes(dst.Name(), stat.ModTime(), stat.ModTime())
+	}
+	if err := os.Rename(dst.Name(), path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
 func (fl *FileLogger) cleanupRoutine() {
-	ticker := time.NewTicker(24 * time.Hour)
+	defer fl.pending.Done()
+
+	ticker := time.NewTicker(time.Hour)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		fl.archiveMu.Lock()
+		fl.compressStale()
 		fl.cleanup()
+		fl.archiveMu.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-fl.done:
+			return
+		}
 	}
 }
 
-func (fl *FileLogger) cleanup() {
-	files, err := filepath.Glob(filepath.Join(fl.logDir, "*.log"))
+func (fl *FileLogger) activeFile() string {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.logFile == nil {
+		return ""
+	}
+	return fl.logFile.Name()
+}
+
+func (fl *FileLogger) compressStale() {
+	if !fl.compress {
+		return
+	}
+
+	files, err := filepath.Glob(filepath.Join(fl.logDir, "app_*.log"))
 	if err != nil {
 		return
 	}
 
-	if len(files) <= fl.maxFiles {
+	active := fl.activeFile()
+	for _, file := range files {
+		if file != active {
+			fl.compressFile(file)
+		}
+	}
+}
+
+func (fl *FileLogger) cleanup() {
+	plain, err := filepath.Glob(filepath.Join(fl.logDir, "app_*.log"))
+	if err != nil {
+		return
+	}
+	compressed, err := filepath.Glob(filepath.Join(fl.logDir, "app_*.log.gz"))
+	if err != nil {
 		return
 	}
 
@@ -349,84 +593,326 @@ func (fl *FileLogger) cleanup() {
 		modTime time.Time
 	}
 
+	active := fl.activeFile()
 	var fileInfos []fileInfo
-	for _, file := range files {
+	for _, file := range append(plain, compressed...) {
+		if file == active {
+			continue
+		}
 		if stat, err := os.Stat(file); err == nil {
 			fileInfos = append(fileInfos, fileInfo{file, stat.ModTime()})
 		}
 	}
 
-	if len(fileInfos) <= fl.maxFiles {
-		return
-	}
+	sort.Slice(fileInfos, func(i, j int) bool {
+		return fileInfos[i].modTime.After(fileInfos[j].modTime)
+	})
 
-	for i := 0; i < len(fileInfos)-1; i++ {
-		for j := i + 1; j < len(fileInfos); j++ {
-			if fileInfos[i].modTime.After(fileInfos[j].modTime) {
-				fileInfos[i], fileInfos[j] = fileInfos[j], fileInfos[i]
-			}
+	now := fl.now()
+	for i, info := range fileInfos {
+		if i >= fl.maxFiles || (fl.maxAge > 0 && now.Sub(info.modTime) > fl.maxAge) {
+			os.Remove(info.path)
 		}
 	}
-
-	for i := 0; i < len(fileInfos)-fl.maxFiles; i++ {
-		os.Remove(fileInfos[i].path)
-	}
 }
 
 func (fl *FileLogger) Close() error {
+	fl.closeOnce.Do(func() {
+		close(fl.done)
+	})
+
 	fl.mu.Lock()
-	defer fl.mu.Unlock()
+	var err error
 	if fl.logFile != nil {
-		return fl.logFile.Close()
+		err = fl.logFile.Close()
+		fl.logFile = nil
 	}
-	return nil
+	fl.mu.Unlock()
+
+	fl.pending.Wait()
+	return err
+}
+
+func (m *APIKeyManager) logFileReopener() {
+	if m.fileLogger == nil {
+		return
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-hup:
+				if err := m.fileLogger.Reopen(); err != nil {
+					m.Error("Failed to reopen log file", "error", err)
+					continue
+				}
+				m.Info("Log file reopened")
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}()
 }
 
+const (
+	WSPolicyDisconnect = "disconnect"
+	WSPolicyDrop       = "drop"
+)
+
+var (
+	errSlowConsumer = errors.New("client send queue full")
+	errClientClosed = errors.New("client connection closed")
+)
+
 type WSClient struct {
-	conn     *websocket.Conn
-	clientID string
-	lastPing time.Time
-	mutex    sync.Mutex
+	conn         *websocket.Conn
+	clientID     string
+	lastPing     atomic.Int64
+	transport    string
+	ip           string
+	userAgent    string
+	userID       string
+	tokenID      string
+	expiresAt    time.Time
+	expiryTimer  *time.Timer
+	connectedAt  time.Time
+	subMu        sync.RWMutex
+	logFilter    *LogTailFilter
+	topics       map[string]bool
+	send         chan WSMessage
+	done         chan struct{}
+	closeOnce    sync.Once
+	policy       string
+	writeTimeout time.Duration
+	replayMu     sync.Mutex
+	replay       *wsReplay
+	sent         int64
+	dropped      int64
+}
+
+type WSClientStats struct {
+	ClientID    string    `json:"clientId"`
+	Transport   string    `json:"transport"`
+	UserID      string    `json:"userId,omitempty"`
+	TokenID     string    `json:"tokenId,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	UserAgent   string    `json:"userAgent,omitempty"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	LastPing    time.Time `json:"lastPing"`
+	Topics      []string  `json:"topics"`
+	Policy      string    `json:"policy"`
+	Queued      int       `json:"queued"`
+	Capacity    int       `json:"capacity"`
+	Sent        int64     `json:"sent"`
+	Dropped     int64     `json:"dropped"`
+}
+
+func NewWSClient(conn *websocket.Conn, clientID string, config *Config) *WSClient {
+	now := time.Now().UTC()
+	wsClient := &WSClient{
+		conn:         conn,
+		clientID:     clientID,
+		transport:    TransportWebSocket,
+		connectedAt:  now,
+		send:         make(chan WSMessage, config.WSSendQueueSize),
+		done:         make(chan struct{}),
+		policy:       config.WSSlowConsumerPolicy,
+		writeTimeout: time.Duration(config.WSWriteTimeout) * time.Second,
+	}
+	wsClient.lastPing.Store(now.UnixNano())
+	return wsClient
 }
 
 func (wsc *WSClient) Send(message WSMessage) error {
-	wsc.mutex.Lock()
-	defer wsc.mutex.Unlock()
+	select {
+	case <-wsc.done:
+		return errClientClosed
+	default:
+	}
+
+	select {
+	case wsc.send <- message:
+		return nil
+	default:
+		atomic.AddInt64(&wsc.dropped, 1)
+		return errSlowConsumer
+	}
+}
 
-	wsc.conn.SetWriteDeadline(time.Now().UTC().Add(10 * time.Second))
-	return wsc.conn.WriteJSON(message)
+func (wsc *WSClient) SendWait(message WSMessage, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case wsc.send <- message:
+		return nil
+	case <-wsc.done:
+		return errClientClosed
+	case <-timer.C:
+		atomic.AddInt64(&wsc.dropped, 1)
+		return errSlowConsumer
+	}
+}
+
+func (wsc *WSClient) CloseWith(code int, reason string) {
+	wsc.closeOnce.Do(func() {
+		close(wsc.done)
+		if wsc.expiryTimer != nil {
+			wsc.expiryTimer.Stop()
+		}
+		if wsc.conn == nil {
+			return
+		}
+		wsc.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+		wsc.conn.Close()
+	})
 }
 
 func (wsc *WSClient) Close() error {
-	wsc.mutex.Lock()
-	defer wsc.mutex.Unlock()
-	return wsc.conn.Close()
+	wsc.CloseWith(websocket.CloseNormalClosure, "")
+	return nil
+}
+
+func (wsc *WSClient) Stats() WSClientStats {
+	return WSClientStats{
+		ClientID:    wsc.clientID,
+		Transport:   wsc.transport,
+		UserID:      wsc.userID,
+		TokenID:     wsc.tokenID,
+		IP:          wsc.ip,
+		UserAgent:   wsc.userAgent,
+		ExpiresAt:   wsc.expiresAt,
+		ConnectedAt: wsc.connectedAt,
+		LastPing:    time.Unix(0, wsc.lastPing.Load()).UTC(),
+		Topics:      wsc.Topics(),
+		Policy:      wsc.policy,
+		Queued:      len(wsc.send),
+		Capacity:    cap(wsc.send),
+		Sent:        atomic.LoadInt64(&wsc.sent),
+		Dropped:     atomic.LoadInt64(&wsc.dropped),
+	}
 }
 
 type APIKeyManager struct {
-	mongoClient       *mongo.Client
-	apiKeysCollection *mongo.Collection
-	logsCollection    *mongo.Collection
-	cache             *Cache
-	config            *Config
-	validator         *validator.Validate
-	startTime         time.Time
-	upgrader          websocket.Upgrader
-	wsClients         sync.Map
-	eventChan         chan WSMessage
-	shutdownOnce      sync.Once
-	ctx               context.Context
-	cancel            context.CancelFunc
-	mongoConnected    int32
-	fileLogger        *FileLogger
+	mongoClient            *mongo.Client
+	apiKeysCollection      *mongo.Collection
+	logsCollection         *mongo.Collection
+	cache                  *Cache
+	config                 *Config
+	validator              *validator.Validate
+	startTime              time.Time
+	upgrader               websocket.Upgrader
+	wsClients              sync.Map
+	eventChan              chan WSMessage
+	shutdownOnce           sync.Once
+	ctx                    context.Context
+	cancel                 context.CancelFunc
+	mongoConnected         int32
+	fileLogger             *FileLogger
+	logger                 *slog.Logger
+	plans                  map[string]*Plan
+	limiter                *KeyLimiter
+	quotas                 *QuotaTracker
+	verifyMisses           verifyMissTracker
+	usagePeriodsCollection *mongo.Collection
+	usage                  *UsageRecorder
+	usageBucketsCollection *mongo.Collection
+	usageReportsCollection *mongo.Collection
+	auditCollection        *mongo.Collection
+	auditHeadCollection    *mongo.Collection
+	revocationsCollection  *mongo.Collection
+	sessionsCollection     *mongo.Collection
+	webhooksCollection     *mongo.Collection
+	deliveriesCollection   *mongo.Collection
+	webhooks               *WebhookDispatcher
+	notifier               *Notifier
+	revocations            *TokenRevocations
+	sessions               *IssuedSessions
+	audit                  AuditChain
+	logRetention           map[string]time.Duration
+	logSinks               []*LogSink
+	logPipeline            *LogPipeline
+	logTail                *LogTailBuffer
+	eventHistory           *EventHistory
+	eventMu                sync.Mutex
+	metrics                *Metrics
+	tracing                *Tracing
+	cacheLoaded            atomic.Bool
+	draining               atomic.Bool
+	readinessPingFailing   atomic.Bool
 }
 
 func NewAPIKeyManager(config *Config) (*APIKeyManager, error) {
 	v := validator.New()
 
-	fileLogger, err := NewFileLogger(config.LogDir, config.MaxLogSize, config.MaxLogFiles)
+	fileLogger, fileLoggerErr := NewFileLogger(config.LogDir, config.MaxLogSize, config.MaxLogFiles, config.MaxLogAge, config.CompressLogs)
+
+	logSinks, err := newLogSinks(config.LogSinks)
 	if err != nil {
-		log.Printf("Warning: Failed to initialize file logger: %v", err)
+		return nil, fmt.Errorf("failed to initialize log sinks: %w", err)
+	}
+
+	logger, err := newLogger(config, fileLogger, logSinks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	if fileLoggerErr != nil {
+		logger.Warn("Failed to initialize file logger", "error", fileLoggerErr)
+	}
+
+	logRetention, err := parseLogRetention(config.LogRetention)
+	if err != nil {
+		return nil, err
+	}
+	if config.LogArchiveDir == "" {
+		config.LogArchiveDir = filepath.Join(config.LogDir, "archive")
+	}
+	if config.LogJournalDir == "" {
+		config.LogJournalDir = filepath.Join(config.LogDir, "journal")
+	}
+	switch config.WSSlowConsumerPolicy {
+	case WSPolicyDisconnect, WSPolicyDrop:
+	default:
+		return nil, fmt.Errorf("invalid wsSlowConsumerPolicy '%s': supported policies are disconnect, drop", config.WSSlowConsumerPolicy)
+	}
+	if err := resolveConfigSecrets(config, logger); err != nil {
+		return nil, err
+	}
+	if config.WSSendQueueSize <= 0 {
+		config.WSSendQueueSize = 256
+	}
+	if config.WSWriteTimeout <= 0 {
+		config.WSWriteTimeout = 10
+	}
+	if config.SSEHeartbeatInterval <= 0 {
+		config.SSEHeartbeatInterval = 15
+	}
+	if config.WSAuthTimeout <= 0 {
+		config.WSAuthTimeout = 10
+	}
+	if config.RevocationsCollection == "" {
+		config.RevocationsCollection = "revocations"
+	}
+	if config.SessionsCollection == "" {
+		config.SessionsCollection = "sessions"
+	}
+	if config.WebhooksCollection == "" {
+		config.WebhooksCollection = "webhooks"
+	}
+	if config.DeliveriesCollection == "" {
+		config.DeliveriesCollection = "webhookDeliveries"
+	}
+	if config.WebhookMaxAttempts <= 0 {
+		config.WebhookMaxAttempts = 6
+	}
+	if config.WebhookTimeout <= 0 {
+		config.WebhookTimeout = 10
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -437,9 +923,7 @@ func NewAPIKeyManager(config *Config) (*APIKeyManager, error) {
 		validator: v,
 		startTime: time.Now().UTC(),
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
+			Subprotocols:    []string{wsAuthProtocol},
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
 		},
@@ -447,28 +931,91 @@ func NewAPIKeyManager(config *Config) (*APIKeyManager, error) {
 		ctx:        ctx,
 		cancel:     cancel,
 		fileLogger: fileLogger,
+		logger:     logger,
+		limiter:    NewKeyLimiter(time.Duration(config.LeaseTimeout) * time.Second),
+		quotas:     NewQuotaTracker(),
+		usage:      NewUsageRecorder(),
+		logTail:    NewLogTailBuffer(logTailBufferSize),
+
+		revocations: NewTokenRevocations(),
+		sessions:    NewIssuedSessions(),
+
+		eventHistory: NewEventHistory(eventHistorySize),
+		metrics:      NewMetrics(),
+
+		logRetention: logRetention,
+		logSinks:     logSinks,
+	}
+
+	manager.loadPlans()
+	manager.registerMetrics()
+
+	manager.tracing, err = NewTracing(config.Tracing)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to initialize tracing: %w", err)
 	}
 
+	manager.upgrader.CheckOrigin = manager.checkWebSocketOrigin
+	webhookTargets, err := newWebhookTargetPolicy(config.WebhookAllowedHosts)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("invalid webhookAllowedHosts: %w", err)
+	}
+	manager.webhooks = NewWebhookDispatcher(manager, time.Duration(config.WebhookTimeout)*time.Second, webhookTargets)
+	manager.notifier, err = NewNotifier(manager, config.Notifications)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("invalid notifications config: %w", err)
+	}
+	manager.logPipeline = NewLogPipeline(manager, config.LogQueueSize, config.LogJournalDir, config.LogJournalMaxSize)
+	manager.logPipeline.Start()
+
 	return manager, nil
 }
 
 func loadConfig(filePath string) (*Config, error) {
 	config := &Config{
-		ServerPort:        "3001",
-		MongoURI:          "mongodb://localhost:27017",
-		DatabaseName:      "apikeys",
-		ApiKeysCollection: "keys",
-		LogsCollection:    "logs",
-		ReadTimeout:       30,
-		WriteTimeout:      30,
-		IdleTimeout:       120,
-		JWTSecret:         generateSecureKey(64),
-		AdminPassword:     "admin123",
-		MaxRetries:        3,
-		RetryDelay:        1000,
-		LogDir:            "logs",
-		MaxLogSize:        10 * 1024 * 1024,
-		MaxLogFiles:       5,
+		ServerPort:             "3001",
+		MongoURI:               "mongodb://localhost:27017",
+		DatabaseName:           "apikeys",
+		ApiKeysCollection:      "keys",
+		LogsCollection:         "logs",
+		ReadTimeout:            30,
+		WriteTimeout:           30,
+		IdleTimeout:            120,
+		JWTSecret:              generateSecureKey(64),
+		AdminPassword:          "admin123",
+		MaxRetries:             3,
+		RetryDelay:             1000,
+		LogDir:                 "logs",
+		MaxLogSize:             10 * 1024 * 1024,
+		MaxLogFiles:            5,
+		MaxLogAge:              30,
+		CompressLogs:           true,
+		LogQueueSize:           defaultLogQueueSize,
+		LogJournalMaxSize:      defaultLogJournalSize,
+		WSSendQueueSize:        256,
+		WSSlowConsumerPolicy:   WSPolicyDisconnect,
+		WSWriteTimeout:         10,
+		SSEHeartbeatInterval:   15,
+		WSAuthTimeout:          10,
+		RevocationsCollection:  "revocations",
+		SessionsCollection:     "sessions",
+		WebhooksCollection:     "webhooks",
+		DeliveriesCollection:   "webhookDeliveries",
+		WebhookMaxAttempts:     6,
+		WebhookTimeout:         10,
+		ShutdownDrainDelay:     5,
+		LogFormat:              LogFormatJSON,
+		LogLevel:               "info",
+		LeaseTimeout:           60,
+		QuotaTimezone:          "UTC",
+		UsagePeriodsCollection: "usagePeriods",
+		UsageBucketsCollection: "usageBuckets",
+		UsageReportsCollection: "usageReports",
+		AuditCollection:        "auditLog",
+		AuditHeadCollection:    "auditHead",
 	}
 
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
@@ -490,43 +1037,77 @@ func loadConfig(filePath string) (*Config, error) {
 	return config, nil
 }
 
-func generateSecureKey(length int) string {
-	key, _ := generateRandomKey(length)
-	return key
+const (
+	secretsDirName          = "secrets"
+	auditSecretPlaceholder  = "your-audit-hmac-secret-change-this-in-production"
+	keyRefSecretPlaceholder = "your-billing-keyref-secret-change-this-in-production"
+)
+
+func secretPath(dir, name string) string {
+	return filepath.Join(dir, secretsDirName, name)
 }
 
-func (m *APIKeyManager) logToFile(level, message string, fields ...interface{}) {
-	if m.fileLogger == nil {
-		return
+// resolveSecret returns value when it is configured. Otherwise it returns the
+// secret stored under dir, generating and storing one on first start so that
+// it stays the same across restarts. generated reports that the stored secret
+// is in use.
+func resolveSecret(value, placeholder, dir, name string) (secret string, generated bool, err error) {
+	if value == placeholder {
+		return "", false, fmt.Errorf("%s is still the example value from server.json: set a unique secret or remove it to have one generated", name)
 	}
-
-	timestamp := time.Now().UTC().Format(time.RFC3339)
-	logLine := fmt.Sprintf("[%s] %s: %s", timestamp, level, message)
-
-	if len(fields) > 0 {
-		logLine += fmt.Sprintf(" %v", fields)
+	if value != "" {
+		return value, false, nil
 	}
 
-	logLine += "\n"
-	m.fileLogger.Write([]byte(logLine))
-}
+	path := secretPath(dir, name)
+	if data, err := os.ReadFile(path); err == nil {
+		if stored := strings.TrimSpace(string(data)); stored != "" {
+			return stored, true, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", false, fmt.Errorf("failed to read %s: %w", name, err)
+	}
 
-func (m *APIKeyManager) Info(message string, fields ...interface{}) {
-	m.logToFile("INFO", message, fields...)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", false, fmt.Errorf("failed to store %s: %w", name, err)
+	}
+	if secret, err = generateRandomKey(64); err != nil {
+		return "", false, err
+	}
+	if err := os.WriteFile(path, []byte(secret), 0600); err != nil {
+		return "", false, fmt.Errorf("failed to store %s: %w", name, err)
+	}
+	return secret, true, nil
 }
 
-func (m *APIKeyManager) Error(message string, fields ...interface{}) {
-	log.Printf("[ERROR] %s %v", message, fields)
-	m.logToFile("ERROR", message, fields...)
-}
+// resolveConfigSecrets fills in keyRefSecret and auditSecret, which the
+// server and the report command must agree on.
+func resolveConfigSecrets(config *Config, logger *slog.Logger) error {
+	keyRefSecret, generated, err := resolveSecret(config.KeyRefSecret, keyRefSecretPlaceholder, config.LogDir, "keyRefSecret")
+	if err != nil {
+		return err
+	}
+	if generated {
+		logger.Warn("keyRefSecret is not configured, using a generated secret stored under the log directory; set keyRefSecret explicitly when several instances report usage",
+			"path", secretPath(config.LogDir, "keyRefSecret"))
+	}
+	config.KeyRefSecret = keyRefSecret
 
-func (m *APIKeyManager) Warn(message string, fields ...interface{}) {
-	log.Printf("[WARN] %s %v", message, fields)
-	m.logToFile("WARN", message, fields...)
+	auditSecret, generated, err := resolveSecret(config.AuditSecret, auditSecretPlaceholder, config.LogDir, "auditSecret")
+	if err != nil {
+		return err
+	}
+	if generated {
+		logger.Warn("auditSecret is not configured, using a generated secret stored under the log directory; set auditSecret explicitly when several instances share the audit log",
+			"path", secretPath(config.LogDir, "auditSecret"))
+	}
+	config.AuditSecret = auditSecret
+	return nil
 }
 
-func (m *APIKeyManager) Debug(message string, fields ...interface{}) {
-	m.logToFile("DEBUG", message, fields...)
+func generateSecureKey(length int) string {
+	key, _ := generateRandomKey(length)
+	return key
 }
 
 func (m *APIKeyManager) connectMongo() error {
@@ -540,7 +1121,8 @@ func (m *APIKeyManager) connectMongo() error {
 		SetRetryReads(true).
 		SetConnectTimeout(15 * time.Second).
 		SetServerSelectionTimeout(15 * time.Second).
-		SetSocketTimeout(30 * time.Second)
+		SetSocketTimeout(30 * time.Second).
+		SetMonitor(otelmongo.NewMonitor())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
@@ -562,11 +1144,25 @@ func (m *APIKeyManager) connectMongo() error {
 
 	m.apiKeysCollection = m.mongoClient.Database(m.config.DatabaseName).Collection(m.config.ApiKeysCollection)
 	m.logsCollection = m.mongoClient.Database(m.config.DatabaseName).Collection(m.config.LogsCollection)
+	m.usagePeriodsCollection = m.mongoClient.Database(m.config.DatabaseName).Collection(m.config.UsagePeriodsCollection)
+	m.usageBucketsCollection = m.mongoClient.Database(m.config.DatabaseName).Collection(m.config.UsageBucketsCollection)
+	m.usageReportsCollection = m.mongoClient.Database(m.config.DatabaseName).Collection(m.config.UsageReportsCollection)
+	m.auditCollection = m.mongoClient.Database(m.config.DatabaseName).Collection(m.config.AuditCollection)
+	m.auditHeadCollection = m.mongoClient.Database(m.config.DatabaseName).Collection(m.config.AuditHeadCollection)
+	m.revocationsCollection = m.mongoClient.Database(m.config.DatabaseName).Collection(m.config.RevocationsCollection)
+	m.sessionsCollection = m.mongoClient.Database(m.config.DatabaseName).Collection(m.config.SessionsCollection)
+	m.webhooksCollection = m.mongoClient.Database(m.config.DatabaseName).Collection(m.config.WebhooksCollection)
+	m.deliveriesCollection = m.mongoClient.Database(m.config.DatabaseName).Collection(m.config.DeliveriesCollection)
+	m.notifier.collection = m.mongoClient.Database(m.config.DatabaseName).Collection(m.notifier.config.Collection)
 
 	if err := m.createIndexes(); err != nil {
 		m.Warn("Failed to create indexes", "error", err)
 	}
 
+	if err := m.loadRevokedTokens(); err != nil {
+		m.Warn("Failed to load revoked tokens", "error", err)
+	}
+
 	m.setMongoStatus(true)
 	m.Info("Successfully connected to MongoDB")
 	return nil
@@ -587,15 +1183,81 @@ func (m *APIKeyManager) createIndexes() error {
 	}
 
 	logsIndexes := []mongo.IndexModel{
-		{Keys: bson.D{{Key: "timestamp", Value: -1}}},
-		{Keys: bson.D{{Key: "level", Value: 1}}},
-		{Keys: bson.D{{Key: "component", Value: 1}}},
+		{Keys: bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "level", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "component", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "timestamp", Value: -1}}, Options: options.Index().SetSparse(true)},
 	}
 
 	if _, err := m.logsCollection.Indexes().CreateMany(ctx, logsIndexes); err != nil {
 		return fmt.Errorf("failed to create logs indexes: %w", err)
 	}
 
+	usagePeriodsIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "keyId", Value: 1}, {Key: "periodStart", Value: -1}}},
+		{Keys: bson.D{{Key: "closed", Value: 1}}},
+		{Keys: bson.D{{Key: "scope", Value: 1}, {Key: "periodEnd", Value: 1}}},
+	}
+
+	if _, err := m.usagePeriodsCollection.Indexes().CreateMany(ctx, usagePeriodsIndexes); err != nil {
+		return fmt.Errorf("failed to create usage periods indexes: %w", err)
+	}
+
+	usageBucketsIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "keyId", Value: 1}, {Key: "granularity", Value: 1}, {Key: "start", Value: 1}}},
+		{Keys: bson.D{{Key: "granularity", Value: 1}, {Key: "start", Value: 1}}},
+		{Keys: bson.D{{Key: "expireAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+
+	if _, err := m.usageBucketsCollection.Indexes().CreateMany(ctx, usageBucketsIndexes); err != nil {
+		return fmt.Errorf("failed to create usage buckets indexes: %w", err)
+	}
+
+	auditIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}}},
+		{Keys: bson.D{{Key: "actor", Value: 1}}},
+		{Keys: bson.D{{Key: "resourceId", Value: 1}}},
+	}
+
+	if _, err := m.auditCollection.Indexes().CreateMany(ctx, auditIndexes); err != nil {
+		return fmt.Errorf("failed to create audit indexes: %w", err)
+	}
+
+	revokedTokensIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+
+	if _, err := m.revocationsCollection.Indexes().CreateMany(ctx, revokedTokensIndexes); err != nil {
+		return fmt.Errorf("failed to create revoked tokens indexes: %w", err)
+	}
+
+	sessionsIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+
+	if _, err := m.sessionsCollection.Indexes().CreateMany(ctx, sessionsIndexes); err != nil {
+		return fmt.Errorf("failed to create sessions indexes: %w", err)
+	}
+
+	deliveriesIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "webhookId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(webhookDeliveryTTL.Seconds()))},
+	}
+
+	if _, err := m.deliveriesCollection.Indexes().CreateMany(ctx, deliveriesIndexes); err != nil {
+		return fmt.Errorf("failed to create webhook deliveries indexes: %w", err)
+	}
+
+	notificationsIndexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expireAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+
+	if _, err := m.mongoClient.Database(m.config.DatabaseName).Collection(m.notifier.config.Collection).Indexes().CreateMany(ctx, notificationsIndexes); err != nil {
+		return fmt.Errorf("failed to create notifications indexes: %w", err)
+	}
+
 	return nil
 }
 
@@ -656,6 +1318,7 @@ func (m *APIKeyManager) loadAPIKeysToCache() error {
 		return fmt.Errorf("cursor error: %w", err)
 	}
 
+	m.cacheLoaded.Store(true)
 	m.Info("Loaded API keys to cache", "count", count)
 	return nil
 }
@@ -740,6 +1403,7 @@ func (m *APIKeyManager) toAPIKeyResponse(apiKey *APIKey) APIKeyResponse {
 	return APIKeyResponse{
 		ID:            apiKey.ID,
 		MaskedKey:     maskAPIKey(apiKey.ID),
+		KeyRef:        m.keyRef(apiKey.ID),
 		Name:          apiKey.Name,
 		Expiration:    apiKey.Expiration,
 		RPM:           apiKey.RPM,
@@ -750,39 +1414,79 @@ func (m *APIKeyManager) toAPIKeyResponse(apiKey *APIKey) APIKeyResponse {
 		UpdatedAt:     apiKey.UpdatedAt,
 		IsActive:      apiKey.IsActive,
 		LastUsed:      apiKey.LastUsed,
+		Scopes:        scopesOrEmpty(apiKey.Scopes),
+		Plan:          apiKey.Plan,
+		OrgID:         apiKey.OrgID,
+		Limits:        limitRulesOrEmpty(apiKey.Limits),
+		Quota:         apiKey.Quota,
+		QuotaUsage:    m.quotas.Usage(apiKey, time.Now().UTC()),
 	}
 }
 
-func (m *APIKeyManager) withRetry(operation func() error) error {
+func (m *APIKeyManager) withRetry(opName string, operation func() error) error {
+	return m.retry(m.ctx, opName, func(context.Context) error { return operation() })
+}
+
+func (m *APIKeyManager) withRetryContext(ctx context.Context, opName string, operation func(ctx context.Context) error) error {
+	return m.retry(ctx, opName, operation)
+}
+
+func (m *APIKeyManager) retry(ctx context.Context, opName string, operation func(ctx context.Context) error) error {
+	ctx, span := m.tracing.tracer.Start(ctx, "retry "+opName, trace.WithAttributes(attribute.String("operation", opName)))
+	defer span.End()
+
+	attempts := m.config.MaxRetries
+	if attempts < 1 {
+		attempts = 1
+	}
+
 	var lastErr error
-	for i := 0; i < m.config.MaxRetries; i++ {
-		err := operation()
+	for i := 0; i < attempts; i++ {
+		start := time.Now()
+		err := operation(ctx)
 		if err == nil {
+			m.metrics.mongoDuration.WithLabelValues(opName, "success").Observe(time.Since(start).Seconds())
+			span.SetAttributes(attribute.Int("attempts", i+1))
 			return nil
 		}
+		m.metrics.mongoDuration.WithLabelValues(opName, "error").Observe(time.Since(start).Seconds())
 		lastErr = err
-		if i < m.config.MaxRetries-1 {
+		if i < attempts-1 {
+			m.metrics.mongoRetries.WithLabelValues(opName).Inc()
+			backoff := time.Duration(m.config.RetryDelay) * time.Millisecond * time.Duration(i+1)
+			span.AddEvent("retry", trace.WithAttributes(
+				attribute.Int("attempt", i+1),
+				attribute.String("error", err.Error()),
+				attribute.Int64("backoff_ms", backoff.Milliseconds()),
+			))
 			select {
-			case <-time.After(time.Duration(m.config.RetryDelay) * time.Millisecond * time.Duration(i+1)):
-			case <-m.ctx.Done():
-				return m.ctx.Err()
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				span.SetStatus(codes.Error, ctx.Err().Error())
+				return ctx.Err()
 			}
 		}
 	}
-	return fmt.Errorf("operation failed after %d retries: %w", m.config.MaxRetries, lastErr)
+	m.metrics.mongoErrors.WithLabelValues(opName).Inc()
+	span.SetAttributes(attribute.Int("attempts", attempts))
+	if lastErr != nil {
+		span.RecordError(lastErr)
+		span.SetStatus(codes.Error, lastErr.Error())
+	}
+	return fmt.Errorf("operation failed after %d retries: %w", attempts, lastErr)
 }
 
-func (m *APIKeyManager) SaveAPIKey(apiKey *APIKey) error {
+func (m *APIKeyManager) SaveAPIKey(ctx context.Context, apiKey *APIKey) error {
 	if err := m.ensureMongoConnection(); err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(m.ctx, 15*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	apiKey.UpdatedAt = time.Now().UTC()
 
-	return m.withRetry(func() error {
+	return m.withRetryContext(ctx, "saveAPIKey", func(ctx context.Context) error {
 		_, err := m.apiKeysCollection.ReplaceOne(
 			ctx,
 			bson.M{"_id": apiKey.ID},
@@ -793,11 +1497,25 @@ func (m *APIKeyManager) SaveAPIKey(apiKey *APIKey) error {
 	})
 }
 
-func (m *APIKeyManager) generateAPIKey(req CreateKeyRequest) (*APIKey, error) {
-	req.Name = strings.TrimSpace(req.Name)
-	if req.Name == "" {
-		return nil, errors.New("API key name cannot be empty")
+// normalizeKeyName trims a key name and rejects control characters, since
+// names end up in log lines and notification mail headers.
+func normalizeKeyName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("API key name cannot be empty")
+	}
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "", errors.New("API key name must not contain control characters")
+	}
+	return name, nil
+}
+
+func (m *APIKeyManager) generateAPIKey(ctx context.Context, req CreateKeyRequest) (*APIKey, error) {
+	name, err := normalizeKeyName(req.Name)
+	if err != nil {
+		return nil, err
 	}
+	req.Name = name
 
 	expirationDuration, err := parseExpiration(req.Expiration)
 	if err != nil {
@@ -807,6 +1525,28 @@ func (m *APIKeyManager) generateAPIKey(req CreateKeyRequest) (*APIKey, error) {
 
 	m.Debug("Parsed expiration", "input", req.Expiration, "duration", expirationDuration)
 
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Plan = strings.TrimSpace(req.Plan)
+	if req.Plan != "" {
+		if _, ok := m.getPlan(req.Plan); !ok {
+			return nil, fmt.Errorf("unknown plan '%s'", req.Plan)
+		}
+	}
+
+	limits, err := validateLimitRules(req.Limits)
+	if err != nil {
+		return nil, err
+	}
+
+	quota, err := validateQuotaConfig(req.Quota, m.config.QuotaTimezone)
+	if err != nil {
+		return nil, err
+	}
+
 	var keyID string
 	if req.CustomKey != "" {
 		if len(req.CustomKey) < 16 || len(req.CustomKey) > 64 {
@@ -856,17 +1596,22 @@ func (m *APIKeyManager) generateAPIKey(req CreateKeyRequest) (*APIKey, error) {
 		CreatedAt:     now,
 		UpdatedAt:     now,
 		IsActive:      true,
+		Scopes:        scopes,
+		Plan:          req.Plan,
+		OrgID:         strings.TrimSpace(req.OrgID),
+		Limits:        limits,
+		Quota:         quota,
 		Metadata:      make(map[string]interface{}),
 	}
 
-	if err = m.SaveAPIKey(apiKey); err != nil {
+	if err = m.SaveAPIKey(ctx, apiKey); err != nil {
 		m.Error("Failed to save API key to database", "keyId", maskAPIKey(keyID), "error", err)
 		return nil, fmt.Errorf("failed to save API key: %w", err)
 	}
 
 	m.cache.SetAPIKey(apiKey)
 
-	m.logMessage("INFO", "API Key generated successfully", map[string]interface{}{
+	m.logMessage(ctx, "INFO", "API Key generated successfully", map[string]interface{}{
 		"component":  "apikey",
 		"keyId":      maskAPIKey(apiKey.ID),
 		"name":       apiKey.Name,
@@ -875,11 +1620,12 @@ func (m *APIKeyManager) generateAPIKey(req CreateKeyRequest) (*APIKey, error) {
 		"userId":     "admin",
 	})
 
-	m.broadcastEvent(WSMessage{
+	m.broadcastEventContext(ctx, WSMessage{
 		Type:      "key_created",
 		Data:      m.toAPIKeyResponse(apiKey),
 		Timestamp: time.Now().UTC(),
 		ID:        generateRequestID(),
+		Topic:     TopicKeys + ":" + apiKey.ID,
 	})
 
 	return apiKey, nil
@@ -896,11 +1642,13 @@ func (m *APIKeyManager) validationMiddleware() gin.HandlerFunc {
 			contentType := c.GetHeader("Content-Type")
 			if !strings.Contains(contentType, "application/json") {
 				m.respondWithError(c, http.StatusBadRequest, "Content-Type must be application/json", "INVALID_CONTENT_TYPE", nil)
+				c.Abort()
 				return
 			}
 
 			if c.Request.ContentLength > 1024*1024 {
 				m.respondWithError(c, http.StatusRequestEntityTooLarge, "Request body too large", "BODY_TOO_LARGE", nil)
+				c.Abort()
 				return
 			}
 		}
@@ -912,7 +1660,7 @@ func (m *APIKeyManager) corsMiddleware() gin.HandlerFunc {
 	config := cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-Requested-With"},
+		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-Requested-With", "X-API-Key", "Last-Event-ID"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: false,
 		MaxAge:           12 * time.Hour,
@@ -923,6 +1671,10 @@ func (m *APIKeyManager) corsMiddleware() gin.HandlerFunc {
 func (m *APIKeyManager) requestIDMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		requestID := generateRequestID()
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			requestID = sc.TraceID().String()
+			c.Set("spanID", sc.SpanID().String())
+		}
 		c.Set("requestID", requestID)
 		c.Header("X-Request-ID", requestID)
 		c.Next()
@@ -930,23 +1682,38 @@ func (m *APIKeyManager) requestIDMiddleware() gin.HandlerFunc {
 }
 
 func (m *APIKeyManager) loggingMiddleware() gin.HandlerFunc {
-	return gin.LoggerWithConfig(gin.LoggerConfig{
-		Output: io.Discard,
-		Formatter: func(param gin.LogFormatterParams) string {
-			if param.StatusCode >= 400 {
-				log.Printf("[%d] %s %s %v", param.StatusCode, param.Method, param.Path, param.Latency)
-			}
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		query := redactQuery(c.Request.URL)
 
-			m.Info("Request",
-				"method", param.Method,
-				"path", param.Path,
-				"status", param.StatusCode,
-				"latency", param.Latency,
-				"ip", param.ClientIP,
-			)
-			return ""
-		},
-	})
+		c.Next()
+
+		latency := time.Since(start)
+		m.observeRequest(c, latency)
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		attrs := []interface{}{
+			"component", "http",
+			"method", c.Request.Method,
+			"path", path,
+			"status", status,
+			"latency", latency,
+			"ip", c.ClientIP(),
+		}
+		if query != "" {
+			attrs = append(attrs, "query", query)
+		}
+		m.logger.Log(c, level, "Request", attrs...)
+	}
 }
 
 func (m *APIKeyManager) authMiddleware() gin.HandlerFunc {
@@ -959,6 +1726,7 @@ func (m *APIKeyManager) authMiddleware() gin.HandlerFunc {
 		token := c.GetHeader("Authorization")
 		if token == "" {
 			m.respondWithError(c, http.StatusUnauthorized, "Authorization header required", "AUTH_MISSING", nil)
+			c.Abort()
 			return
 		}
 
@@ -966,16 +1734,10 @@ func (m *APIKeyManager) authMiddleware() gin.HandlerFunc {
 			token = token[7:]
 		}
 
-		claims := jwt.MapClaims{}
-		parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(m.config.JWTSecret), nil
-		})
-
-		if err != nil || !parsedToken.Valid {
+		claims, err := m.parseSessionToken(token)
+		if err != nil {
 			m.respondWithError(c, http.StatusUnauthorized, "Invalid or expired token", "AUTH_INVALID", err)
+			c.Abort()
 			return
 		}
 
@@ -997,7 +1759,7 @@ func (m *APIKeyManager) respondWithError(c *gin.Context, statusCode int, message
 
 	if err != nil {
 		response.Details = err.Error()
-		m.Error("Request error", "error", err, "requestId", requestID, "path", c.Request.URL.Path)
+		m.ErrorContext(c, "Request error", "error", err, "path", c.Request.URL.Path)
 	}
 
 	c.JSON(statusCode, response)
@@ -1044,6 +1806,9 @@ func (m *APIKeyManager) healthHandler(c *gin.Context) {
 		"mongoStatus":  m.isMongoConnected(),
 		"cacheHitRate": m.cache.GetHitRate(),
 		"cacheSize":    m.cache.Size(),
+		"logPipeline":  m.logPipeline.Stats(),
+		"webhooks":     m.webhooks.Stats(),
+		"audit":        m.auditStats(),
 		"goRoutines":   runtime.NumGoroutine(),
 		"serverTime":   time.Now().UTC().Format(time.RFC3339),
 		"timezone":     "UTC",
@@ -1070,10 +1835,11 @@ func (m *APIKeyManager) loginHandler(c *gin.Context) {
 		return
 	}
 
-	m.Info("Login attempt", "ip", c.ClientIP())
+	m.InfoContext(c, "Login attempt", "ip", c.ClientIP())
 
 	if req.Password != m.config.AdminPassword {
-		m.Warn("Failed login attempt", "ip", c.ClientIP())
+		m.WarnContext(c, "Failed login attempt", "ip", c.ClientIP())
+		m.recordLoginFailure(c)
 		m.respondWithError(c, http.StatusUnauthorized, "Invalid password", "AUTH_FAILED", nil)
 		return
 	}
@@ -1089,14 +1855,18 @@ func (m *APIKeyManager) loginHandler(c *gin.Context) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString([]byte(m.config.JWTSecret))
 	if err != nil {
-		m.Error("Failed to generate token", "error", err)
+		m.ErrorContext(c, "Failed to generate token", "error", err)
 		m.respondWithError(c, http.StatusInternalServerError, "Failed to generate authentication token", "TOKEN_ERROR", err)
 		return
 	}
 
-	m.Info("Successful login", "ip", c.ClientIP())
+	m.InfoContext(c, "Successful login", "ip", c.ClientIP())
+
+	c.Set("userID", "admin")
+	m.recordSession(c, claims["jti"].(string), "admin", expiresAt)
+	m.recordAudit(c, AuditActionLogin, "session", claims["jti"].(string), nil)
 
-	m.logMessage("INFO", "User login", map[string]interface{}{
+	m.logMessage(c, "INFO", "User login", map[string]interface{}{
 		"component": "auth",
 		"userId":    "admin",
 		"ip":        c.ClientIP(),
@@ -1115,19 +1885,20 @@ func (m *APIKeyManager) createAPIKeyHandler(c *gin.Context) {
 		return
 	}
 
-	apiKey, err := m.generateAPIKey(req)
+	apiKey, err := m.generateAPIKey(m.traceContext(c), req)
 	if err != nil {
-		m.Error("Failed to create API key", "error", err, "ip", c.ClientIP())
+		m.ErrorContext(c, "Failed to create API key", "error", err, "ip", c.ClientIP())
 		m.respondWithError(c, http.StatusBadRequest, err.Error(), "KEY_CREATION_FAILED", err)
 		return
 	}
 
-	m.Info("API key created successfully", "keyId", maskAPIKey(apiKey.ID), "ip", c.ClientIP())
+	m.InfoContext(c, "API key created successfully", "keyId", maskAPIKey(apiKey.ID), "ip", c.ClientIP())
+	m.recordKeyAudit(c, AuditActionKeyCreate, apiKey.ID, auditKeyChanges(nil, apiKey))
 	m.respondWithSuccess(c, m.toAPIKeyResponse(apiKey), "API key created successfully")
 }
 
 func (m *APIKeyManager) listAPIKeysHandler(c *gin.Context) {
-	m.Debug("API Keys request", "ip", c.ClientIP())
+	m.DebugContext(c, "API Keys request", "ip", c.ClientIP())
 
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
@@ -1238,15 +2009,21 @@ func (m *APIKeyManager) updateAPIKeyHandler(c *gin.Context) {
 		return
 	}
 
+	// Edit a copy: the cached key is only replaced once the save succeeds, and
+	// before stays intact for the audit diff.
+	before := *apiKey
+	next := *apiKey
+	apiKey = &next
 	changes := []string{}
 	updated := false
 
 	if req.Name != nil && strings.TrimSpace(*req.Name) != apiKey.Name {
-		if strings.TrimSpace(*req.Name) == "" {
-			m.respondWithError(c, http.StatusBadRequest, "API key name cannot be empty", "INVALID_NAME", nil)
+		name, err := normalizeKeyName(*req.Name)
+		if err != nil {
+			m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_NAME", nil)
 			return
 		}
-		apiKey.Name = strings.TrimSpace(*req.Name)
+		apiKey.Name = name
 		changes = append(changes, "name")
 		updated = true
 	}
@@ -1275,10 +2052,68 @@ func (m *APIKeyManager) updateAPIKeyHandler(c *gin.Context) {
 		updated = true
 	}
 
+	if req.Scopes != nil {
+		scopes, err := normalizeScopes(*req.Scopes)
+		if err != nil {
+			m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_SCOPES", nil)
+			return
+		}
+		if !equalScopes(scopes, apiKey.Scopes) {
+			apiKey.Scopes = scopes
+			changes = append(changes, "scopes")
+			updated = true
+		}
+	}
+
+	if req.Plan != nil && strings.TrimSpace(*req.Plan) != apiKey.Plan {
+		plan := strings.TrimSpace(*req.Plan)
+		if plan != "" {
+			if _, ok := m.getPlan(plan); !ok {
+				m.respondWithError(c, http.StatusBadRequest, fmt.Sprintf("Unknown plan '%s'", plan), "INVALID_PLAN", nil)
+				return
+			}
+		}
+		apiKey.Plan = plan
+		changes = append(changes, "plan")
+		updated = true
+	}
+
+	if req.OrgID != nil && strings.TrimSpace(*req.OrgID) != apiKey.OrgID {
+		apiKey.OrgID = strings.TrimSpace(*req.OrgID)
+		changes = append(changes, "orgId")
+		updated = true
+	}
+
+	if req.Limits != nil {
+		limits, err := validateLimitRules(*req.Limits)
+		if err != nil {
+			m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_LIMITS", nil)
+			return
+		}
+		if !equalLimitRules(limits, apiKey.Limits) {
+			apiKey.Limits = limits
+			changes = append(changes, "limits")
+			updated = true
+		}
+	}
+
+	if req.Quota != nil {
+		quota, err := validateQuotaConfig(req.Quota, m.config.QuotaTimezone)
+		if err != nil {
+			m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_QUOTA", nil)
+			return
+		}
+		if !equalQuotaConfig(quota, apiKey.Quota) {
+			apiKey.Quota = quota
+			changes = append(changes, "quota")
+			updated = true
+		}
+	}
+
 	if req.Expiration != nil {
 		expirationDuration, err := parseExpiration(*req.Expiration)
 		if err != nil {
-			m.Warn("Invalid expiration in update request", "keyId", keyID, "expiration", *req.Expiration, "error", err)
+			m.WarnContext(c, "Invalid expiration in update request", "keyId", keyID, "expiration", *req.Expiration, "error", err)
 			m.respondWithError(c, http.StatusBadRequest, fmt.Sprintf("Invalid expiration format: %v", err), "INVALID_EXPIRATION", err)
 			return
 		}
@@ -1304,15 +2139,17 @@ func (m *APIKeyManager) updateAPIKeyHandler(c *gin.Context) {
 
 	apiKey.UpdatedAt = time.Now().UTC()
 
-	if err := m.SaveAPIKey(apiKey); err != nil {
-		m.Error("Failed to update API key in database", "keyId", keyID, "error", err)
+	if err := m.SaveAPIKey(m.traceContext(c), apiKey); err != nil {
+		m.ErrorContext(c, "Failed to update API key in database", "keyId", keyID, "error", err)
 		m.respondWithError(c, http.StatusInternalServerError, "Failed to update API key", "UPDATE_FAILED", err)
 		return
 	}
 
 	m.cache.SetAPIKey(apiKey)
 
-	m.logMessage("INFO", "API Key updated", map[string]interface{}{
+	m.recordKeyAudit(c, AuditActionKeyUpdate, apiKey.ID, auditKeyChanges(&before, apiKey))
+
+	m.logMessage(c, "INFO", "API Key updated", map[string]interface{}{
 		"component": "apikey",
 		"keyId":     maskAPIKey(apiKey.ID),
 		"name":      apiKey.Name,
@@ -1320,11 +2157,12 @@ func (m *APIKeyManager) updateAPIKeyHandler(c *gin.Context) {
 		"userId":    c.GetString("userID"),
 	})
 
-	m.broadcastEvent(WSMessage{
+	m.broadcastEventContext(c.Request.Context(), WSMessage{
 		Type:      "key_updated",
 		Data:      m.toAPIKeyResponse(apiKey),
 		Timestamp: time.Now().UTC(),
 		ID:        generateRequestID(),
+		Topic:     TopicKeys + ":" + apiKey.ID,
 	})
 
 	m.respondWithSuccess(c, m.toAPIKeyResponse(apiKey), fmt.Sprintf("API key updated successfully (%s)", strings.Join(changes, ", ")))
@@ -1337,38 +2175,43 @@ func (m *APIKeyManager) deleteAPIKeyHandler(c *gin.Context) {
 		return
 	}
 
-	_, exists := m.cache.GetAPIKey(keyID)
+	apiKey, exists := m.cache.GetAPIKey(keyID)
 	if !exists {
 		m.respondWithError(c, http.StatusNotFound, "API key not found", "KEY_NOT_FOUND", nil)
 		return
 	}
 
-	err := m.withRetry(func() error {
-		ctx, cancel := context.WithTimeout(m.ctx, 15*time.Second)
+	err := m.withRetryContext(m.traceContext(c), "deleteAPIKey", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 		defer cancel()
 		_, err := m.apiKeysCollection.DeleteOne(ctx, bson.M{"_id": keyID})
 		return err
 	})
 
 	if err != nil {
-		m.Error("Failed to delete API key", "keyId", keyID, "error", err)
+		m.ErrorContext(c, "Failed to delete API key", "keyId", keyID, "error", err)
 		m.respondWithError(c, http.StatusInternalServerError, "Failed to delete API key", "DELETE_FAILED", err)
 		return
 	}
 
 	m.cache.DeleteAPIKey(keyID)
+	m.limiter.Forget(keyID)
+	m.quotas.Forget(keyID)
+
+	m.recordKeyAudit(c, AuditActionKeyDelete, keyID, auditKeyChanges(apiKey, nil))
 
-	m.logMessage("INFO", "API Key deleted", map[string]interface{}{
+	m.logMessage(c, "INFO", "API Key deleted", map[string]interface{}{
 		"component": "apikey",
 		"keyId":     maskAPIKey(keyID),
 		"userId":    c.GetString("userID"),
 	})
 
-	m.broadcastEvent(WSMessage{
+	m.broadcastEventContext(c.Request.Context(), WSMessage{
 		Type:      "key_deleted",
 		Data:      gin.H{"id": keyID},
 		Timestamp: time.Now().UTC(),
 		ID:        generateRequestID(),
+		Topic:     TopicKeys + ":" + keyID,
 	})
 
 	c.JSON(http.StatusOK, gin.H{
@@ -1381,9 +2224,10 @@ func (m *APIKeyManager) deleteAPIKeyHandler(c *gin.Context) {
 func (m *APIKeyManager) cleanExpiredKeysHandler(c *gin.Context) {
 	now := time.Now().UTC()
 	var deletedCount int64
+	var deletedKeys []string
 
-	err := m.withRetry(func() error {
-		ctx, cancel := context.WithTimeout(m.ctx, 60*time.Second)
+	err := m.withRetryContext(m.traceContext(c), "cleanExpiredKeys", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 		defer cancel()
 
 		filter := bson.M{"expiration": bson.M{"$lt": now}}
@@ -1414,21 +2258,34 @@ func (m *APIKeyManager) cleanExpiredKeysHandler(c *gin.Context) {
 			return err
 		}
 		deletedCount = res.DeletedCount
+		deletedKeys = expiredKeys
 
 		for _, keyID := range expiredKeys {
 			m.cache.DeleteAPIKey(keyID)
+			m.limiter.Forget(keyID)
+			m.quotas.Forget(keyID)
 		}
 
 		return nil
 	})
 
 	if err != nil {
-		m.Error("Failed to clean expired keys", "error", err)
+		m.ErrorContext(c, "Failed to clean expired keys", "error", err)
 		m.respondWithError(c, http.StatusInternalServerError, "Failed to clean expired keys", "CLEANUP_FAILED", err)
 		return
 	}
 
-	m.logMessage("INFO", "Cleaned expired API keys", map[string]interface{}{
+	if deletedCount > 0 {
+		maskedKeys := make([]string, 0, len(deletedKeys))
+		for _, keyID := range deletedKeys {
+			maskedKeys = append(maskedKeys, maskAPIKey(keyID))
+		}
+		m.recordAudit(c, AuditActionKeysClean, "apiKey", "", []AuditFieldChange{
+			{Field: "deletedKeys", After: auditValue("deletedKeys", maskedKeys)},
+		})
+	}
+
+	m.logMessage(c, "INFO", "Cleaned expired API keys", map[string]interface{}{
 		"component": "cleanup",
 		"count":     deletedCount,
 		"userId":    c.GetString("userID"),
@@ -1443,7 +2300,7 @@ func (m *APIKeyManager) cleanExpiredKeysHandler(c *gin.Context) {
 }
 
 func (m *APIKeyManager) getLogsHandler(c *gin.Context) {
-	m.Debug("Logs request", "ip", c.ClientIP())
+	m.DebugContext(c, "Logs request", "ip", c.ClientIP())
 
 	if !m.isMongoConnected() {
 		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", nil)
@@ -1452,9 +2309,6 @@ func (m *APIKeyManager) getLogsHandler(c *gin.Context) {
 
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
-	level := c.Query("level")
-	component := c.Query("component")
-	search := c.Query("search")
 
 	if page < 1 {
 		page = 1
@@ -1463,40 +2317,57 @@ func (m *APIKeyManager) getLogsHandler(c *gin.Context) {
 		limit = 100
 	}
 
-	filter := bson.M{}
-	if level != "" && level != "all" {
-		filter["level"] = level
-	}
-	if component != "" && component != "all" {
-		filter["component"] = component
-	}
-	if search != "" {
-		filter["$or"] = []bson.M{
-			{"message": bson.M{"$regex": search, "$options": "i"}},
-			{"component": bson.M{"$regex": search, "$options": "i"}},
-		}
+	filter, err := parseLogFilter(c)
+	if err != nil {
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_FILTER", err)
+		return
 	}
 
-	ctx, cancel := context.WithTimeout(m.ctx, 15*time.Second)
-	defer cancel()
-
-	totalCount, err := m.logsCollection.CountDocuments(ctx, filter)
+	sortDir, err := parseLogSort(c)
 	if err != nil {
-		m.Error("Error counting logs", "error", err)
-		m.respondWithError(c, http.StatusInternalServerError, "Failed to count logs", "COUNT_FAILED", err)
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_SORT", err)
 		return
 	}
 
-	totalPages := int((totalCount + int64(limit) - 1) / int64(limit))
+	ctx, cancel := context.WithTimeout(m.ctx, 15*time.Second)
+	defer cancel()
 
 	opts := options.Find().
-		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
-		SetSkip(int64((page - 1) * limit)).
-		SetLimit(int64(limit))
+		SetSort(bson.D{{Key: "timestamp", Value: sortDir}, {Key: "_id", Value: sortDir}})
+
+	cursorValue, cursorMode := c.GetQuery("cursor")
+	pagination := &PaginationInfo{
+		Page:  page,
+		Limit: limit,
+	}
+
+	if cursorMode {
+		if cursorValue != "" {
+			after, err := decodeLogCursor(cursorValue)
+			if err != nil {
+				m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_CURSOR", err)
+				return
+			}
+			filter = applyLogCursor(filter, after, sortDir)
+		}
+		opts.SetLimit(int64(limit + 1))
+		pagination.Page = 0
+		pagination.Total = -1
+	} else {
+		totalCount, err := m.logsCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			m.ErrorContext(c, "Error counting logs", "error", err)
+			m.respondWithError(c, http.StatusInternalServerError, "Failed to count logs", "COUNT_FAILED", err)
+			return
+		}
+		pagination.Total = totalCount
+		pagination.TotalPages = int((totalCount + int64(limit) - 1) / int64(limit))
+		opts.SetSkip(int64((page - 1) * limit)).SetLimit(int64(limit))
+	}
 
 	cursor, err := m.logsCollection.Find(ctx, filter, opts)
 	if err != nil {
-		m.Error("Error finding logs", "error", err)
+		m.ErrorContext(c, "Error finding logs", "error", err)
 		m.respondWithError(c, http.StatusInternalServerError, "Failed to retrieve logs", "RETRIEVAL_FAILED", err)
 		return
 	}
@@ -1504,7 +2375,7 @@ func (m *APIKeyManager) getLogsHandler(c *gin.Context) {
 
 	var logs []LogEntry
 	if err := cursor.All(ctx, &logs); err != nil {
-		m.Error("Error decoding logs", "error", err)
+		m.ErrorContext(c, "Error decoding logs", "error", err)
 		m.respondWithError(c, http.StatusInternalServerError, "Failed to decode logs", "DECODE_FAILED", err)
 		return
 	}
@@ -1513,11 +2384,14 @@ func (m *APIKeyManager) getLogsHandler(c *gin.Context) {
 		logs = []LogEntry{}
 	}
 
-	pagination := &PaginationInfo{
-		Page:       page,
-		Limit:      limit,
-		Total:      totalCount,
-		TotalPages: totalPages,
+	if cursorMode {
+		if len(logs) > limit {
+			logs = logs[:limit]
+			pagination.HasMore = true
+			pagination.NextCursor = encodeLogCursor(logs[len(logs)-1])
+		}
+	} else {
+		pagination.HasMore = page < pagination.TotalPages
 	}
 
 	c.JSON(http.StatusOK, ApiResponse{
@@ -1529,105 +2403,171 @@ func (m *APIKeyManager) getLogsHandler(c *gin.Context) {
 }
 
 func (m *APIKeyManager) wsHandler(c *gin.Context) {
-	m.Info("WebSocket connection attempt", "ip", c.ClientIP())
+	m.InfoContext(c, "WebSocket connection attempt", "ip", c.ClientIP())
 
-	token := c.Query("token")
-	if token == "" {
-		m.Warn("Missing token in WebSocket query", "ip", c.ClientIP())
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token required for WebSocket connection"})
-		return
-	}
-
-	claims := jwt.MapClaims{}
-	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	var claims jwt.MapClaims
+	if token := websocketProtocolToken(c.Request); token != "" {
+		var err error
+		claims, err = m.parseSessionToken(token)
+		if err != nil {
+			m.WarnContext(c, "Invalid WebSocket token", "ip", c.ClientIP(), "error", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
 		}
-		return []byte(m.config.JWTSecret), nil
-	})
-
-	if err != nil || !parsedToken.Valid {
-		m.Warn("Invalid WebSocket token", "ip", c.ClientIP(), "error", err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-		return
 	}
 
 	conn, err := m.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		m.Error("WebSocket upgrade failed", "ip", c.ClientIP(), "error", err)
+		m.ErrorContext(c, "WebSocket upgrade failed", "ip", c.ClientIP(), "error", err)
 		return
 	}
 
-	clientID := generateRequestID()
-	wsClient := &WSClient{
-		conn:     conn,
-		clientID: clientID,
-		lastPing: time.Now().UTC(),
-	}
-
-	m.wsClients.Store(clientID, wsClient)
-	m.Info("WebSocket client connected", "clientId", clientID, "ip", c.ClientIP())
+	ip := c.ClientIP()
+	userAgent := c.Request.UserAgent()
+	go func() {
+		if claims == nil {
+			var err error
+			claims, err = m.readWebSocketAuth(conn)
+			if err != nil {
+				m.Warn("WebSocket authentication failed", "ip", ip, "error", err)
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(CloseAuthFailed, "authentication failed"), time.Now().Add(time.Second))
+				conn.Close()
+				return
+			}
+		}
 
-	go m.handleWebSocketClient(clientID, wsClient)
+		clientID := generateRequestID()
+		wsClient := NewWSClient(conn, clientID, m.config)
+		wsClient.ip = ip
+		wsClient.userAgent = userAgent
+		m.authorizeClient(wsClient, claims)
+
+		m.registerClient(wsClient)
+		m.Info("WebSocket client connected", "clientId", clientID, "ip", ip, "userId", wsClient.userID)
+
+		go m.writeWebSocketClient(wsClient)
+		wsClient.Send(WSMessage{
+			Type: "authenticated",
+			Data: map[string]interface{}{
+				"clientId":  clientID,
+				"expiresAt": wsClient.expiresAt,
+			},
+			Timestamp: time.Now().UTC(),
+			ID:        generateRequestID(),
+		})
+		m.handleWebSocketClient(clientID, wsClient)
+	}()
 }
 
 func (m *APIKeyManager) handleWebSocketClient(clientID string, wsClient *WSClient) {
 	defer func() {
-		m.wsClients.Delete(clientID)
+		m.unregisterClient(wsClient)
 		wsClient.Close()
-		m.Info("WebSocket client disconnected", "clientId", clientID)
+		stats := wsClient.Stats()
+		m.Info("WebSocket client disconnected", "clientId", clientID, "sent", stats.Sent, "dropped", stats.Dropped)
 	}()
 
 	wsClient.conn.SetReadDeadline(time.Now().UTC().Add(60 * time.Second))
 	wsClient.conn.SetPongHandler(func(string) error {
 		wsClient.conn.SetReadDeadline(time.Now().UTC().Add(60 * time.Second))
-		wsClient.lastPing = time.Now().UTC()
+		wsClient.lastPing.Store(time.Now().UnixNano())
 		return nil
 	})
 
+	for {
+		_, message, err := wsClient.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNormalClosure) {
+				m.Warn("WebSocket unexpected close", "clientId", clientID, "error", err)
+			}
+			return
+		}
+
+		var wsMsg WSClientMessage
+		if err := json.Unmarshal(message, &wsMsg); err == nil {
+			switch wsMsg.Type {
+			case "ping":
+				wsClient.Send(WSMessage{
+					Type:      "pong",
+					Timestamp: time.Now().UTC(),
+				})
+			case "subscribe":
+				m.handleSubscribe(wsClient, wsMsg)
+			case "unsubscribe":
+				m.handleUnsubscribe(wsClient, wsMsg)
+			}
+		}
+	}
+}
+
+func (m *APIKeyManager) writeWebSocketClient(wsClient *WSClient) {
 	pingTicker := time.NewTicker(30 * time.Second)
 	defer pingTicker.Stop()
 
+	replayed := make(map[string]uint64)
 	for {
 		select {
-		case <-m.ctx.Done():
-			return
+		case message := <-wsClient.send:
+			for _, out := range m.outgoing(wsClient, message, replayed) {
+				wsClient.conn.SetWriteDeadline(time.Now().Add(wsClient.writeTimeout))
+				if err := wsClient.conn.WriteJSON(out); err != nil {
+					m.Warn("Failed to send event to client", "clientId", wsClient.clientID, "error", err)
+					wsClient.CloseWith(websocket.CloseAbnormalClosure, "")
+					return
+				}
+				atomic.AddInt64(&wsClient.sent, 1)
+			}
 		case <-pingTicker.C:
+			wsClient.conn.SetWriteDeadline(time.Now().Add(wsClient.writeTimeout))
 			if err := wsClient.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				m.Warn("Failed to send ping", "clientId", clientID, "error", err)
-				return
-			}
-		default:
-			_, message, err := wsClient.conn.ReadMessage()
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					m.Warn("WebSocket unexpected close", "clientId", clientID, "error", err)
-				}
+				m.Warn("Failed to send ping", "clientId", wsClient.clientID, "error", err)
+				wsClient.CloseWith(websocket.CloseAbnormalClosure, "")
 				return
 			}
-
-			var wsMsg map[string]interface{}
-			if err := json.Unmarshal(message, &wsMsg); err == nil {
-				if msgType, ok := wsMsg["type"].(string); ok && msgType == "ping" {
-					response := map[string]interface{}{
-						"type":      "pong",
-						"timestamp": time.Now().UTC(),
-					}
-					if data, err := json.Marshal(response); err == nil {
-						wsClient.conn.WriteMessage(websocket.TextMessage, data)
-					}
-				}
-			}
+		case <-wsClient.done:
+			return
 		}
 	}
 }
 
+func (m *APIKeyManager) listWebSocketClientsHandler(c *gin.Context) {
+	m.respondWithSuccess(c, m.activeClients(), "")
+}
+
 func (m *APIKeyManager) broadcastEvent(event WSMessage) {
+	m.broadcastEventContext(m.ctx, event)
+}
+
+func (m *APIKeyManager) broadcastEventContext(ctx context.Context, event WSMessage) {
+	if event.Topic == "" {
+		event.Topic = TopicSystem
+	}
+
+	span := trace.SpanFromContext(context.Background())
+	if tracedEvent(event) {
+		_, span = m.tracing.tracer.Start(ctx, "broadcast "+event.Type,
+			trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(
+				attribute.String("event.type", event.Type),
+				attribute.String("event.topic", traceTopic(event.Topic)),
+			),
+		)
+		event.span = span.SpanContext()
+	}
+	defer span.End()
+
+	m.eventMu.Lock()
+	m.eventHistory.Append(&event)
 	select {
 	case m.eventChan <- event:
 	default:
+		m.metrics.eventsDropped.WithLabelValues("queue_full").Inc()
+		span.SetStatus(codes.Error, "event channel full")
 		m.Warn("Event channel full, dropping event", "type", event.Type)
 	}
+	m.eventMu.Unlock()
+
+	m.webhooks.Dispatch(event)
 }
 
 func (m *APIKeyManager) eventBroadcaster() {
@@ -1636,28 +2576,34 @@ func (m *APIKeyManager) eventBroadcaster() {
 		for {
 			select {
 			case event := <-m.eventChan:
+				span := m.deliverySpan(event)
 				clientCount := 0
-				toDelete := make([]string, 0)
+				dropped := 0
 
 				m.wsClients.Range(func(key, value interface{}) bool {
-					if wsClient, ok := value.(*WSClient); ok {
-						if err := wsClient.Send(event); err != nil {
-							m.Warn("Failed to send event to client", "clientId", key, "error", err)
-							toDelete = append(toDelete, key.(string))
-						} else {
-							clientCount++
-						}
+					wsClient, ok := value.(*WSClient)
+					if !ok || !wsClient.wants(event) {
+						return true
+					}
+
+					err := wsClient.Deliver(event)
+					switch {
+					case err == nil:
+						clientCount++
+					case errors.Is(err, errSlowConsumer) && wsClient.policy == WSPolicyDrop:
+						m.metrics.eventsDropped.WithLabelValues("slow_consumer").Inc()
+						dropped++
+						m.Debug("Dropped event for slow client", "clientId", key, "type", event.Type)
+					default:
+						m.Warn("Disconnecting WebSocket client", "clientId", key, "error", err)
+						m.unregisterClient(wsClient)
+						wsClient.CloseWith(websocket.ClosePolicyViolation, "slow consumer")
 					}
 					return true
 				})
 
-				for _, clientID := range toDelete {
-					if value, ok := m.wsClients.LoadAndDelete(clientID); ok {
-						if wsClient, ok := value.(*WSClient); ok {
-							wsClient.Close()
-						}
-					}
-				}
+				span.SetAttributes(attribute.Int("clients", clientCount), attribute.Int("dropped", dropped))
+				span.End()
 
 				if clientCount > 0 {
 					m.Debug("Broadcasted event", "type", event.Type, "clients", clientCount)
@@ -1670,13 +2616,12 @@ func (m *APIKeyManager) eventBroadcaster() {
 	}()
 }
 
-func (m *APIKeyManager) logMessage(level, message string, metadata map[string]interface{}) {
-	m.Info(fmt.Sprintf("[%s] %s", level, message))
-
-	if !m.isMongoConnected() {
-		return
+// logMessage writes a log line and persists it to the logs collection. ctx
+// supplies the request and user correlation for both.
+func (m *APIKeyManager) logMessage(ctx context.Context, level, message string, metadata map[string]interface{}) {
+	if metadata == nil {
+		metadata = make(map[string]interface{})
 	}
-
 	component := "system"
 	if comp, ok := metadata["component"]; ok {
 		component = fmt.Sprintf("%v", comp)
@@ -1684,6 +2629,7 @@ func (m *APIKeyManager) logMessage(level, message string, metadata map[string]in
 	}
 
 	logEntry := LogEntry{
+		ID:        primitive.NewObjectID(),
 		Level:     level,
 		Message:   message,
 		Component: component,
@@ -1694,24 +2640,30 @@ func (m *APIKeyManager) logMessage(level, message string, metadata map[string]in
 	if userID, ok := metadata["userId"]; ok {
 		logEntry.UserID = fmt.Sprintf("%v", userID)
 		delete(metadata, "userId")
+	} else {
+		logEntry.UserID = logCorrelationValue(ctx, "userID")
 	}
-
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		if _, err := m.logsCollection.InsertOne(ctx, logEntry); err != nil {
-			m.Error("Failed to insert log entry", "error", err)
-			return
+	if _, ok := metadata["requestId"]; !ok {
+		if requestID := logCorrelationValue(ctx, "requestID"); requestID != "" {
+			metadata["requestId"] = requestID
 		}
+	}
 
-		m.broadcastEvent(WSMessage{
-			Type:      "log_entry",
-			Data:      logEntry,
-			Timestamp: time.Now().UTC(),
-			ID:        generateRequestID(),
-		})
-	}()
+	attrs := []interface{}{"component", component}
+	if logEntry.UserID != "" {
+		attrs = append(attrs, "userId", logEntry.UserID)
+	}
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		attrs = append(attrs, key, metadata[key])
+	}
+	m.logger.Log(ctx, logLevelFromString(level), message, attrs...)
+
+	m.logPipeline.Enqueue(logEntry)
 }
 
 func (m *APIKeyManager) staticFileHandler() gin.HandlerFunc {
@@ -1792,22 +2744,44 @@ func (m *APIKeyManager) staticFileHandler() gin.HandlerFunc {
 	}
 }
 
+func (m *APIKeyManager) closeRealtimeClients() {
+	m.wsClients.Range(func(key, value interface{}) bool {
+		if wsClient, ok := value.(*WSClient); ok {
+			wsClient.CloseWith(websocket.CloseGoingAway, "Server shutting down")
+		}
+		m.wsClients.Delete(key)
+		return true
+	})
+}
+
 func (m *APIKeyManager) shutdown() {
 	m.shutdownOnce.Do(func() {
 		m.Info("Starting graceful shutdown...")
 
+		m.startDraining()
+
 		m.cancel()
+		m.closeRealtimeClients()
 
-		m.wsClients.Range(func(key, value interface{}) bool {
-			if wsClient, ok := value.(*WSClient); ok {
-				wsClient.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "Server shutting down"))
-				wsClient.Close()
-			}
-			m.wsClients.Delete(key)
-			return true
-		})
+		if err := m.flushQuotaUsage(); err != nil {
+			m.Warn("Failed to flush quota usage on shutdown", "error", err)
+		}
 
-		close(m.eventChan)
+		if err := m.flushLimitUsage(); err != nil {
+			m.Warn("Failed to flush limit usage on shutdown", "error", err)
+		}
+
+		if err := m.flushUsage(); err != nil {
+			m.Warn("Failed to flush usage on shutdown", "error", err)
+		}
+
+		m.waitAuditWriter(10 * time.Second)
+
+		m.logPipeline.Close(10 * time.Second)
+
+		if err := m.tracing.Shutdown(5 * time.Second); err != nil {
+			m.Warn("Failed to flush traces on shutdown", "error", err)
+		}
 
 		if m.mongoClient != nil {
 			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
@@ -1817,15 +2791,23 @@ func (m *APIKeyManager) shutdown() {
 			}
 		}
 
+		m.Info("Shutdown complete")
+
+		for _, sink := range m.logSinks {
+			sink.Close(5 * time.Second)
+		}
+
 		if m.fileLogger != nil {
 			m.fileLogger.Close()
 		}
-
-		m.Info("Shutdown complete")
 	})
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		os.Exit(runReportCommand(os.Args[2:]))
+	}
+
 	log.Printf("Starting API Key Manager Server v2.0...")
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -1844,6 +2826,8 @@ func main() {
 		log.Fatalf("Error creating API manager: %v", err)
 	}
 
+	slog.SetDefault(manager.logger)
+
 	log.Printf("Configuration loaded: Port=%s, DB=%s", config.ServerPort, config.DatabaseName)
 
 	if err := manager.connectMongo(); err != nil {
@@ -1855,7 +2839,30 @@ func main() {
 		log.Printf("Failed to load API keys to cache: %v", err)
 	}
 
+	if err := manager.loadQuotaUsage(); err != nil {
+		log.Printf("Failed to restore quota usage: %v", err)
+	}
+
+	if err := manager.loadLimitUsage(); err != nil {
+		log.Printf("Failed to restore limit usage: %v", err)
+	}
+
+	if err := manager.loadWebhooks(); err != nil {
+		log.Printf("Failed to load webhooks: %v", err)
+	}
+
 	manager.eventBroadcaster()
+	manager.webhooks.Start()
+	manager.notifier.Start()
+	manager.limiterJanitor()
+	manager.quotaFlusher()
+	manager.usageFlusher()
+	manager.logRetentionJob()
+	manager.tokenRevocationJob()
+	manager.auditWriter()
+	manager.auditSpoolReplayer()
+	manager.storeRecoveryJob()
+	manager.logFileReopener()
 
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
 		v.RegisterValidation("alphanum", func(fl validator.FieldLevel) bool {
@@ -1864,28 +2871,69 @@ func main() {
 	}
 
 	router := gin.New()
+	router.Use(otelgin.Middleware(manager.tracing.service, otelgin.WithGinFilter(func(c *gin.Context) bool {
+		switch c.FullPath() {
+		case "/metrics", "/livez", "/readyz":
+			return false
+		}
+		return true
+	})))
 	router.Use(manager.loggingMiddleware())
 	router.Use(gin.Recovery())
 	router.Use(manager.requestIDMiddleware())
 	router.Use(manager.corsMiddleware())
 	router.Use(manager.validationMiddleware())
 
+	router.GET("/metrics", manager.metricsHandler)
+	router.GET("/livez", manager.livenessHandler)
+	router.GET("/readyz", manager.readinessHandler)
+
 	serverGroup := router.Group("/server")
 	{
 		serverGroup.POST("/api/v1/auth/login", manager.loginHandler)
 		serverGroup.GET("/api/v1/health", manager.healthHandler)
 		serverGroup.GET("/api/v1/ws", manager.wsHandler)
+		serverGroup.POST("/api/v1/verify", manager.verifyAPIKeyHandler)
+		serverGroup.POST("/api/v1/verify/release", manager.releaseLeaseHandler)
+		serverGroup.GET("/api/v1/events", manager.eventsAuthMiddleware(), manager.sseHandler)
 
 		api := serverGroup.Group("/api/v1")
 		api.Use(manager.authMiddleware())
 		{
+			api.POST("/auth/logout", manager.logoutHandler)
 			api.POST("/keys", manager.createAPIKeyHandler)
 			api.GET("/keys", manager.listAPIKeysHandler)
 			api.GET("/keys/:id", manager.getAPIKeyHandler)
 			api.PUT("/keys/:id", manager.updateAPIKeyHandler)
 			api.DELETE("/keys/:id", manager.deleteAPIKeyHandler)
+			api.GET("/keys/:id/quota/history", manager.getQuotaHistoryHandler)
+			api.GET("/keys/:id/usage", manager.getKeyUsageHandler)
+			api.GET("/analytics/top-keys", manager.topKeysHandler)
+			api.GET("/billing/reports", manager.listUsageReportsHandler)
+			api.GET("/billing/reports/:period", manager.getUsageReportHandler)
+			api.POST("/billing/reports/:period/finalize", manager.finalizeUsageReportHandler)
+			api.GET("/audit", manager.getAuditLogHandler)
+			api.GET("/audit/verify", manager.verifyAuditChainHandler)
 			api.POST("/keys/clean", manager.cleanExpiredKeysHandler)
 			api.GET("/logs", manager.getLogsHandler)
+			api.GET("/logs/stats", manager.getLogStatsHandler)
+			api.GET("/logs/export", manager.exportLogsHandler)
+			api.GET("/logs/sinks", manager.listLogSinksHandler)
+			api.GET("/logs/archives", manager.listLogArchivesHandler)
+			api.GET("/logs/archives/:name", manager.downloadLogArchiveHandler)
+			api.GET("/plans", manager.listPlansHandler)
+			api.GET("/ws/clients", manager.listWebSocketClientsHandler)
+			api.GET("/sessions", manager.listSessionsHandler)
+			api.DELETE("/sessions/:id", manager.disconnectSessionHandler)
+			api.GET("/webhooks", manager.listWebhooksHandler)
+			api.POST("/webhooks", manager.createWebhookHandler)
+			api.GET("/webhooks/:id", manager.getWebhookHandler)
+			api.PUT("/webhooks/:id", manager.updateWebhookHandler)
+			api.DELETE("/webhooks/:id", manager.deleteWebhookHandler)
+			api.GET("/webhooks/:id/deliveries", manager.listWebhookDeliveriesHandler)
+			api.POST("/webhooks/:id/deliveries/:deliveryId/redeliver", manager.redeliverWebhookHandler)
+			api.GET("/notifications", manager.listNotificationsHandler)
+			api.POST("/events/token", manager.issueEventsTokenHandler)
 		}
 	}
 
@@ -1912,6 +2960,7 @@ func main() {
 		WriteTimeout: time.Duration(config.WriteTimeout) * time.Second,
 		IdleTimeout:  time.Duration(config.IdleTimeout) * time.Second,
 	}
+	server.RegisterOnShutdown(manager.closeRealtimeClients)
 
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -1928,15 +2977,21 @@ func main() {
 
 	log.Println("Shutting down server...")
 
+	manager.startDraining()
+	if config.ShutdownDrainDelay > 0 {
+		log.Printf("Draining for %ds before closing connections", config.ShutdownDrainDelay)
+		time.Sleep(time.Duration(config.ShutdownDrainDelay) * time.Second)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	manager.shutdown()
-
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	manager.shutdown()
+
 	log.Println("Server exited gracefully")
 }
 
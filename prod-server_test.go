@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+
+	if secret, generated, err := resolveSecret("configured", auditSecretPlaceholder, dir, "auditSecret"); err != nil || generated || secret != "configured" {
+		t.Fatalf("resolveSecret(configured) = %q, %v, %v, want the configured value", secret, generated, err)
+	}
+	if _, err := os.Stat(secretPath(dir, "auditSecret")); !os.IsNotExist(err) {
+		t.Fatalf("configured secret was stored (stat error = %v)", err)
+	}
+
+	first, generated, err := resolveSecret("", auditSecretPlaceholder, dir, "auditSecret")
+	if err != nil || !generated || len(first) != 64 {
+		t.Fatalf("resolveSecret(empty) = %q, %v, %v, want a generated 64-character secret", first, generated, err)
+	}
+	second, _, err := resolveSecret("", auditSecretPlaceholder, dir, "auditSecret")
+	if err != nil || second != first {
+		t.Fatalf("resolveSecret(empty) after restart = %q, %v, want the stored secret %q", second, err, first)
+	}
+	if info, err := os.Stat(secretPath(dir, "auditSecret")); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("stored secret stat = %v, %v, want mode 0600", info, err)
+	}
+
+	if _, _, err := resolveSecret(auditSecretPlaceholder, auditSecretPlaceholder, dir, "auditSecret"); err == nil {
+		t.Fatal("resolveSecret(placeholder) error = nil, want the example value rejected")
+	}
+
+	keyRef, _, err := resolveSecret("", keyRefSecretPlaceholder, dir, "keyRefSecret")
+	if err != nil || keyRef == first {
+		t.Fatalf("resolveSecret(keyRefSecret) = %q, %v, want a secret separate from auditSecret", keyRef, err)
+	}
+	if _, _, err := resolveSecret(keyRefSecretPlaceholder, keyRefSecretPlaceholder, dir, "keyRefSecret"); err == nil {
+		t.Fatal("resolveSecret(keyRefSecret placeholder) error = nil, want the example value rejected")
+	}
+}
+
+func TestNormalizeKeyName(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "trims", input: "  ci  ", want: "ci"},
+		{name: "unicode", input: "clé de prod", want: "clé de prod"},
+		{name: "empty", input: "   ", wantErr: true},
+		{name: "newline", input: "ci\nBcc: x@example.com", wantErr: true},
+		{name: "carriage return", input: "ci\rx", wantErr: true},
+		{name: "nul", input: "ci\x00", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeKeyName(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeKeyName(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("normalizeKeyName(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateAPIKeyRejectsControlCharactersInName(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := &APIKeyManager{
+		ctx:    context.Background(),
+		cache:  &Cache{},
+		config: &Config{},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	m.cache.SetAPIKey(&APIKey{ID: "key-1", Name: "ci", IsActive: true})
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Params = gin.Params{{Key: "id", Value: "key-1"}}
+	c.Request = httptest.NewRequest(http.MethodPut, "/api/keys/key-1", strings.NewReader(`{"name":"ci\r\nBcc: x@example.com"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	m.updateAPIKeyHandler(c)
+
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "INVALID_NAME") {
+		t.Fatalf("status = %d, body = %s, want 400 INVALID_NAME", rec.Code, rec.Body.String())
+	}
+	if cached, _ := m.cache.GetAPIKey("key-1"); cached.Name != "ci" {
+		t.Fatalf("cached name = %q, want it unchanged", cached.Name)
+	}
+}
+
+func newTestWebSocketPair(t *testing.T) (server, peer *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade() error = %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	server = <-conns
+	t.Cleanup(func() { server.Close() })
+	return server, peer
+}
+
+func newBroadcastTestManager(t *testing.T) *APIKeyManager {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &APIKeyManager{
+		ctx:          ctx,
+		config:       &Config{},
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		tracing:      &Tracing{tracer: noop.NewTracerProvider().Tracer(tracerName)},
+		metrics:      NewMetrics(),
+		eventChan:    make(chan WSMessage, 16),
+		eventHistory: NewEventHistory(eventHistorySize),
+	}
+}
+
+func readTestEvents(t *testing.T, peer *websocket.Conn, n int) []WSMessage {
+	t.Helper()
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	events := make([]WSMessage, 0, n)
+	for len(events) < n {
+		var event WSMessage
+		if err := peer.ReadJSON(&event); err != nil {
+			t.Fatalf("ReadJSON() after %d events error = %v", len(events), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWSClientSend(t *testing.T) {
+	wsClient := NewWSClient(nil, "c1", &Config{WSSendQueueSize: 1, WSSlowConsumerPolicy: WSPolicyDrop})
+
+	if err := wsClient.Send(WSMessage{Type: "first"}); err != nil {
+		t.Fatalf("Send() into an empty queue error = %v", err)
+	}
+	if err := wsClient.Send(WSMessage{Type: "second"}); !errors.Is(err, errSlowConsumer) {
+		t.Fatalf("Send() into a full queue error = %v, want errSlowConsumer", err)
+	}
+	if stats := wsClient.Stats(); stats.Queued != 1 || stats.Capacity != 1 || stats.Dropped != 1 {
+		t.Fatalf("Stats() = %+v, want 1 queued of 1 and 1 dropped", stats)
+	}
+
+	wsClient.Close()
+	if err := wsClient.Send(WSMessage{Type: "third"}); !errors.Is(err, errClientClosed) {
+		t.Fatalf("Send() after Close error = %v, want errClientClosed", err)
+	}
+}
+
+func TestEventBroadcasterSlowConsumer(t *testing.T) {
+	tests := []struct {
+		policy string
+	}{
+		{policy: WSPolicyDisconnect},
+		{policy: WSPolicyDrop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			m := newBroadcastTestManager(t)
+			config := &Config{WSSendQueueSize: 16, WSWriteTimeout: 5, WSSlowConsumerPolicy: tt.policy}
+
+			fastConn, fastPeer := newTestWebSocketPair(t)
+			fast := NewWSClient(fastConn, "fast", config)
+			go m.writeWebSocketClient(fast)
+			t.Cleanup(func() { fast.Close() })
+
+			// The slow client has no writer, so its one-slot queue stays full.
+			slowConn, slowPeer := newTestWebSocketPair(t)
+			slow := NewWSClient(slowConn, "slow", &Config{WSSendQueueSize: 1, WSWriteTimeout: 5, WSSlowConsumerPolicy: tt.policy})
+			slow.Send(WSMessage{Type: "backlog"})
+
+			m.wsClients.Store(fast.clientID, fast)
+			m.wsClients.Store(slow.clientID, slow)
+			m.eventBroadcaster()
+
+			for i := 0; i < 3; i++ {
+				m.broadcastEvent(WSMessage{Type: "test", Topic: TopicSystem})
+			}
+
+			// Disconnecting the slow client also broadcasts its presence leave.
+			want := 3
+			if tt.policy == WSPolicyDisconnect {
+				want = 4
+			}
+			delivered, leaves := 0, 0
+			for _, event := range readTestEvents(t, fastPeer, want) {
+				data, _ := event.Data.(map[string]interface{})
+				switch {
+				case event.Type == "test":
+					delivered++
+				case event.Type == "presence" && data["action"] == PresenceLeave && data["clientId"] == slow.clientID:
+					leaves++
+				}
+			}
+			if delivered != 3 || leaves != want-3 {
+				t.Fatalf("fast client got %d events and %d slow-client leaves, want 3 and %d", delivered, leaves, want-3)
+			}
+			waitFor(t, "the fast client's sent counter", func() bool { return fast.Stats().Sent == int64(want) })
+
+			switch tt.policy {
+			case WSPolicyDisconnect:
+				select {
+				case <-slow.done:
+				case <-time.After(5 * time.Second):
+					t.Fatal("slow client not closed")
+				}
+				if _, ok := m.wsClients.Load(slow.clientID); ok {
+					t.Fatal("slow client still registered after disconnect")
+				}
+				slowPeer.SetReadDeadline(time.Now().Add(5 * time.Second))
+				_, _, err := slowPeer.ReadMessage()
+				if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+					t.Fatalf("slow peer read error = %v, want close %d", err, websocket.ClosePolicyViolation)
+				}
+			case WSPolicyDrop:
+				waitFor(t, "the slow client's dropped counter", func() bool { return slow.Stats().Dropped == 3 })
+				if got := testutil.ToFloat64(m.metrics.eventsDropped.WithLabelValues("slow_consumer")); got != 3 {
+					t.Fatalf("eventsDropped{slow_consumer} = %v, want 3", got)
+				}
+				if _, ok := m.wsClients.Load(slow.clientID); !ok {
+					t.Fatal("slow client unregistered under the drop policy")
+				}
+				select {
+				case <-slow.done:
+					t.Fatal("slow client closed under the drop policy")
+				default:
+				}
+			}
+		})
+	}
+}
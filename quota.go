@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	QuotaPeriodHourly  = "hourly"
+	QuotaPeriodDaily   = "daily"
+	QuotaPeriodMonthly = "monthly"
+
+	LimitKeyQuota = "key_quota"
+
+	quotaFlushInterval = 10 * time.Second
+)
+
+type QuotaConfig struct {
+	Period   string `bson:"period" json:"period"`
+	Limit    int64  `bson:"limit" json:"limit"`
+	Timezone string `bson:"timezone,omitempty" json:"timezone,omitempty"`
+}
+
+type QuotaUsageInfo struct {
+	Period      string    `json:"period"`
+	Timezone    string    `json:"timezone"`
+	Limit       int64     `json:"limit"`
+	Used        int64     `json:"used"`
+	Remaining   int64     `json:"remaining"`
+	PeriodStart time.Time `json:"periodStart"`
+	ResetAt     time.Time `json:"resetAt"`
+}
+
+type QuotaPeriodUsage struct {
+	ID          string    `bson:"_id" json:"id"`
+	KeyID       string    `bson:"keyId" json:"keyId"`
+	Period      string    `bson:"period" json:"period"`
+	Timezone    string    `bson:"timezone" json:"timezone"`
+	PeriodStart time.Time `bson:"periodStart" json:"periodStart"`
+	PeriodEnd   time.Time `bson:"periodEnd" json:"periodEnd"`
+	Limit       int64     `bson:"limit" json:"limit"`
+	Count       int64     `bson:"count" json:"count"`
+	Closed      bool      `bson:"closed" json:"closed"`
+	UpdatedAt   time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+type quotaState struct {
+	usage   QuotaPeriodUsage
+	pending int64
+}
+
+type QuotaTracker struct {
+	states  map[string]*quotaState
+	closing []*quotaState
+	mu      sync.Mutex
+}
+
+func NewQuotaTracker() *QuotaTracker {
+	return &QuotaTracker{
+		states: make(map[string]*quotaState),
+	}
+}
+
+func validateQuotaConfig(quota *QuotaConfig, defaultTimezone string) (*QuotaConfig, error) {
+	if quota == nil || quota.Limit == 0 {
+		return nil, nil
+	}
+
+	normalized := *quota
+	normalized.Period = strings.ToLower(strings.TrimSpace(normalized.Period))
+	switch normalized.Period {
+	case QuotaPeriodHourly, QuotaPeriodDaily, QuotaPeriodMonthly:
+	default:
+		return nil, fmt.Errorf("invalid quota period '%s': supported periods are hourly, daily, monthly", quota.Period)
+	}
+
+	if normalized.Limit < 0 {
+		return nil, errors.New("quota limit cannot be negative")
+	}
+
+	normalized.Timezone = strings.TrimSpace(normalized.Timezone)
+	if normalized.Timezone == "" {
+		normalized.Timezone = defaultTimezone
+	}
+	if _, err := time.LoadLocation(normalized.Timezone); err != nil {
+		return nil, fmt.Errorf("invalid quota timezone '%s': %w", normalized.Timezone, err)
+	}
+
+	return &normalized, nil
+}
+
+func equalQuotaConfig(a, b *QuotaConfig) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func quotaPeriodBounds(period string, t time.Time) (time.Time, time.Time) {
+	switch period {
+	case QuotaPeriodHourly:
+		start := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+		return start, start.Add(time.Hour)
+	case QuotaPeriodDaily:
+		start := startOfDay(t)
+		return start, start.AddDate(0, 0, 1)
+	default:
+		start := startOfMonth(t)
+		return start, start.AddDate(0, 1, 0)
+	}
+}
+
+func quotaUsageID(keyID, period string, periodStart time.Time) string {
+	return fmt.Sprintf("%s:%s:%s", keyID, period, periodStart.UTC().Format(time.RFC3339))
+}
+
+func (m *APIKeyManager) quotaLocation(apiKey *APIKey) *time.Location {
+	timezone := m.config.QuotaTimezone
+	if apiKey.Quota != nil && apiKey.Quota.Timezone != "" {
+		timezone = apiKey.Quota.Timezone
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func (q *QuotaTracker) stateLocked(keyID string, quota *QuotaConfig, now time.Time) *quotaState {
+	loc, err := time.LoadLocation(quota.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	start, end := quotaPeriodBounds(quota.Period, now.In(loc))
+
+	state, ok := q.states[keyID]
+	if ok && state.usage.Period == quota.Period && state.usage.Timezone == quota.Timezone && state.usage.PeriodStart.Equal(start) {
+		state.usage.Limit = quota.Limit
+		return state
+	}
+
+	if ok {
+		state.usage.Closed = true
+		q.closing = append(q.closing, state)
+	}
+
+	state = &quotaState{
+		usage: QuotaPeriodUsage{
+			ID:          quotaUsageID(keyID, quota.Period, start),
+			KeyID:       keyID,
+			Period:      quota.Period,
+			Timezone:    quota.Timezone,
+			PeriodStart: start.UTC(),
+			PeriodEnd:   end.UTC(),
+			Limit:       quota.Limit,
+		},
+	}
+	q.states[keyID] = state
+	return state
+}
+
+func (q *QuotaTracker) Consume(apiKey *APIKey, now time.Time) (QuotaUsageInfo, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	state := q.stateLocked(apiKey.ID, apiKey.Quota, now)
+	if state.usage.Count >= state.usage.Limit {
+		return quotaUsageInfo(state), false
+	}
+
+	state.usage.Count++
+	state.pending++
+	return quotaUsageInfo(state), true
+}
+
+func (q *QuotaTracker) Refund(apiKey *APIKey, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	state := q.stateLocked(apiKey.ID, apiKey.Quota, now)
+	if state.usage.Count > 0 {
+		state.usage.Count--
+		state.pending--
+	}
+}
+
+func (q *QuotaTracker) Usage(apiKey *APIKey, now time.Time) *QuotaUsageInfo {
+	if apiKey.Quota == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	info := quotaUsageInfo(q.stateLocked(apiKey.ID, apiKey.Quota, now))
+	return &info
+}
+
+func quotaUsageInfo(state *quotaState) QuotaUsageInfo {
+	remaining := state.usage.Limit - state.usage.Count
+	if remaining < 0 {
+		remaining = 0
+	}
+	return QuotaUsageInfo{
+		Period:      state.usage.Period,
+		Timezone:    state.usage.Timezone,
+		Limit:       state.usage.Limit,
+		Used:        state.usage.Count,
+		Remaining:   remaining,
+		PeriodStart: state.usage.PeriodStart,
+		ResetAt:     state.usage.PeriodEnd,
+	}
+}
+
+func (q *QuotaTracker) Restore(usage QuotaPeriodUsage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.states[usage.KeyID] = &quotaState{usage: usage}
+}
+
+func (q *QuotaTracker) Forget(keyID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if state, ok := q.states[keyID]; ok {
+		state.usage.Closed = true
+		q.closing = append(q.closing, state)
+		delete(q.states, keyID)
+	}
+}
+
+func (q *QuotaTracker) rollover(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for keyID, state := range q.states {
+		if !now.Before(state.usage.PeriodEnd) {
+			state.usage.Closed = true
+			q.closing = append(q.closing, state)
+			delete(q.states, keyID)
+		}
+	}
+}
+
+func (q *QuotaTracker) drain() []QuotaPeriodUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var dirty []QuotaPeriodUsage
+	for _, state := range q.closing {
+		usage := state.usage
+		usage.Count = state.pending
+		dirty = append(dirty, usage)
+	}
+	q.closing = nil
+
+	for _, state := range q.states {
+		if state.pending == 0 {
+			continue
+		}
+		usage := state.usage
+		usage.Count = state.pending
+		dirty = append(dirty, usage)
+		state.pending = 0
+	}
+	return dirty
+}
+
+func (q *QuotaTracker) requeue(failed []QuotaPeriodUsage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, usage := range failed {
+		if state, ok := q.states[usage.KeyID]; ok && state.usage.ID == usage.ID {
+			state.pending += usage.Count
+			continue
+		}
+		q.closing = append(q.closing, &quotaState{usage: usage, pending: usage.Count})
+	}
+}
+
+func (m *APIKeyManager) flushQuotaUsage() error {
+	m.quotas.rollover(time.Now().UTC())
+
+	if !m.isMongoConnected() || m.usagePeriodsCollection == nil {
+		return errors.New("database connection unavailable")
+	}
+
+	dirty := m.quotas.drain()
+	if len(dirty) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	models := make([]mongo.WriteModel, 0, len(dirty))
+	for _, usage := range dirty {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": usage.ID}).
+			SetUpdate(bson.M{
+				"$inc": bson.M{"count": usage.Count},
+				"$set": bson.M{
+					"keyId":       usage.KeyID,
+					"period":      usage.Period,
+					"timezone":    usage.Timezone,
+					"periodStart": usage.PeriodStart,
+					"periodEnd":   usage.PeriodEnd,
+					"limit":       usage.Limit,
+					"closed":      usage.Closed,
+					"updatedAt":   time.Now().UTC(),
+				},
+			}).
+			SetUpsert(true))
+	}
+
+	if _, err := m.usagePeriodsCollection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		m.quotas.requeue(dirty)
+		return fmt.Errorf("failed to flush quota usage: %w", err)
+	}
+
+	return nil
+}
+
+func (m *APIKeyManager) loadQuotaUsage() error {
+	if err := m.ensureMongoConnection(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 30*time.Second)
+	defer cancel()
+
+	cursor, err := m.usagePeriodsCollection.Find(ctx, bson.M{"closed": false})
+	if err != nil {
+		return fmt.Errorf("failed to find quota usage: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	now := time.Now().UTC()
+	restored := 0
+	for cursor.Next(ctx) {
+		var usage QuotaPeriodUsage
+		if err := cursor.Decode(&usage); err != nil {
+			m.Warn("Failed to decode quota usage", "error", err)
+			continue
+		}
+		if !now.Before(usage.PeriodEnd) {
+			usage.Closed = true
+			usage.Count = 0
+			m.quotas.requeue([]QuotaPeriodUsage{usage})
+			continue
+		}
+		m.quotas.Restore(usage)
+		restored++
+	}
+
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("cursor error: %w", err)
+	}
+
+	m.Info("Restored quota usage", "count", restored)
+	return nil
+}
+
+func (m *APIKeyManager) quotaFlusher() {
+	go func() {
+		ticker := time.NewTicker(quotaFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.flushQuotaUsage(); err != nil && m.isMongoConnected() {
+					m.Warn("Quota usage flush failed", "error", err)
+				}
+				if err := m.flushLimitUsage(); err != nil && m.isMongoConnected() {
+					m.Warn("Limit usage flush failed", "error", err)
+				}
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (m *APIKeyManager) getQuotaHistoryHandler(c *gin.Context) {
+	keyID := strings.TrimSpace(c.Param("id"))
+	if keyID == "" {
+		m.respondWithError(c, http.StatusBadRequest, "Key ID is required", "MISSING_KEY_ID", nil)
+		return
+	}
+
+	if !m.isMongoConnected() {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", nil)
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "24"))
+	if limit < 1 || limit > 500 {
+		limit = 24
+	}
+
+	if err := m.flushQuotaUsage(); err != nil {
+		m.WarnContext(c, "Quota usage flush before history failed", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 15*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "periodStart", Value: -1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := m.usagePeriodsCollection.Find(ctx, bson.M{"keyId": keyID, "scope": bson.M{"$ne": limitUsageScope}}, opts)
+	if err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to retrieve quota history", "RETRIEVAL_FAILED", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var periods []QuotaPeriodUsage
+	if err := cursor.All(ctx, &periods); err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to decode quota history", "DECODE_FAILED", err)
+		return
+	}
+
+	if periods == nil {
+		periods = []QuotaPeriodUsage{}
+	}
+
+	m.respondWithSuccess(c, periods, "")
+}
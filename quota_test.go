@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateQuotaConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   *QuotaConfig
+		want    *QuotaConfig
+		wantErr string
+	}{
+		{name: "nil", quota: nil, want: nil},
+		{name: "zero limit disables", quota: &QuotaConfig{Period: "daily"}, want: nil},
+		{name: "normalizes period and default timezone", quota: &QuotaConfig{Period: " Monthly ", Limit: 100}, want: &QuotaConfig{Period: QuotaPeriodMonthly, Limit: 100, Timezone: "UTC"}},
+		{name: "keeps explicit timezone", quota: &QuotaConfig{Period: "hourly", Limit: 5, Timezone: "Europe/Berlin"}, want: &QuotaConfig{Period: QuotaPeriodHourly, Limit: 5, Timezone: "Europe/Berlin"}},
+		{name: "bad period", quota: &QuotaConfig{Period: "bogus", Limit: 1}, wantErr: "invalid quota period"},
+		{name: "negative limit", quota: &QuotaConfig{Period: "daily", Limit: -1}, wantErr: "cannot be negative"},
+		{name: "bad timezone", quota: &QuotaConfig{Period: "daily", Limit: 1, Timezone: "Mars/Olympus"}, wantErr: "invalid quota timezone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateQuotaConfig(tt.quota, "UTC")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("validateQuotaConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateQuotaConfig() unexpected error: %v", err)
+			}
+			if !equalQuotaConfig(got, tt.want) {
+				t.Fatalf("validateQuotaConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaPeriodBounds(t *testing.T) {
+	at := time.Date(2026, 2, 14, 17, 42, 0, 0, time.UTC)
+	tests := []struct {
+		period     string
+		start, end time.Time
+	}{
+		{QuotaPeriodHourly, time.Date(2026, 2, 14, 17, 0, 0, 0, time.UTC), time.Date(2026, 2, 14, 18, 0, 0, 0, time.UTC)},
+		{QuotaPeriodDaily, time.Date(2026, 2, 14, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC)},
+		{QuotaPeriodMonthly, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		start, end := quotaPeriodBounds(tt.period, at)
+		if !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("quotaPeriodBounds(%s) = %v..%v, want %v..%v", tt.period, start, end, tt.start, tt.end)
+		}
+	}
+}
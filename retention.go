@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	logRetentionInterval = time.Hour
+	logArchiveBatchSize  = 10000
+	logArchivePrefix     = "logs-"
+	logArchiveSuffix     = ".ndjson.gz"
+)
+
+type LogArchive struct {
+	Name      string    `json:"name"`
+	Level     string    `json:"level"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func parseLogRetention(config map[string]int) (map[string]time.Duration, error) {
+	retention := make(map[string]time.Duration, len(config))
+	seen := make(map[string]string, len(config))
+	for name, days := range config {
+		level, err := parseLogLevel(name)
+		if err != nil || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid log retention level '%s'", name)
+		}
+		if other, exists := seen[level.String()]; exists {
+			return nil, fmt.Errorf("duplicate log retention level '%s': conflicts with '%s'", name, other)
+		}
+		seen[level.String()] = name
+		if days < 0 {
+			return nil, fmt.Errorf("invalid log retention for '%s': days must not be negative", name)
+		}
+		if days == 0 {
+			continue
+		}
+		retention[level.String()] = time.Duration(days) * 24 * time.Hour
+	}
+	return retention, nil
+}
+
+func (m *APIKeyManager) logRetentionJob() {
+	if len(m.logRetention) == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(logRetentionInterval)
+		defer ticker.Stop()
+
+		for {
+			if m.isMongoConnected() {
+				if err := m.purgeLogs(); err != nil {
+					m.Warn("Log retention run failed", "component", "logs", "error", err)
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (m *APIKeyManager) purgeLogs() error {
+	levels := make([]string, 0, len(m.logRetention))
+	for level := range m.logRetention {
+		levels = append(levels, level)
+	}
+	sort.Strings(levels)
+
+	now := time.Now().UTC()
+	for _, level := range levels {
+		filter := bson.M{
+			"level":     level,
+			"timestamp": bson.M{"$lt": now.Add(-m.logRetention[level])},
+		}
+
+		var (
+			purged int64
+			err    error
+		)
+		if m.config.LogArchive {
+			purged, err = m.archiveLogs(level, filter)
+		} else {
+			err = m.withRetry("purgeLogs", func() error {
+				ctx, cancel := context.WithTimeout(m.ctx, 5*time.Minute)
+				defer cancel()
+
+				result, err := m.logsCollection.DeleteMany(ctx, filter)
+				if err != nil {
+					return err
+				}
+				purged = result.DeletedCount
+				return nil
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("level %s: %w", level, err)
+		}
+
+		if purged > 0 {
+			m.Info("Purged expired logs", "component", "logs", "level", level, "count", purged, "archived", m.config.LogArchive)
+		}
+	}
+	return nil
+}
+
+func (m *APIKeyManager) archiveLogs(level string, filter bson.M) (int64, error) {
+	if err := os.MkdirAll(m.config.LogArchiveDir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	var purged int64
+	for {
+		if m.ctx.Err() != nil {
+			return purged, m.ctx.Err()
+		}
+
+		entries, err := m.findArchiveBatch(filter)
+		if err != nil {
+			return purged, err
+		}
+		if len(entries) == 0 {
+			return purged, nil
+		}
+
+		if err := m.writeLogArchive(level, entries); err != nil {
+			return purged, err
+		}
+
+		ids := make([]primitive.ObjectID, len(entries))
+		for i, entry := range entries {
+			ids[i] = entry.ID
+		}
+
+		err = m.withRetry("archiveLogs", func() error {
+			ctx, cancel := context.WithTimeout(m.ctx, time.Minute)
+			defer cancel()
+
+			result, err := m.logsCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+			if err != nil {
+				return err
+			}
+			purged += result.DeletedCount
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+
+		if len(entries) < logArchiveBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+func (m *APIKeyManager) findArchiveBatch(filter bson.M) ([]LogEntry, error) {
+	var entries []LogEntry
+	err := m.withRetry("findArchiveBatch", func() error {
+		ctx, cancel := context.WithTimeout(m.ctx, time.Minute)
+		defer cancel()
+
+		opts := options.Find().
+			SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}).
+			SetLimit(logArchiveBatchSize)
+
+		cursor, err := m.logsCollection.Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		entries = nil
+		return cursor.All(ctx, &entries)
+	})
+	return entries, err
+}
+
+func (m *APIKeyManager) writeLogArchive(level string, entries []LogEntry) error {
+	first := entries[0]
+	last := entries[len(entries)-1]
+	name := fmt.Sprintf("%s%s-%s-%s-%s%s",
+		logArchivePrefix,
+		level,
+		first.Timestamp.UTC().Format("20060102T150405Z"),
+		last.Timestamp.UTC().Format("20060102T150405Z"),
+		first.ID.Hex(),
+		logArchiveSuffix,
+	)
+	path := filepath.Join(m.config.LogArchiveDir, name)
+
+	tmp, err := os.CreateTemp(m.config.LogArchiveDir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	gz := gzip.NewWriter(tmp)
+	w := bufio.NewWriter(gz)
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to encode log entry: %w", err)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync archive: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close archive: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set archive permissions: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to finalize archive: %w", err)
+	}
+	return nil
+}
+
+func isLogArchiveName(name string) bool {
+	return name == filepath.Base(name) &&
+		strings.HasPrefix(name, logArchivePrefix) &&
+		strings.HasSuffix(name, logArchiveSuffix)
+}
+
+func (m *APIKeyManager) listLogArchivesHandler(c *gin.Context) {
+	archives := []LogArchive{}
+
+	entries, err := os.ReadDir(m.config.LogArchiveDir)
+	if err != nil && !os.IsNotExist(err) {
+		m.ErrorContext(c, "Error reading log archive directory", "error", err)
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to list log archives", "ARCHIVE_LIST_FAILED", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isLogArchiveName(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		level := strings.TrimPrefix(entry.Name(), logArchivePrefix)
+		if i := strings.Index(level, "-"); i >= 0 {
+			level = level[:i]
+		}
+
+		archives = append(archives, LogArchive{
+			Name:      entry.Name(),
+			Level:     level,
+			Size:      info.Size(),
+			CreatedAt: info.ModTime().UTC(),
+		})
+	}
+
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].CreatedAt.After(archives[j].CreatedAt)
+	})
+
+	m.respondWithSuccess(c, archives, "")
+}
+
+func (m *APIKeyManager) downloadLogArchiveHandler(c *gin.Context) {
+	name := c.Param("name")
+	if !isLogArchiveName(name) {
+		m.respondWithError(c, http.StatusBadRequest, "Invalid archive name", "INVALID_ARCHIVE_NAME", nil)
+		return
+	}
+
+	path := filepath.Join(m.config.LogArchiveDir, name)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		m.respondWithError(c, http.StatusNotFound, "Archive not found", "ARCHIVE_NOT_FOUND", nil)
+		return
+	}
+
+	m.InfoContext(c, "Log archive downloaded", "archive", name)
+	c.Header("Content-Type", "application/gzip")
+	c.FileAttachment(path, name)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogRetention(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]int
+		want    map[string]time.Duration
+		wantErr string
+	}{
+		{name: "nil disables", config: nil, want: map[string]time.Duration{}},
+		{name: "normalizes levels", config: map[string]int{"debug": 7, " Warning ": 90}, want: map[string]time.Duration{"DEBUG": 7 * 24 * time.Hour, "WARN": 90 * 24 * time.Hour}},
+		{name: "zero keeps forever", config: map[string]int{"ERROR": 0}, want: map[string]time.Duration{}},
+		{name: "unknown level", config: map[string]int{"TRACE": 1}, wantErr: "invalid log retention level"},
+		{name: "blank level", config: map[string]int{" ": 1}, wantErr: "invalid log retention level"},
+		{name: "negative days", config: map[string]int{"INFO": -1}, wantErr: "must not be negative"},
+		{name: "duplicate after normalization", config: map[string]int{"debug": 1, "DEBUG": 7}, wantErr: "duplicate log retention level"},
+		{name: "warn alias duplicate", config: map[string]int{"warn": 1, "warning": 2}, wantErr: "duplicate log retention level"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLogRetention(tt.config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseLogRetention() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseLogRetention() unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseLogRetention() = %v, want %v", got, tt.want)
+			}
+			for level, d := range tt.want {
+				if got[level] != d {
+					t.Fatalf("parseLogRetention()[%s] = %v, want %v", level, got[level], d)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	LogSinkSyslog = "syslog"
+	LogSinkHTTP   = "http"
+
+	defaultSinkBufferSize    = 10000
+	defaultSinkBatchSize     = 100
+	defaultSinkFlushInterval = 2000
+	defaultSyslogFacility    = 1
+	sinkWriteTimeout         = 10 * time.Second
+	sinkHTTPAttempts         = 3
+)
+
+type LogSinkConfig struct {
+	Name          string            `json:"name"`
+	Type          string            `json:"type"`
+	Level         string            `json:"level"`
+	BufferSize    int               `json:"bufferSize"`
+	Network       string            `json:"network"`
+	Address       string            `json:"address"`
+	Facility      *int              `json:"facility"`
+	AppName       string            `json:"appName"`
+	URL           string            `json:"url"`
+	Headers       map[string]string `json:"headers"`
+	BatchSize     int               `json:"batchSize"`
+	FlushInterval int               `json:"flushInterval"`
+}
+
+type LogSinkStats struct {
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Target      string     `json:"target"`
+	Level       string     `json:"level"`
+	Queued      int        `json:"queued"`
+	Capacity    int        `json:"capacity"`
+	Sent        int64      `json:"sent"`
+	Dropped     int64      `json:"dropped"`
+	Failed      int64      `json:"failed"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+type logShipment struct {
+	level slog.Level
+	time  time.Time
+	line  []byte
+}
+
+type LogSink struct {
+	name    string
+	kind    string
+	target  string
+	level   slog.Level
+	queue   chan logShipment
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	deliver func(batch []logShipment) error
+	batch   int
+	flush   time.Duration
+	closer  func()
+
+	sent    int64
+	dropped int64
+	failed  int64
+
+	errMu     sync.Mutex
+	lastError string
+	lastErrAt time.Time
+}
+
+func newLogSinks(configs []LogSinkConfig) ([]*LogSink, error) {
+	sinks := make([]*LogSink, 0, len(configs))
+	names := make(map[string]bool, len(configs))
+
+	for i, config := range configs {
+		if config.Name == "" {
+			config.Name = fmt.Sprintf("%s-%d", config.Type, i+1)
+		}
+		if names[config.Name] {
+			return nil, fmt.Errorf("duplicate log sink name '%s'", config.Name)
+		}
+		names[config.Name] = true
+
+		sink, err := newLogSink(config)
+		if err != nil {
+			for _, started := range sinks {
+				started.Close(time.Second)
+			}
+			return nil, fmt.Errorf("log sink '%s': %w", config.Name, err)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, nil
+}
+
+func newLogSink(config LogSinkConfig) (*LogSink, error) {
+	level, err := parseLogLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	bufferSize := config.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultSinkBufferSize
+	}
+
+	sink := &LogSink{
+		name:    config.Name,
+		kind:    strings.ToLower(config.Type),
+		level:   level,
+		queue:   make(chan logShipment, bufferSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		batch:   1,
+	}
+
+	switch sink.kind {
+	case LogSinkSyslog:
+		syslog, err := newSyslogWriter(config)
+		if err != nil {
+			return nil, err
+		}
+		sink.target = syslog.network + "://" + syslog.address
+		sink.deliver = syslog.deliver
+		sink.closer = syslog.close
+	case LogSinkHTTP:
+		shipper, err := newHTTPShipper(config)
+		if err != nil {
+			return nil, err
+		}
+		sink.target = shipper.url
+		sink.deliver = shipper.deliver
+		sink.batch = config.BatchSize
+		if sink.batch <= 0 {
+			sink.batch = defaultSinkBatchSize
+		}
+		interval := config.FlushInterval
+		if interval <= 0 {
+			interval = defaultSinkFlushInterval
+		}
+		sink.flush = time.Duration(interval) * time.Millisecond
+	default:
+		return nil, fmt.Errorf("invalid type '%s': supported types are syslog, http", config.Type)
+	}
+
+	go sink.run()
+	return sink, nil
+}
+
+func (s *LogSink) enqueue(shipment logShipment) {
+	select {
+	case <-s.done:
+		atomic.AddInt64(&s.dropped, 1)
+		return
+	default:
+	}
+
+	select {
+	case s.queue <- shipment:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+	}
+}
+
+func (s *LogSink) run() {
+	defer close(s.stopped)
+	if s.closer != nil {
+		defer s.closer()
+	}
+
+	var ticker <-chan time.Time
+	if s.flush > 0 {
+		t := time.NewTicker(s.flush)
+		defer t.Stop()
+		ticker = t.C
+	}
+
+	pending := make([]logShipment, 0, s.batch)
+	send := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if err := s.deliver(pending); err != nil {
+			atomic.AddInt64(&s.failed, int64(len(pending)))
+			s.recordError(err)
+		} else {
+			atomic.AddInt64(&s.sent, int64(len(pending)))
+		}
+		pending = pending[:0]
+	}
+
+	for {
+		select {
+		case shipment := <-s.queue:
+			pending = append(pending, shipment)
+			if len(pending) >= s.batch {
+				send()
+			}
+		case <-ticker:
+			send()
+		case <-s.done:
+			for {
+				select {
+				case shipment := <-s.queue:
+					pending = append(pending, shipment)
+					if len(pending) >= s.batch {
+						send()
+					}
+				default:
+					send()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *LogSink) recordError(err error) {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	s.lastError = err.Error()
+	s.lastErrAt = time.Now().UTC()
+}
+
+func (s *LogSink) Close(timeout time.Duration) {
+	s.once.Do(func() {
+		close(s.done)
+	})
+
+	select {
+	case <-s.stopped:
+	case <-time.After(timeout):
+	}
+}
+
+func (s *LogSink) Stats() LogSinkStats {
+	stats := LogSinkStats{
+		Name:     s.name,
+		Type:     s.kind,
+		Target:   s.target,
+		Level:    s.level.String(),
+		Queued:   len(s.queue),
+		Capacity: cap(s.queue),
+		Sent:     atomic.LoadInt64(&s.sent),
+		Dropped:  atomic.LoadInt64(&s.dropped),
+		Failed:   atomic.LoadInt64(&s.failed),
+	}
+
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	if s.lastError != "" {
+		lastErrAt := s.lastErrAt
+		stats.LastError = s.lastError
+		stats.LastErrorAt = &lastErrAt
+	}
+	return stats
+}
+
+type sinkHandler struct {
+	sink *LogSink
+	ops  []func(slog.Handler) slog.Handler
+}
+
+func newSinkLogHandler(sink *LogSink) slog.Handler {
+	return &sinkHandler{sink: sink}
+}
+
+func (h *sinkHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.sink.level
+}
+
+func (h *sinkHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level < h.sink.level {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	var inner slog.Handler = slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	for _, op := range h.ops {
+		inner = op(inner)
+	}
+	if err := inner.Handle(ctx, record); err != nil {
+		return err
+	}
+
+	h.sink.enqueue(logShipment{
+		level: record.Level,
+		time:  record.Time,
+		line:  bytes.TrimRight(buf.Bytes(), "\n"),
+	})
+	return nil
+}
+
+func (h *sinkHandler) with(op func(slog.Handler) slog.Handler) slog.Handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &sinkHandler{sink: h.sink, ops: append(ops, op)}
+}
+
+func (h *sinkHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(inner slog.Handler) slog.Handler {
+		return inner.WithAttrs(attrs)
+	})
+}
+
+func (h *sinkHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(inner slog.Handler) slog.Handler {
+		return inner.WithGroup(name)
+	})
+}
+
+type syslogWriter struct {
+	network  string
+	address  string
+	facility int
+	hostname string
+	appName  string
+	procID   string
+	conn     net.Conn
+}
+
+func newSyslogWriter(config LogSinkConfig) (*syslogWriter, error) {
+	network := strings.ToLower(config.Network)
+	if network == "" {
+		network = "udp"
+	}
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("invalid network '%s': supported networks are udp, tcp", config.Network)
+	}
+	if config.Address == "" {
+		return nil, fmt.Errorf("address is required")
+	}
+	if _, _, err := net.SplitHostPort(config.Address); err != nil {
+		return nil, fmt.Errorf("invalid address '%s': %w", config.Address, err)
+	}
+
+	facility := defaultSyslogFacility
+	if config.Facility != nil {
+		facility = *config.Facility
+	}
+	if facility < 0 || facility > 23 {
+		return nil, fmt.Errorf("invalid facility %d: must be between 0 and 23", facility)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	appName := config.AppName
+	if appName == "" {
+		appName = "apikeys-manager"
+	}
+
+	return &syslogWriter{
+		network:  network,
+		address:  config.Address,
+		facility: facility,
+		hostname: syslogField(hostname, 255),
+		appName:  syslogField(appName, 48),
+		procID:   strconv.Itoa(os.Getpid()),
+	}, nil
+}
+
+func syslogField(value string, maxLen int) string {
+	var b strings.Builder
+	for _, r := range value {
+		if r > 32 && r < 127 {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "-"
+	}
+	field := b.String()
+	if len(field) > maxLen {
+		field = field[:maxLen]
+	}
+	return field
+}
+
+func syslogSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+func (w *syslogWriter) format(shipment logShipment) []byte {
+	timestamp := "-"
+	if !shipment.time.IsZero() {
+		timestamp = shipment.time.UTC().Format("2006-01-02T15:04:05.000000Z07:00")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %s - - ",
+		w.facility*8+syslogSeverity(shipment.level),
+		timestamp,
+		w.hostname,
+		w.appName,
+		w.procID,
+	)
+	buf.Write(shipment.line)
+	return buf.Bytes()
+}
+
+func (w *syslogWriter) deliver(batch []logShipment) error {
+	for _, shipment := range batch {
+		message := w.format(shipment)
+		if w.network == "tcp" {
+			message = append([]byte(strconv.Itoa(len(message))+" "), message...)
+		}
+
+		if err := w.write(message); err != nil {
+			w.close()
+			if err = w.write(message); err != nil {
+				w.close()
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *syslogWriter) write(message []byte) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.network, w.address, sinkWriteTimeout)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+
+	w.conn.SetWriteDeadline(time.Now().Add(sinkWriteTimeout))
+	_, err := w.conn.Write(message)
+	return err
+}
+
+func (w *syslogWriter) close() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+type httpShipper struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newHTTPShipper(config LogSinkConfig) (*httpShipper, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	if !strings.HasPrefix(config.URL, "http://") && !strings.HasPrefix(config.URL, "https://") {
+		return nil, fmt.Errorf("invalid url '%s': must use http or https", config.URL)
+	}
+
+	return &httpShipper{
+		url:     config.URL,
+		headers: config.Headers,
+		client:  &http.Client{Timeout: sinkWriteTimeout},
+	}, nil
+}
+
+func (s *httpShipper) deliver(batch []logShipment) error {
+	var body bytes.Buffer
+	for _, shipment := range batch {
+		body.Write(shipment.line)
+		body.WriteByte('\n')
+	}
+
+	var err error
+	for attempt := 0; attempt < sinkHTTPAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+		}
+		if err = s.post(body.Bytes()); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (s *httpShipper) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (m *APIKeyManager) listLogSinksHandler(c *gin.Context) {
+	stats := make([]LogSinkStats, 0, len(m.logSinks))
+	for _, sink := range m.logSinks {
+		stats = append(stats, sink.Stats())
+	}
+	m.respondWithSuccess(c, stats, "")
+}
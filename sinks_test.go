@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyslogFormat(t *testing.T) {
+	w := &syslogWriter{facility: 1, hostname: "host", appName: "app", procID: "42"}
+	at := time.Date(2026, 5, 6, 7, 8, 9, 123456000, time.UTC)
+
+	tests := []struct {
+		level slog.Level
+		time  time.Time
+		want  string
+	}{
+		{slog.LevelError, at, `<11>1 2026-05-06T07:08:09.123456Z host app 42 - - {"msg":"x"}`},
+		{slog.LevelWarn, at, `<12>1 2026-05-06T07:08:09.123456Z host app 42 - - {"msg":"x"}`},
+		{slog.LevelInfo, at, `<14>1 2026-05-06T07:08:09.123456Z host app 42 - - {"msg":"x"}`},
+		{slog.LevelDebug, time.Time{}, `<15>1 - host app 42 - - {"msg":"x"}`},
+	}
+	for _, tt := range tests {
+		got := string(w.format(logShipment{level: tt.level, time: tt.time, line: []byte(`{"msg":"x"}`)}))
+		if got != tt.want {
+			t.Errorf("format(%s) = %q, want %q", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestSyslogField(t *testing.T) {
+	tests := []struct {
+		value  string
+		maxLen int
+		want   string
+	}{
+		{"api keys\tmanager", 48, "apikeysmanager"},
+		{"", 48, "-"},
+		{"\x01\x02", 48, "-"},
+		{"abcdef", 4, "abcd"},
+	}
+	for _, tt := range tests {
+		if got := syslogField(tt.value, tt.maxLen); got != tt.want {
+			t.Errorf("syslogField(%q, %d) = %q, want %q", tt.value, tt.maxLen, got, tt.want)
+		}
+	}
+}
+
+func TestSyslogTCPOctetCounting(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	w, err := newSyslogWriter(LogSinkConfig{Network: "tcp", Address: listener.Addr().String(), AppName: "app"})
+	if err != nil {
+		t.Fatalf("newSyslogWriter() error = %v", err)
+	}
+	batch := []logShipment{
+		{level: slog.LevelInfo, line: []byte(`{"msg":"one"}`)},
+		{level: slog.LevelError, line: []byte(`{"msg":"two"}`)},
+	}
+	if err := w.deliver(batch); err != nil {
+		t.Fatalf("deliver() error = %v", err)
+	}
+	w.close()
+
+	var want strings.Builder
+	for _, shipment := range batch {
+		frame := w.format(shipment)
+		want.WriteString(strconv.Itoa(len(frame)) + " ")
+		want.Write(frame)
+	}
+
+	select {
+	case got := <-received:
+		if got != want.String() {
+			t.Fatalf("tcp stream = %q, want %q", got, want.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for syslog frames")
+	}
+}
+
+func TestHTTPSinkBatchesNDJSON(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("Content-Type = %q, want application/x-ndjson", ct)
+		}
+		if token := r.Header.Get("X-Token"); token != "secret" {
+			t.Errorf("X-Token = %q, want secret", token)
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	sink, err := newLogSink(LogSinkConfig{
+		Name:          "collector",
+		Type:          LogSinkHTTP,
+		URL:           server.URL,
+		Headers:       map[string]string{"X-Token": "secret"},
+		BatchSize:     2,
+		FlushInterval: 60000,
+	})
+	if err != nil {
+		t.Fatalf("newLogSink() error = %v", err)
+	}
+
+	for _, line := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		sink.enqueue(logShipment{level: slog.LevelInfo, line: []byte(line)})
+	}
+	sink.Close(5 * time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"{\"n\":1}\n{\"n\":2}\n", "{\"n\":3}\n"}
+	if len(bodies) != len(want) {
+		t.Fatalf("collector received %d batches %q, want %q", len(bodies), bodies, want)
+	}
+	for i := range want {
+		if bodies[i] != want[i] {
+			t.Fatalf("batch %d = %q, want %q", i, bodies[i], want[i])
+		}
+		scanner := bufio.NewScanner(strings.NewReader(bodies[i]))
+		for scanner.Scan() {
+			if !strings.HasPrefix(scanner.Text(), "{") {
+				t.Fatalf("batch %d line %q is not a JSON object", i, scanner.Text())
+			}
+		}
+	}
+	if stats := sink.Stats(); stats.Sent != 3 || stats.Failed != 0 {
+		t.Fatalf("Stats() = %+v, want 3 sent and 0 failed", stats)
+	}
+}
+
+func TestHTTPSinkRetriesFailedBatch(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		n := attempts
+		mu.Unlock()
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	shipper, err := newHTTPShipper(LogSinkConfig{URL: server.URL})
+	if err != nil {
+		t.Fatalf("newHTTPShipper() error = %v", err)
+	}
+	if err := shipper.deliver([]logShipment{{line: []byte(`{}`)}}); err != nil {
+		t.Fatalf("deliver() error = %v, want success after retry", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("attempts = %d, want 2", attempts)
+	}
+}
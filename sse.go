@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	TransportWebSocket = "websocket"
+	TransportSSE       = "sse"
+
+	sseRetryInterval = 5 * time.Second
+)
+
+func formatEventID(epoch string, seq uint64) string {
+	return epoch + ":" + strconv.FormatUint(seq, 10)
+}
+
+func parseEventID(id string) (string, uint64, error) {
+	id = strings.TrimSpace(id)
+	epoch := ""
+	if i := strings.LastIndexByte(id, ':'); i >= 0 {
+		epoch, id = id[:i], id[i+1:]
+	}
+	seq, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid event id '%s': expected <epoch>:<seq>", id)
+	}
+	return epoch, seq, nil
+}
+
+func writeSSEEvent(c *gin.Context, id string, event WSMessage) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	if id != "" {
+		b.WriteString("id: " + id + "\n")
+	}
+	b.WriteString("event: " + event.Type + "\n")
+	b.WriteString("data: ")
+	b.Write(data)
+	b.WriteString("\n\n")
+
+	if _, err := c.Writer.WriteString(b.String()); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+func (m *APIKeyManager) sseHandler(c *gin.Context) {
+	topics := queryList(c, "topics")
+	for _, topic := range topics {
+		if err := validateTopic(topic); err != nil {
+			m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_TOPIC", nil)
+			return
+		}
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+	var (
+		resumeFrom  *uint64
+		resumeEpoch string
+	)
+	if lastEventID != "" {
+		epoch, seq, err := parseEventID(lastEventID)
+		if err != nil {
+			m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_EVENT_ID", nil)
+			return
+		}
+		resumeFrom, resumeEpoch = &seq, epoch
+	}
+
+	clientID := generateRequestID()
+	client := NewWSClient(nil, clientID, m.config)
+	client.transport = TransportSSE
+	if claims, ok := c.Get("claims"); ok {
+		if tokenClaims, ok := claims.(jwt.MapClaims); ok {
+			m.authorizeClient(client, tokenClaims)
+		}
+	}
+	if len(topics) > 0 {
+		client.updateTopics(topics, nil)
+	}
+	client.setLogFilter(&LogTailFilter{
+		Levels:     queryList(c, "levels"),
+		Components: queryList(c, "components"),
+		Search:     c.Query("search"),
+	})
+
+	// Events broadcast between reading lastSeq and registering the client are
+	// not in its queue, so they are replayed from history after registering,
+	// exactly like a resume from lastSeq.
+	epoch := m.eventHistory.epoch
+	lastSeq := m.eventHistory.CurrentSeq()
+	replayFrom, replayEpoch := lastSeq, epoch
+	if resumeFrom != nil {
+		replayFrom, replayEpoch = *resumeFrom, resumeEpoch
+	}
+
+	client.ip = c.ClientIP()
+	client.userAgent = c.Request.UserAgent()
+
+	m.registerClient(client)
+	defer func() {
+		m.unregisterClient(client)
+		client.Close()
+		stats := client.Stats()
+		m.Info("SSE client disconnected", "clientId", clientID, "sent", stats.Sent, "dropped", stats.Dropped)
+	}()
+
+	rc := http.NewResponseController(c.Writer)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	m.InfoContext(c, "SSE client connected", "clientId", clientID, "ip", c.ClientIP(), "resumeFrom", resumeFrom)
+
+	write := func(event WSMessage) bool {
+		id := ""
+		if event.Seq > 0 {
+			id = formatEventID(epoch, event.Seq)
+			if event.Seq > lastSeq {
+				lastSeq = event.Seq
+			}
+		}
+		_ = rc.SetWriteDeadline(time.Now().Add(client.writeTimeout))
+		if err := writeSSEEvent(c, id, event); err != nil {
+			m.Debug("SSE write failed", "clientId", clientID, "error", err)
+			return false
+		}
+		atomic.AddInt64(&client.sent, 1)
+		return true
+	}
+
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", sseRetryInterval.Milliseconds())
+
+	client.subMu.RLock()
+	filter := client.logFilter
+	client.subMu.RUnlock()
+	if !write(WSMessage{
+		Type: "subscribed",
+		Data: map[string]interface{}{
+			"topics": client.Topics(),
+			"filter": filter,
+			"epoch":  epoch,
+			"seq":    lastSeq,
+		},
+		Timestamp: time.Now().UTC(),
+		ID:        generateRequestID(),
+	}) {
+		return
+	}
+
+	if !m.replaySSE(client, replayFrom, replayEpoch, write) {
+		return
+	}
+
+	heartbeat := time.NewTicker(time.Duration(m.config.SSEHeartbeatInterval) * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event := <-client.send:
+			if event.Seq > 0 && event.Seq <= lastSeq {
+				continue
+			}
+			if !write(event) {
+				return
+			}
+		case <-heartbeat.C:
+			_ = rc.SetWriteDeadline(time.Now().Add(client.writeTimeout))
+			if _, err := fmt.Fprintf(c.Writer, ": heartbeat %d\n\n", time.Now().Unix()); err != nil {
+				return
+			}
+			c.Writer.Flush()
+			client.lastPing.Store(time.Now().UnixNano())
+		case <-client.done:
+			return
+		case <-c.Request.Context().Done():
+			return
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *APIKeyManager) replaySSE(client *WSClient, resumeFrom uint64, epoch string, write func(WSMessage) bool) bool {
+	current := m.eventHistory.CurrentSeq()
+	resync := func(oldest uint64) bool {
+		return write(WSMessage{
+			Type: "resync_required",
+			Data: map[string]interface{}{
+				"resumeFrom":      resumeFrom,
+				"oldestAvailable": oldest,
+				"epoch":           m.eventHistory.epoch,
+				"seq":             current,
+			},
+			Timestamp: time.Now().UTC(),
+			ID:        generateRequestID(),
+		})
+	}
+
+	if resumeFrom > current || (epoch != "" && epoch != m.eventHistory.epoch) {
+		return resync(0)
+	}
+
+	events, oldest, ok := m.eventHistory.Since(resumeFrom, client.subscribedFamilies(), client.wants)
+	if !ok {
+		return resync(oldest)
+	}
+
+	for _, event := range events {
+		if !write(event) {
+			return false
+		}
+	}
+
+	m.Debug("Replayed SSE events", "clientId", client.clientID, "resumeFrom", resumeFrom, "count", len(events))
+	return true
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	TracingExporterNone   = "none"
+	TracingExporterOTLP   = "otlp"
+	TracingExporterStdout = "stdout"
+
+	tracerName         = "prodserver"
+	defaultServiceName = "apikey-manager"
+)
+
+type TracingConfig struct {
+	Exporter    string            `json:"exporter"`
+	Endpoint    string            `json:"endpoint"`
+	Insecure    bool              `json:"insecure"`
+	Headers     map[string]string `json:"headers"`
+	ServiceName string            `json:"serviceName"`
+	SampleRatio float64           `json:"sampleRatio"`
+}
+
+type Tracing struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	service  string
+}
+
+func NewTracing(config TracingConfig) (*Tracing, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	service := config.ServiceName
+	if service == "" {
+		service = defaultServiceName
+	}
+
+	exporterName := strings.ToLower(strings.TrimSpace(config.Exporter))
+	if exporterName == "" || exporterName == TracingExporterNone {
+		return &Tracing{tracer: noop.NewTracerProvider().Tracer(tracerName), service: service}, nil
+	}
+
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch exporterName {
+	case TracingExporterOTLP:
+		opts := []otlptracehttp.Option{}
+		if config.Endpoint != "" {
+			if strings.Contains(config.Endpoint, "://") {
+				opts = append(opts, otlptracehttp.WithEndpointURL(config.Endpoint))
+			} else {
+				opts = append(opts, otlptracehttp.WithEndpoint(config.Endpoint))
+			}
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(config.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(config.Headers))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("invalid tracing exporter '%s': supported exporters are none, otlp, stdout", config.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", exporterName, err)
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(attribute.String("service.name", service)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	ratio := config.SampleRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = 1
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return &Tracing{provider: provider, tracer: provider.Tracer(tracerName), service: service}, nil
+}
+
+func (t *Tracing) Shutdown(timeout time.Duration) error {
+	if t.provider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return t.provider.Shutdown(ctx)
+}
+
+func (m *APIKeyManager) traceContext(c *gin.Context) context.Context {
+	return withLogCorrelation(trace.ContextWithSpanContext(m.ctx, trace.SpanContextFromContext(c.Request.Context())), c)
+}
+
+func traceTopic(topic string) string {
+	if family := topicFamily(topic); family == TopicKeys && topic != family {
+		return family + ":" + maskAPIKey(topic[len(family)+1:])
+	}
+	return topic
+}
+
+func tracedEvent(event WSMessage) bool {
+	return event.Topic != TopicLogs
+}
+
+func (m *APIKeyManager) deliverySpan(event WSMessage) trace.Span {
+	if !tracedEvent(event) {
+		return trace.SpanFromContext(context.Background())
+	}
+	ctx := trace.ContextWithSpanContext(m.ctx, event.span)
+	_, span := m.tracing.tracer.Start(ctx, "deliver "+event.Type,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("event.type", event.Type),
+			attribute.String("event.topic", traceTopic(event.Topic)),
+			attribute.Int64("event.seq", int64(event.Seq)),
+		),
+	)
+	return span
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestTraceTopic(t *testing.T) {
+	tests := []struct {
+		topic string
+		want  string
+	}{
+		{TopicSystem, TopicSystem},
+		{TopicKeys, TopicKeys},
+		{"keys:sk_live_0123456789abcdef", "keys:" + maskAPIKey("sk_live_0123456789abcdef")},
+	}
+	for _, tt := range tests {
+		if got := traceTopic(tt.topic); got != tt.want {
+			t.Errorf("traceTopic(%q) = %q, want %q", tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestBroadcastSpansOmitRawKey(t *testing.T) {
+	const rawKey = "sk_live_0123456789abcdef"
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(context.Background())
+
+	m := &APIKeyManager{
+		ctx:          context.Background(),
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		tracing:      &Tracing{tracer: provider.Tracer(tracerName)},
+		metrics:      NewMetrics(),
+		eventChan:    make(chan WSMessage, 1),
+		eventHistory: NewEventHistory(4),
+	}
+	m.webhooks = NewWebhookDispatcher(m, time.Second, nil)
+
+	m.broadcastEventContext(context.Background(), WSMessage{Type: "key_deleted", Topic: TopicKeys + ":" + rawKey})
+	m.deliverySpan(<-m.eventChan).End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want broadcast and deliver", len(spans))
+	}
+	for _, span := range spans {
+		for _, attr := range span.Attributes() {
+			if strings.Contains(attr.Value.Emit(), rawKey) {
+				t.Fatalf("span %q attribute %s leaks the raw key", span.Name(), attr.Key)
+			}
+		}
+	}
+}
+
+func TestRetryStopsWhenContextCancelled(t *testing.T) {
+	m := &APIKeyManager{
+		ctx:     context.Background(),
+		config:  &Config{MaxRetries: 3, RetryDelay: int(time.Minute / time.Millisecond)},
+		tracing: &Tracing{tracer: noop.NewTracerProvider().Tracer(tracerName)},
+		metrics: NewMetrics(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- m.withRetryContext(ctx, "transient", func(context.Context) error {
+			attempts++
+			return errors.New("transient")
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("withRetryContext() error = %v, want context.Canceled", err)
+		}
+		if attempts != 1 {
+			t.Fatalf("attempts = %d, want 1", attempts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("withRetryContext() kept backing off after the caller's context was cancelled")
+	}
+}
+
+func TestRetryMakesOneAttemptWithoutRetries(t *testing.T) {
+	m := &APIKeyManager{
+		ctx:     context.Background(),
+		config:  &Config{MaxRetries: 0},
+		tracing: &Tracing{tracer: noop.NewTracerProvider().Tracer(tracerName)},
+		metrics: NewMetrics(),
+	}
+
+	attempts := 0
+	err := m.withRetry("failing", func() error {
+		attempts++
+		return errors.New("boom")
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("withRetry() = %v after %d attempts, want an error after 1", err, attempts)
+	}
+}
+
+func TestLogEventsAreNotTraced(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(context.Background())
+
+	m := &APIKeyManager{
+		ctx:          context.Background(),
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		tracing:      &Tracing{tracer: provider.Tracer(tracerName)},
+		metrics:      NewMetrics(),
+		eventChan:    make(chan WSMessage, 1),
+		eventHistory: NewEventHistory(4),
+	}
+	m.webhooks = NewWebhookDispatcher(m, time.Second, nil)
+
+	m.broadcastEventContext(context.Background(), WSMessage{Type: "log_entry", Topic: TopicLogs})
+	m.deliverySpan(<-m.eventChan).End()
+
+	if spans := recorder.Ended(); len(spans) != 0 {
+		t.Fatalf("recorded %d spans for a log event, want none", len(spans))
+	}
+}
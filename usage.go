@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	GranularityMinute = "minute"
+	GranularityHour   = "hour"
+	GranularityDay    = "day"
+
+	OutcomeAllowed       = "allowed"
+	OutcomeRateLimited   = "rateLimited"
+	OutcomeQuotaExceeded = "quotaExceeded"
+	OutcomeInvalid       = "invalid"
+
+	usageFlushInterval = 10 * time.Second
+	maxUsageBuckets    = 2000
+)
+
+var usageGranularities = []string{GranularityMinute, GranularityHour, GranularityDay}
+
+type UsageCounts struct {
+	Allowed       int64 `bson:"allowed" json:"allowed"`
+	RateLimited   int64 `bson:"rateLimited" json:"rateLimited"`
+	QuotaExceeded int64 `bson:"quotaExceeded" json:"quotaExceeded"`
+	Invalid       int64 `bson:"invalid" json:"invalid"`
+	Total         int64 `bson:"total" json:"total"`
+}
+
+// UsageAttribution is the billing attribution of a key at the time of a
+// request. Day buckets are split by it so reports bill each request to the org
+// and plan the key belonged to when it was made.
+type UsageAttribution struct {
+	OrgID string
+	Plan  string
+	Name  string
+}
+
+func (a UsageAttribution) bucketSuffix() string {
+	if a.OrgID == "" && a.Plan == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(a.OrgID + "\x00" + a.Plan))
+	return ":" + hex.EncodeToString(sum[:8])
+}
+
+type UsageBucket struct {
+	ID          string     `bson:"_id" json:"-"`
+	KeyID       string     `bson:"keyId" json:"-"`
+	Granularity string     `bson:"granularity" json:"-"`
+	Start       time.Time  `bson:"start" json:"start"`
+	ExpireAt    *time.Time `bson:"expireAt,omitempty" json:"-"`
+	OrgID       string     `bson:"orgId,omitempty" json:"-"`
+	Plan        string     `bson:"plan,omitempty" json:"-"`
+	Name        string     `bson:"name,omitempty" json:"-"`
+	UsageCounts `bson:",inline"`
+}
+
+type KeyUsageResponse struct {
+	KeyID       string        `json:"keyId"`
+	Name        string        `json:"name,omitempty"`
+	Granularity string        `json:"granularity"`
+	From        time.Time     `json:"from"`
+	To          time.Time     `json:"to"`
+	Totals      UsageCounts   `json:"totals"`
+	Buckets     []UsageBucket `json:"buckets"`
+}
+
+type TopKeyUsage struct {
+	KeyID       string `bson:"_id" json:"id"`
+	MaskedKey   string `bson:"-" json:"maskedKey"`
+	Name        string `bson:"-" json:"name,omitempty"`
+	UsageCounts `bson:",inline"`
+}
+
+type UsageRecorder struct {
+	buckets   map[string]*UsageBucket
+	dirtyKeys map[string]bool
+	mu        sync.Mutex
+}
+
+func NewUsageRecorder() *UsageRecorder {
+	return &UsageRecorder{
+		buckets:   make(map[string]*UsageBucket),
+		dirtyKeys: make(map[string]bool),
+	}
+}
+
+func truncateToGranularity(t time.Time, granularity string) time.Time {
+	t = t.UTC()
+	switch granularity {
+	case GranularityMinute:
+		return t.Truncate(time.Minute)
+	case GranularityHour:
+		return t.Truncate(time.Hour)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+func granularityStep(granularity string) time.Duration {
+	switch granularity {
+	case GranularityMinute:
+		return time.Minute
+	case GranularityHour:
+		return time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
+
+func granularityRetention(granularity string) time.Duration {
+	switch granularity {
+	case GranularityMinute:
+		return 7 * 24 * time.Hour
+	case GranularityHour:
+		return 90 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+func (c *UsageCounts) add(outcome string, n int64) {
+	switch outcome {
+	case OutcomeAllowed:
+		c.Allowed += n
+	case OutcomeRateLimited:
+		c.RateLimited += n
+	case OutcomeQuotaExceeded:
+		c.QuotaExceeded += n
+	case OutcomeInvalid:
+		c.Invalid += n
+	}
+	c.Total += n
+}
+
+func (c *UsageCounts) merge(other UsageCounts) {
+	c.Allowed += other.Allowed
+	c.RateLimited += other.RateLimited
+	c.QuotaExceeded += other.QuotaExceeded
+	c.Invalid += other.Invalid
+	c.Total += other.Total
+}
+
+func verificationOutcome(result VerifyKeyResponse) string {
+	switch {
+	case result.Allowed:
+		return OutcomeAllowed
+	case result.LimitHit == LimitKeyQuota || result.LimitHit == LimitDailyQuota || result.LimitHit == LimitMonthlyQuota:
+		return OutcomeQuotaExceeded
+	case result.LimitHit != "":
+		return OutcomeRateLimited
+	default:
+		return OutcomeInvalid
+	}
+}
+
+func (u *UsageRecorder) Record(keyID, outcome string, attribution UsageAttribution, at time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, granularity := range usageGranularities {
+		start := truncateToGranularity(at, granularity)
+		bucketID := fmt.Sprintf("%s:%s:%d", keyID, granularity, start.Unix())
+		if granularity == GranularityDay {
+			bucketID += attribution.bucketSuffix()
+		}
+		bucket, ok := u.buckets[bucketID]
+		if !ok {
+			bucket = &UsageBucket{
+				ID:          bucketID,
+				KeyID:       keyID,
+				Granularity: granularity,
+				Start:       start,
+			}
+			if granularity == GranularityDay {
+				bucket.OrgID = attribution.OrgID
+				bucket.Plan = attribution.Plan
+				bucket.Name = attribution.Name
+			}
+			if retention := granularityRetention(granularity); retention > 0 {
+				expireAt := start.Add(retention)
+				bucket.ExpireAt = &expireAt
+			}
+			u.buckets[bucketID] = bucket
+		}
+		bucket.add(outcome, 1)
+	}
+
+	if outcome == OutcomeAllowed {
+		u.dirtyKeys[keyID] = true
+	}
+}
+
+func (u *UsageRecorder) drain() ([]*UsageBucket, []string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	buckets := make([]*UsageBucket, 0, len(u.buckets))
+	for _, bucket := range u.buckets {
+		buckets = append(buckets, bucket)
+	}
+	u.buckets = make(map[string]*UsageBucket)
+
+	keys := make([]string, 0, len(u.dirtyKeys))
+	for keyID := range u.dirtyKeys {
+		keys = append(keys, keyID)
+	}
+	u.dirtyKeys = make(map[string]bool)
+
+	return buckets, keys
+}
+
+func (u *UsageRecorder) requeue(buckets []*UsageBucket, keys []string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, bucket := range buckets {
+		if existing, ok := u.buckets[bucket.ID]; ok {
+			existing.merge(bucket.UsageCounts)
+			continue
+		}
+		u.buckets[bucket.ID] = bucket
+	}
+	for _, keyID := range keys {
+		u.dirtyKeys[keyID] = true
+	}
+}
+
+func (c *Cache) RecordUsage(key string, at time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	value, exists := c.keyToAPIKey.Load(key)
+	if !exists {
+		return false
+	}
+	apiKey, ok := value.(*APIKey)
+	if !ok {
+		return false
+	}
+
+	updated := *apiKey
+	updated.UsageCount++
+	lastUsed := at
+	updated.LastUsed = &lastUsed
+	c.keyToAPIKey.Store(key, &updated)
+	return true
+}
+
+func mergeUsage(apiKey, current *APIKey) {
+	if current.UsageCount > apiKey.UsageCount {
+		apiKey.UsageCount = current.UsageCount
+	}
+	if current.LastUsed != nil && (apiKey.LastUsed == nil || current.LastUsed.After(*apiKey.LastUsed)) {
+		lastUsed := *current.LastUsed
+		apiKey.LastUsed = &lastUsed
+	}
+}
+
+func (m *APIKeyManager) recordVerification(key string, result VerifyKeyResponse, at time.Time) {
+	m.observeVerification(result)
+	if result.apiKey == nil {
+		return
+	}
+
+	outcome := verificationOutcome(result)
+	if outcome == OutcomeAllowed && !m.cache.RecordUsage(key, at) {
+		return
+	}
+
+	apiKey := result.apiKey
+	m.usage.Record(key, outcome, UsageAttribution{OrgID: apiKey.OrgID, Plan: apiKey.Plan, Name: apiKey.Name}, at)
+}
+
+func (m *APIKeyManager) flushUsage() error {
+	if !m.isMongoConnected() || m.usageBucketsCollection == nil {
+		return errors.New("database connection unavailable")
+	}
+
+	buckets, keys := m.usage.drain()
+	if len(buckets) == 0 && len(keys) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if len(buckets) > 0 {
+		models := make([]mongo.WriteModel, 0, len(buckets))
+		for _, bucket := range buckets {
+			set := bson.M{
+				"keyId":       bucket.KeyID,
+				"granularity": bucket.Granularity,
+				"start":       bucket.Start,
+			}
+			if bucket.ExpireAt != nil {
+				set["expireAt"] = *bucket.ExpireAt
+			}
+			if bucket.Granularity == GranularityDay {
+				set["orgId"] = bucket.OrgID
+				set["plan"] = bucket.Plan
+				set["name"] = bucket.Name
+			}
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": bucket.ID}).
+				SetUpdate(bson.M{
+					"$inc": bson.M{
+						"allowed":       bucket.Allowed,
+						"rateLimited":   bucket.RateLimited,
+						"quotaExceeded": bucket.QuotaExceeded,
+						"invalid":       bucket.Invalid,
+						"total":         bucket.Total,
+					},
+					"$set": set,
+				}).
+				SetUpsert(true))
+		}
+
+		if _, err := m.usageBucketsCollection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			m.usage.requeue(buckets, keys)
+			return fmt.Errorf("failed to flush usage buckets: %w", err)
+		}
+	}
+
+	if len(keys) > 0 {
+		models := make([]mongo.WriteModel, 0, len(keys))
+		for _, keyID := range keys {
+			apiKey, exists := m.cache.GetAPIKey(keyID)
+			if !exists {
+				continue
+			}
+			set := bson.M{"usageCount": apiKey.UsageCount}
+			if apiKey.LastUsed != nil {
+				set["lastUsed"] = *apiKey.LastUsed
+			}
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": keyID}).
+				SetUpdate(bson.M{"$set": set}))
+		}
+
+		if len(models) > 0 {
+			if _, err := m.apiKeysCollection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+				m.usage.requeue(nil, keys)
+				return fmt.Errorf("failed to flush key usage counters: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *APIKeyManager) usageFlusher() {
+	go func() {
+		ticker := time.NewTicker(usageFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.flushUsage(); err != nil && m.isMongoConnected() {
+					m.Warn("Usage flush failed", "error", err)
+				}
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func parseUsageRange(c *gin.Context, defaultSpan time.Duration) (time.Time, time.Time, error) {
+	to := time.Now().UTC()
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid 'to' timestamp: %w", err)
+		}
+		to = parsed.UTC()
+	}
+
+	from := to.Add(-defaultSpan)
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid 'from' timestamp: %w", err)
+		}
+		from = parsed.UTC()
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("'from' must be before 'to'")
+	}
+
+	return from, to, nil
+}
+
+func parseGranularity(value string) (string, error) {
+	switch value {
+	case "":
+		return GranularityHour, nil
+	case GranularityMinute, GranularityHour, GranularityDay:
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid granularity '%s': supported values are minute, hour, day", value)
+	}
+}
+
+func (m *APIKeyManager) getKeyUsageHandler(c *gin.Context) {
+	keyID := strings.TrimSpace(c.Param("id"))
+	if keyID == "" {
+		m.respondWithError(c, http.StatusBadRequest, "Key ID is required", "MISSING_KEY_ID", nil)
+		return
+	}
+
+	if !m.isMongoConnected() {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", nil)
+		return
+	}
+
+	granularity, err := parseGranularity(c.Query("granularity"))
+	if err != nil {
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_GRANULARITY", nil)
+		return
+	}
+
+	from, to, err := parseUsageRange(c, 24*time.Hour)
+	if err != nil {
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_RANGE", nil)
+		return
+	}
+
+	from = truncateToGranularity(from, granularity)
+	step := granularityStep(granularity)
+	if int(to.Sub(from)/step) > maxUsageBuckets {
+		m.respondWithError(c, http.StatusBadRequest, fmt.Sprintf("Requested range exceeds %d %s buckets", maxUsageBuckets, granularity), "RANGE_TOO_LARGE", nil)
+		return
+	}
+
+	if err := m.flushUsage(); err != nil {
+		m.WarnContext(c, "Usage flush before query failed", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 15*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"keyId":       keyID,
+		"granularity": granularity,
+		"start":       bson.M{"$gte": from, "$lt": to},
+	}
+
+	cursor, err := m.usageBucketsCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "start", Value: 1}}))
+	if err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to retrieve usage", "RETRIEVAL_FAILED", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var stored []UsageBucket
+	if err := cursor.All(ctx, &stored); err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to decode usage", "DECODE_FAILED", err)
+		return
+	}
+
+	// Day buckets are split by attribution, so several may share a start.
+	byStart := make(map[int64]UsageBucket, len(stored))
+	for _, bucket := range stored {
+		if existing, ok := byStart[bucket.Start.Unix()]; ok {
+			existing.merge(bucket.UsageCounts)
+			bucket = existing
+		}
+		byStart[bucket.Start.Unix()] = bucket
+	}
+
+	response := KeyUsageResponse{
+		KeyID:       maskAPIKey(keyID),
+		Granularity: granularity,
+		From:        from,
+		To:          to,
+		Buckets:     []UsageBucket{},
+	}
+	if apiKey, exists := m.cache.GetAPIKey(keyID); exists {
+		response.Name = apiKey.Name
+	}
+
+	for start := from; start.Before(to); start = start.Add(step) {
+		bucket, ok := byStart[start.Unix()]
+		if !ok {
+			bucket = UsageBucket{Start: start}
+		}
+		bucket.Start = bucket.Start.UTC()
+		response.Totals.merge(bucket.UsageCounts)
+		response.Buckets = append(response.Buckets, bucket)
+	}
+
+	m.respondWithSuccess(c, response, "")
+}
+
+func (m *APIKeyManager) topKeysHandler(c *gin.Context) {
+	if !m.isMongoConnected() {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", nil)
+		return
+	}
+
+	from, to, err := parseUsageRange(c, 24*time.Hour)
+	if err != nil {
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_RANGE", nil)
+		return
+	}
+
+	metric := c.DefaultQuery("metric", "total")
+	switch metric {
+	case "total", OutcomeAllowed, OutcomeRateLimited, OutcomeQuotaExceeded, OutcomeInvalid:
+	default:
+		m.respondWithError(c, http.StatusBadRequest, fmt.Sprintf("Invalid metric '%s'", metric), "INVALID_METRIC", nil)
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	granularity := GranularityHour
+	if to.Sub(from) > 90*24*time.Hour {
+		granularity = GranularityDay
+	}
+
+	if err := m.flushUsage(); err != nil {
+		m.WarnContext(c, "Usage flush before query failed", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 30*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"granularity": granularity,
+			"start":       bson.M{"$gte": truncateToGranularity(from, granularity), "$lt": to},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           "$keyId",
+			"allowed":       bson.M{"$sum": "$allowed"},
+			"rateLimited":   bson.M{"$sum": "$rateLimited"},
+			"quotaExceeded": bson.M{"$sum": "$quotaExceeded"},
+			"invalid":       bson.M{"$sum": "$invalid"},
+			"total":         bson.M{"$sum": "$total"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: metric, Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+
+	cursor, err := m.usageBucketsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to aggregate usage", "AGGREGATION_FAILED", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var topKeys []TopKeyUsage
+	if err := cursor.All(ctx, &topKeys); err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to decode usage", "DECODE_FAILED", err)
+		return
+	}
+
+	for i := range topKeys {
+		topKeys[i].MaskedKey = maskAPIKey(topKeys[i].KeyID)
+		if apiKey, exists := m.cache.GetAPIKey(topKeys[i].KeyID); exists {
+			topKeys[i].Name = apiKey.Name
+		}
+	}
+
+	if topKeys == nil {
+		topKeys = []TopKeyUsage{}
+	}
+
+	m.respondWithSuccess(c, gin.H{
+		"from":   from,
+		"to":     to,
+		"metric": metric,
+		"keys":   topKeys,
+	}, "")
+}
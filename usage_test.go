@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheRecordUsageSkipsDeletedKey(t *testing.T) {
+	cache := &Cache{}
+	cache.SetAPIKey(&APIKey{ID: "key-1"})
+	cache.DeleteAPIKey("key-1")
+
+	if cache.RecordUsage("key-1", time.Now().UTC()) {
+		t.Fatal("RecordUsage() = true for a deleted key")
+	}
+	if _, exists := cache.GetAPIKey("key-1"); exists {
+		t.Fatal("RecordUsage() stored a deleted key back into the cache")
+	}
+}
+
+func TestCacheSetKeepsConcurrentUsage(t *testing.T) {
+	cache := &Cache{}
+	cache.SetAPIKey(&APIKey{ID: "key-1", Name: "before"})
+
+	stale, _ := cache.GetAPIKey("key-1")
+	edited := *stale
+	edited.Name = "after"
+
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	cache.RecordUsage("key-1", at)
+	cache.RecordUsage("key-1", at)
+	cache.SetAPIKey(&edited)
+
+	got, _ := cache.GetAPIKey("key-1")
+	if got.Name != "after" {
+		t.Fatalf("Name = %q, want %q", got.Name, "after")
+	}
+	if got.UsageCount != 2 || got.LastUsed == nil || !got.LastUsed.Equal(at) {
+		t.Fatalf("usage = %d/%v, want 2/%v", got.UsageCount, got.LastUsed, at)
+	}
+}
+
+func TestUsageRecordSplitsDayBucketsByAttribution(t *testing.T) {
+	u := NewUsageRecorder()
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	u.Record("key-1", OutcomeAllowed, UsageAttribution{OrgID: "org-a", Plan: "free"}, at)
+	u.Record("key-1", OutcomeAllowed, UsageAttribution{OrgID: "org-b", Plan: "free"}, at)
+	u.Record("key-1", OutcomeInvalid, UsageAttribution{OrgID: "org-b", Plan: "free"}, at)
+
+	buckets, _ := u.drain()
+	perGranularity := make(map[string][]*UsageBucket)
+	for _, bucket := range buckets {
+		perGranularity[bucket.Granularity] = append(perGranularity[bucket.Granularity], bucket)
+	}
+
+	for _, granularity := range []string{GranularityMinute, GranularityHour} {
+		if got := perGranularity[granularity]; len(got) != 1 || got[0].Total != 3 || got[0].OrgID != "" {
+			t.Fatalf("%s buckets = %+v, want one unattributed bucket with 3 requests", granularity, got)
+		}
+	}
+
+	days := perGranularity[GranularityDay]
+	if len(days) != 2 {
+		t.Fatalf("day buckets = %d, want one per attribution", len(days))
+	}
+	for _, bucket := range days {
+		want := int64(1)
+		if bucket.OrgID == "org-b" {
+			want = 2
+		}
+		if bucket.Total != want || bucket.Plan != "free" {
+			t.Fatalf("day bucket %+v, want %d requests on plan free", bucket, want)
+		}
+	}
+}
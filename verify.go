@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxScopesPerKey = 64
+	maxScopeLength  = 128
+
+	verifyMissWindow     = time.Minute
+	verifyMissLimit      = 20
+	verifyMissMaxIPs     = 10000
+	verifyMissOverflowIP = "*"
+)
+
+type VerifyKeyRequest struct {
+	Key            string   `json:"key"`
+	Route          string   `json:"route"`
+	RequiredScopes []string `json:"requiredScopes"`
+}
+
+// VerifyKeyResponse is returned to unauthenticated callers, so it carries the
+// decision and the limits behind it but no key metadata.
+type VerifyKeyResponse struct {
+	Valid         bool            `json:"valid"`
+	Allowed       bool            `json:"allowed"`
+	Reason        string          `json:"reason,omitempty"`
+	MissingScopes []string        `json:"missingScopes"`
+	Route         string          `json:"route,omitempty"`
+	Rule          *LimitRule      `json:"rule,omitempty"`
+	LimitHit      string          `json:"limitHit,omitempty"`
+	Limit         int64           `json:"limit,omitempty"`
+	Remaining     *int64          `json:"remaining,omitempty"`
+	RetryAfter    int             `json:"retryAfter,omitempty"`
+	LeaseID       string          `json:"leaseId,omitempty"`
+	QuotaUsage    *QuotaUsageInfo `json:"quotaUsage,omitempty"`
+
+	apiKey *APIKey
+}
+
+// verifyMissTracker counts unknown-key verifications per client address in
+// fixed windows, like login failures, so the endpoint cannot be used to
+// enumerate keys.
+type verifyMissTracker struct {
+	mu      sync.Mutex
+	windows map[string]*verifyMissWindowState
+}
+
+type verifyMissWindowState struct {
+	since time.Time
+	count int
+}
+
+// retryAfter reports how long ip must wait before verifying again, or zero.
+func (v *verifyMissTracker) retryAfter(ip string, now time.Time) time.Duration {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	window, ok := v.windows[ip]
+	if !ok && len(v.windows) >= verifyMissMaxIPs {
+		window, ok = v.windows[verifyMissOverflowIP]
+	}
+	if !ok || window.count < verifyMissLimit {
+		return 0
+	}
+	return window.since.Add(verifyMissWindow).Sub(now)
+}
+
+// record counts a miss for ip and reports whether it just reached the limit.
+func (v *verifyMissTracker) record(ip string, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.windows == nil {
+		v.windows = make(map[string]*verifyMissWindowState)
+	}
+	window, ok := v.windows[ip]
+	if !ok && len(v.windows) >= verifyMissMaxIPs {
+		v.pruneLocked(now)
+		if len(v.windows) >= verifyMissMaxIPs {
+			ip = verifyMissOverflowIP
+			window, ok = v.windows[ip]
+		}
+	}
+	if ok && now.Sub(window.since) < verifyMissWindow {
+		window.count++
+		return window.count == verifyMissLimit
+	}
+	v.windows[ip] = &verifyMissWindowState{since: now, count: 1}
+	return false
+}
+
+func (v *verifyMissTracker) pruneLocked(now time.Time) {
+	for ip, window := range v.windows {
+		if now.Sub(window.since) >= verifyMissWindow {
+			delete(v.windows, ip)
+		}
+	}
+}
+
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) > maxScopesPerKey {
+		return nil, fmt.Errorf("too many scopes: maximum is %d", maxScopesPerKey)
+	}
+
+	seen := make(map[string]bool, len(scopes))
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if len(scope) > maxScopeLength {
+			return nil, fmt.Errorf("scope '%s' exceeds maximum length of %d characters", scope, maxScopeLength)
+		}
+		if strings.ContainsAny(scope, " \t\r\n") {
+			return nil, fmt.Errorf("scope '%s' must not contain whitespace", scope)
+		}
+		if seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		normalized = append(normalized, scope)
+	}
+
+	if len(normalized) == 0 {
+		return nil, nil
+	}
+	return normalized, nil
+}
+
+func equalScopes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func scopesOrEmpty(scopes []string) []string {
+	if scopes == nil {
+		return []string{}
+	}
+	return append([]string(nil), scopes...)
+}
+
+func missingScopes(granted, required []string) []string {
+	grantedSet := make(map[string]bool, len(granted))
+	for _, scope := range granted {
+		grantedSet[scope] = true
+	}
+
+	missing := []string{}
+	for _, scope := range required {
+		if !grantedSet[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+func (m *APIKeyManager) verifyAPIKey(key, route string, requiredScopes []string) (VerifyKeyResponse, int) {
+	result := VerifyKeyResponse{
+		MissingScopes: []string{},
+		Route:         route,
+	}
+
+	apiKey, exists := m.cache.GetAPIKey(key)
+	if !exists {
+		result.Reason = "KEY_NOT_FOUND"
+		return result, http.StatusUnauthorized
+	}
+
+	result.apiKey = apiKey
+
+	if !apiKey.IsActive {
+		result.Reason = "KEY_INACTIVE"
+		return result, http.StatusUnauthorized
+	}
+
+	if !apiKey.Expiration.After(time.Now().UTC()) {
+		result.Reason = "KEY_EXPIRED"
+		return result, http.StatusUnauthorized
+	}
+
+	result.Valid = true
+
+	if missing := missingScopes(apiKey.Scopes, requiredScopes); len(missing) > 0 {
+		result.MissingScopes = missing
+		result.Reason = "INSUFFICIENT_SCOPE"
+		return result, http.StatusForbidden
+	}
+
+	now := time.Now().In(m.quotaLocation(apiKey))
+
+	if apiKey.Quota != nil {
+		usage, ok := m.quotas.Consume(apiKey, now)
+		result.QuotaUsage = &usage
+		if !ok {
+			result.Reason = "LIMIT_EXCEEDED"
+			result.LimitHit = LimitKeyQuota
+			result.Limit = usage.Limit
+			result.RetryAfter = int(math.Ceil(usage.ResetAt.Sub(now).Seconds()))
+			return result, http.StatusTooManyRequests
+		}
+	}
+
+	rule := m.resolveLimitRule(apiKey, route)
+	decision := m.limiter.Check(apiKey, rule, now)
+	if rule != nil {
+		matched := *rule
+		result.Rule = &matched
+	}
+
+	if !decision.Allowed {
+		if apiKey.Quota != nil {
+			m.quotas.Refund(apiKey, now)
+			result.QuotaUsage = m.quotas.Usage(apiKey, now)
+		}
+		result.Reason = "LIMIT_EXCEEDED"
+		result.LimitHit = decision.LimitHit
+		result.Limit = decision.Limit
+		result.RetryAfter = int(decision.RetryAfter.Round(time.Second) / time.Second)
+		if result.RetryAfter < 1 {
+			result.RetryAfter = 1
+		}
+		return result, http.StatusTooManyRequests
+	}
+
+	if decision.Remaining >= 0 {
+		remaining := decision.Remaining
+		result.Remaining = &remaining
+	}
+	result.LeaseID = decision.LeaseID
+	result.Allowed = true
+	return result, http.StatusOK
+}
+
+func (m *APIKeyManager) verifyAPIKeyHandler(c *gin.Context) {
+	var req VerifyKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		m.respondWithError(c, http.StatusBadRequest, "Invalid request data", "INVALID_REQUEST", err)
+		return
+	}
+
+	req.Key = strings.TrimSpace(req.Key)
+	if req.Key == "" {
+		req.Key = strings.TrimSpace(c.GetHeader("X-API-Key"))
+	}
+	if req.Key == "" {
+		m.respondWithError(c, http.StatusBadRequest, "API key is required", "MISSING_KEY", nil)
+		return
+	}
+
+	requiredScopes, err := normalizeScopes(req.RequiredScopes)
+	if err != nil {
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_SCOPES", nil)
+		return
+	}
+
+	req.Route = strings.TrimSpace(req.Route)
+
+	ip := c.ClientIP()
+	if wait := m.verifyMisses.retryAfter(ip, time.Now()); wait > 0 {
+		result := VerifyKeyResponse{
+			MissingScopes: []string{},
+			Route:         req.Route,
+			Reason:        "TOO_MANY_UNKNOWN_KEYS",
+			RetryAfter:    int(math.Ceil(wait.Seconds())),
+		}
+		m.observeVerification(result)
+		c.Header("Retry-After", strconv.Itoa(result.RetryAfter))
+		c.JSON(http.StatusTooManyRequests, ApiResponse{
+			Data:      result,
+			Message:   result.Reason,
+			Timestamp: time.Now().UTC(),
+		})
+		return
+	}
+
+	result, status := m.verifyAPIKey(req.Key, req.Route, requiredScopes)
+	m.recordVerification(req.Key, result, time.Now().UTC())
+
+	if result.Reason == "KEY_NOT_FOUND" && m.verifyMisses.record(ip, time.Now()) {
+		m.WarnContext(c, "Throttling API key verification after repeated unknown keys", "ip", ip, "misses", verifyMissLimit, "window", verifyMissWindow)
+	}
+
+	if !result.Allowed {
+		m.DebugContext(c, "API key verification denied", "keyId", maskAPIKey(req.Key), "route", req.Route, "reason", result.Reason, "limitHit", result.LimitHit, "missingScopes", result.MissingScopes, "ip", ip)
+	}
+
+	if status == http.StatusTooManyRequests {
+		c.Header("Retry-After", strconv.Itoa(result.RetryAfter))
+	}
+
+	c.JSON(status, ApiResponse{
+		Data:      result,
+		Message:   result.Reason,
+		Success:   result.Allowed,
+		Timestamp: time.Now().UTC(),
+	})
+}
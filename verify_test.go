@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNormalizeScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		want    []string
+		wantErr string
+	}{
+		{name: "nil", scopes: nil, want: nil},
+		{name: "blank only", scopes: []string{"", "  "}, want: nil},
+		{name: "trims and dedups preserving order", scopes: []string{" keys:read", "keys:write", "keys:read"}, want: []string{"keys:read", "keys:write"}},
+		{name: "inner whitespace", scopes: []string{"keys read"}, wantErr: "must not contain whitespace"},
+		{name: "too long", scopes: []string{strings.Repeat("a", maxScopeLength+1)}, wantErr: "exceeds maximum length"},
+		{name: "too many", scopes: make([]string, maxScopesPerKey+1), wantErr: "too many scopes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeScopes(tt.scopes)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("normalizeScopes() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalizeScopes() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("normalizeScopes() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingScopes(t *testing.T) {
+	got := missingScopes([]string{"keys:read"}, []string{"keys:read", "keys:write"})
+	if !reflect.DeepEqual(got, []string{"keys:write"}) {
+		t.Fatalf("missingScopes() = %#v", got)
+	}
+}
+
+func newVerifyTestManager(t *testing.T, keys ...*APIKey) *APIKeyManager {
+	t.Helper()
+	m := &APIKeyManager{
+		config:  &Config{QuotaTimezone: "UTC"},
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		cache:   &Cache{},
+		limiter: NewKeyLimiter(time.Minute),
+		quotas:  NewQuotaTracker(),
+		usage:   NewUsageRecorder(),
+		metrics: NewMetrics(),
+	}
+	for _, key := range keys {
+		m.cache.SetAPIKey(key)
+	}
+	return m
+}
+
+func newVerifyTestKey(id string) *APIKey {
+	return &APIKey{
+		ID:         id,
+		Name:       "billing-service",
+		Expiration: time.Now().Add(time.Hour),
+		IsActive:   true,
+		Scopes:     []string{"keys:read"},
+		Plan:       "pro",
+	}
+}
+
+func TestVerifyAPIKeyInsufficientScope(t *testing.T) {
+	m := newVerifyTestManager(t, newVerifyTestKey("sk_live_scoped"))
+
+	result, status := m.verifyAPIKey("sk_live_scoped", "/v1/keys", []string{"keys:read", "keys:write"})
+	if status != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", status, http.StatusForbidden)
+	}
+	if !result.Valid || result.Allowed || result.Reason != "INSUFFICIENT_SCOPE" {
+		t.Fatalf("result = %+v, want a valid key denied for INSUFFICIENT_SCOPE", result)
+	}
+	if !reflect.DeepEqual(result.MissingScopes, []string{"keys:write"}) {
+		t.Fatalf("MissingScopes = %v, want [keys:write]", result.MissingScopes)
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	for _, leaked := range []string{"billing-service", "keys:read", `"plan"`, `"expiration"`, `"scopes"`, "sk_live"} {
+		if strings.Contains(string(encoded), leaked) {
+			t.Fatalf("verify response %s exposes key metadata %s", encoded, leaked)
+		}
+	}
+}
+
+func TestVerifyAPIKeyHandlerThrottlesUnknownKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := newVerifyTestManager(t, newVerifyTestKey("sk_live_known"))
+
+	verify := func(key, ip string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/verify", strings.NewReader(`{"key":"`+key+`"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.RemoteAddr = ip + ":1234"
+		m.verifyAPIKeyHandler(c)
+		return rec
+	}
+
+	for i := 0; i < verifyMissLimit; i++ {
+		if rec := verify("sk_live_guess", "10.0.0.1"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("unknown key attempt %d status = %d, want %d", i+1, rec.Code, http.StatusUnauthorized)
+		}
+	}
+
+	rec := verify("sk_live_known", "10.0.0.1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("status = %d, Retry-After = %q, want 429 with Retry-After once the address is throttled", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := verify("sk_live_known", "10.0.0.2"); rec.Code != http.StatusOK {
+		t.Fatalf("other address status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	if wait := m.verifyMisses.retryAfter("10.0.0.1", time.Now().Add(verifyMissWindow)); wait > 0 {
+		t.Fatalf("retryAfter() after the window = %v, want the throttle lifted", wait)
+	}
+}
+
+func TestVerifyAPIKeyRefundsQuotaWhenRouteRuleDenies(t *testing.T) {
+	key := newVerifyTestKey("sk_live_quota")
+	key.Quota = &QuotaConfig{Period: QuotaPeriodDaily, Limit: 100, Timezone: "UTC"}
+	key.Limits = []LimitRule{{Route: "/v1/search", RPM: 2}}
+	m := newVerifyTestManager(t, key)
+
+	for i := 0; i < 2; i++ {
+		if _, status := m.verifyAPIKey(key.ID, "/v1/search", nil); status != http.StatusOK {
+			t.Fatalf("verification %d status = %d, want %d", i+1, status, http.StatusOK)
+		}
+	}
+	before := m.quotas.Usage(key, time.Now().UTC())
+	if before == nil || before.Used != 2 {
+		t.Fatalf("quota usage after two allowed verifications = %+v, want 2 used", before)
+	}
+
+	result, status := m.verifyAPIKey(key.ID, "/v1/search", nil)
+	if status != http.StatusTooManyRequests || result.LimitHit != LimitRPM {
+		t.Fatalf("status = %d, limitHit = %q, want 429 from the route rule", status, result.LimitHit)
+	}
+	after := m.quotas.Usage(key, time.Now().UTC())
+	if after == nil || after.Used != before.Used || after.Remaining != before.Remaining {
+		t.Fatalf("quota usage after a route denial = %+v, want unchanged from %+v", after, before)
+	}
+	if result.QuotaUsage == nil || result.QuotaUsage.Used != before.Used {
+		t.Fatalf("reported quota usage = %+v, want the refunded usage", result.QuotaUsage)
+	}
+}
@@ -0,0 +1,1053 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	WebhookStatusPending   = "pending"
+	WebhookStatusRetrying  = "retrying"
+	WebhookStatusDelivered = "delivered"
+	WebhookStatusDead      = "dead"
+
+	webhookWorkers         = 4
+	webhookQueueSize       = 1000
+	webhookBaseBackoff     = 10 * time.Second
+	webhookMaxBackoff      = time.Hour
+	webhookDeliveryTTL     = 30 * 24 * time.Hour
+	webhookMaxResponseBody = 1024
+	webhookSecretPrefix    = "whsec_"
+
+	webhookSpoolFile           = "webhook-deliveries-pending.ndjson"
+	webhookSpoolReplayInterval = 30 * time.Second
+)
+
+var webhookEventTypes = []string{"key_created", "key_updated", "key_deleted"}
+
+type Webhook struct {
+	ID          string    `bson:"_id" json:"id"`
+	URL         string    `bson:"url" json:"url"`
+	Events      []string  `bson:"events" json:"events"`
+	Secret      string    `bson:"secret" json:"secret,omitempty"`
+	Description string    `bson:"description,omitempty" json:"description,omitempty"`
+	Active      bool      `bson:"active" json:"active"`
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+type CreateWebhookRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Secret      string   `json:"secret"`
+	Description string   `json:"description"`
+}
+
+type UpdateWebhookRequest struct {
+	URL          *string   `json:"url"`
+	Events       *[]string `json:"events"`
+	Description  *string   `json:"description"`
+	Active       *bool     `json:"active"`
+	RotateSecret bool      `json:"rotateSecret"`
+}
+
+type WebhookDelivery struct {
+	ID             string     `bson:"_id" json:"id"`
+	WebhookID      string     `bson:"webhookId" json:"webhookId"`
+	EventID        string     `bson:"eventId" json:"eventId"`
+	EventType      string     `bson:"eventType" json:"eventType"`
+	Payload        string     `bson:"payload" json:"payload"`
+	Status         string     `bson:"status" json:"status"`
+	Attempts       int        `bson:"attempts" json:"attempts"`
+	ResponseStatus int        `bson:"responseStatus,omitempty" json:"responseStatus,omitempty"`
+	ResponseBody   string     `bson:"responseBody,omitempty" json:"responseBody,omitempty"`
+	LastError      string     `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	RedeliveryOf   string     `bson:"redeliveryOf,omitempty" json:"redeliveryOf,omitempty"`
+	CreatedAt      time.Time  `bson:"createdAt" json:"createdAt"`
+	LastAttemptAt  *time.Time `bson:"lastAttemptAt,omitempty" json:"lastAttemptAt,omitempty"`
+	NextAttemptAt  *time.Time `bson:"nextAttemptAt,omitempty" json:"nextAttemptAt,omitempty"`
+	DeliveredAt    *time.Time `bson:"deliveredAt,omitempty" json:"deliveredAt,omitempty"`
+}
+
+type WebhookPayload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+type WebhookKeyData struct {
+	KeyRef       string    `json:"keyRef"`
+	MaskedKey    string    `json:"maskedKey"`
+	Name         string    `json:"name,omitempty"`
+	Expiration   time.Time `json:"expiration"`
+	RPM          int       `json:"rpm"`
+	ThreadsLimit int       `json:"threadsLimit"`
+	IsActive     bool      `json:"isActive"`
+	Scopes       []string  `json:"scopes"`
+	Plan         string    `json:"plan,omitempty"`
+	OrgID        string    `json:"orgId,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+type WebhookStats struct {
+	Webhooks     int   `json:"webhooks"`
+	Queued       int   `json:"queued"`
+	Delivered    int64 `json:"delivered"`
+	Failed       int64 `json:"failed"`
+	Dead         int64 `json:"dead"`
+	SaveFailures int64 `json:"saveFailures"`
+	Spooled      int64 `json:"spooled"`
+}
+
+type WebhookDispatcher struct {
+	m       *APIKeyManager
+	client  *http.Client
+	targets *webhookTargetPolicy
+	queue   chan *WebhookDelivery
+
+	mu    sync.RWMutex
+	hooks map[string]*Webhook
+
+	spoolMu sync.Mutex
+
+	delivered    int64
+	failed       int64
+	dead         int64
+	saveFailures int64
+	spooled      int64
+}
+
+// webhookTargetPolicy keeps webhook requests away from loopback, link-local
+// and private networks unless the host or range is explicitly allowed.
+type webhookTargetPolicy struct {
+	hosts map[string]bool
+	nets  []*net.IPNet
+}
+
+func NewWebhookDispatcher(m *APIKeyManager, timeout time.Duration, targets *webhookTargetPolicy) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		m:       m,
+		client:  targets.client(timeout),
+		targets: targets,
+		queue:   make(chan *WebhookDelivery, webhookQueueSize),
+		hooks:   make(map[string]*Webhook),
+	}
+}
+
+func newWebhookTargetPolicy(allowed []string) (*webhookTargetPolicy, error) {
+	policy := &webhookTargetPolicy{hosts: make(map[string]bool)}
+	for _, entry := range allowed {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed webhook range '%s': %w", entry, err)
+			}
+			policy.nets = append(policy.nets, network)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			policy.nets = append(policy.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		policy.hosts[entry] = true
+	}
+	return policy, nil
+}
+
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+func (p *webhookTargetPolicy) allowHost(host string) bool {
+	return p != nil && p.hosts[strings.ToLower(host)]
+}
+
+func (p *webhookTargetPolicy) allowIP(ip net.IP) bool {
+	if !isInternalIP(ip) {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	for _, network := range p.nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *webhookTargetPolicy) client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !p.allowIP(ip) {
+				return fmt.Errorf("webhook target %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+	direct := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err == nil && p.allowHost(host) {
+			return direct.DialContext(ctx, network, address)
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+func signWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookEventData identifies keys by keyRef, which is stable and unique per
+// key; the masked form is for display only and can collide.
+func (m *APIKeyManager) webhookEventData(data interface{}) interface{} {
+	switch v := data.(type) {
+	case APIKeyResponse:
+		return WebhookKeyData{
+			KeyRef:       m.keyRef(v.ID),
+			MaskedKey:    maskAPIKey(v.ID),
+			Name:         v.Name,
+			Expiration:   v.Expiration,
+			RPM:          v.RPM,
+			ThreadsLimit: v.ThreadsLimit,
+			IsActive:     v.IsActive,
+			Scopes:       v.Scopes,
+			Plan:         v.Plan,
+			OrgID:        v.OrgID,
+			CreatedAt:    v.CreatedAt,
+			UpdatedAt:    v.UpdatedAt,
+		}
+	case gin.H:
+		if id, ok := v["id"].(string); ok {
+			return gin.H{"keyRef": m.keyRef(id), "maskedKey": maskAPIKey(id)}
+		}
+	}
+	return nil
+}
+
+func webhookBackoff(attempt int) time.Duration {
+	delay := webhookBaseBackoff << uint(attempt-1)
+	if delay <= 0 || delay > webhookMaxBackoff {
+		delay = webhookMaxBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func normalizeWebhookEvents(events []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := make([]string, 0, len(events))
+	for _, event := range events {
+		event = strings.TrimSpace(event)
+		if event == "*" || event == "all" {
+			return []string{"*"}, nil
+		}
+		if !containsString(webhookEventTypes, event) {
+			return nil, fmt.Errorf("unsupported webhook event '%s': supported events are %s", event, strings.Join(webhookEventTypes, ", "))
+		}
+		if !seen[event] {
+			seen[event] = true
+			normalized = append(normalized, event)
+		}
+	}
+	if len(normalized) == 0 {
+		return []string{"*"}, nil
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+func validateWebhookURL(raw string, targets *webhookTargetPolicy) (string, error) {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return "", fmt.Errorf("invalid webhook url '%s': must be an absolute http or https URL", raw)
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if !targets.allowHost(host) {
+		if ip := net.ParseIP(host); ip != nil && !targets.allowIP(ip) {
+			return "", fmt.Errorf("invalid webhook url '%s': internal address %s is not allowed", raw, host)
+		}
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return "", fmt.Errorf("invalid webhook url '%s': host %s is not allowed", raw, host)
+		}
+	}
+	return u.String(), nil
+}
+
+func (w *Webhook) subscribed(eventType string) bool {
+	return containsString(w.Events, "*") || containsString(w.Events, eventType)
+}
+
+func (w *Webhook) redacted() Webhook {
+	redacted := *w
+	redacted.Secret = ""
+	return redacted
+}
+
+func (d *WebhookDispatcher) set(hook *Webhook) {
+	d.mu.Lock()
+	d.hooks[hook.ID] = hook
+	d.mu.Unlock()
+}
+
+func (d *WebhookDispatcher) remove(id string) {
+	d.mu.Lock()
+	delete(d.hooks, id)
+	d.mu.Unlock()
+}
+
+func (d *WebhookDispatcher) get(id string) (*Webhook, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	hook, ok := d.hooks[id]
+	if !ok {
+		return nil, false
+	}
+	snapshot := *hook
+	return &snapshot, true
+}
+
+func (d *WebhookDispatcher) list() []Webhook {
+	d.mu.RLock()
+	hooks := make([]Webhook, 0, len(d.hooks))
+	for _, hook := range d.hooks {
+		hooks = append(hooks, hook.redacted())
+	}
+	d.mu.RUnlock()
+
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].CreatedAt.Before(hooks[j].CreatedAt)
+	})
+	return hooks
+}
+
+func (d *WebhookDispatcher) Start() {
+	for i := 0; i < webhookWorkers; i++ {
+		go d.worker()
+	}
+	go d.spoolReplayer()
+}
+
+func (d *WebhookDispatcher) worker() {
+	for {
+		select {
+		case delivery := <-d.queue:
+			d.attempt(delivery)
+		case <-d.m.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *WebhookDispatcher) Dispatch(event WSMessage) {
+	if !containsString(webhookEventTypes, event.Type) {
+		return
+	}
+
+	d.mu.RLock()
+	var targets []string
+	for _, hook := range d.hooks {
+		if hook.Active && hook.subscribed(event.Type) {
+			targets = append(targets, hook.ID)
+		}
+	}
+	d.mu.RUnlock()
+
+	if len(targets) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(WebhookPayload{
+		ID:        event.ID,
+		Type:      event.Type,
+		Timestamp: event.Timestamp,
+		Data:      d.m.webhookEventData(event.Data),
+	})
+	if err != nil {
+		d.m.Error("Failed to encode webhook payload", "component", "webhooks", "type", event.Type, "error", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	for _, hookID := range targets {
+		delivery := &WebhookDelivery{
+			ID:        primitive.NewObjectID().Hex(),
+			WebhookID: hookID,
+			EventID:   event.ID,
+			EventType: event.Type,
+			Payload:   string(payload),
+			Status:    WebhookStatusPending,
+			CreatedAt: now,
+		}
+		d.save(delivery)
+		d.enqueue(delivery)
+	}
+}
+
+func (d *WebhookDispatcher) enqueue(delivery *WebhookDelivery) {
+	select {
+	case d.queue <- delivery:
+	default:
+		d.m.Warn("Webhook queue full, deferring delivery", "component", "webhooks", "deliveryId", delivery.ID)
+		d.schedule(delivery, webhookBaseBackoff)
+	}
+}
+
+func (d *WebhookDispatcher) schedule(delivery *WebhookDelivery, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		if d.m.ctx.Err() == nil {
+			d.enqueue(delivery)
+		}
+	})
+}
+
+func (d *WebhookDispatcher) attempt(delivery *WebhookDelivery) {
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.NextAttemptAt = nil
+
+	hook, ok := d.get(delivery.WebhookID)
+	if !ok || !hook.Active {
+		delivery.Status = WebhookStatusDead
+		delivery.LastError = "webhook removed or disabled"
+		atomic.AddInt64(&d.dead, 1)
+		d.save(delivery)
+		return
+	}
+
+	status, body, err := d.post(hook, delivery)
+	delivery.ResponseStatus = status
+	delivery.ResponseBody = body
+
+	if err == nil {
+		delivered := time.Now().UTC()
+		delivery.Status = WebhookStatusDelivered
+		delivery.DeliveredAt = &delivered
+		delivery.LastError = ""
+		atomic.AddInt64(&d.delivered, 1)
+		d.save(delivery)
+		return
+	}
+
+	delivery.LastError = err.Error()
+	atomic.AddInt64(&d.failed, 1)
+
+	if delivery.Attempts >= d.m.config.WebhookMaxAttempts {
+		delivery.Status = WebhookStatusDead
+		atomic.AddInt64(&d.dead, 1)
+		d.m.Warn("Webhook delivery dead-lettered", "component", "webhooks", "webhookId", hook.ID, "deliveryId", delivery.ID, "attempts", delivery.Attempts, "error", err)
+		d.save(delivery)
+		return
+	}
+
+	delay := webhookBackoff(delivery.Attempts)
+	next := time.Now().UTC().Add(delay)
+	delivery.Status = WebhookStatusRetrying
+	delivery.NextAttemptAt = &next
+	d.m.Debug("Webhook delivery failed, retrying", "component", "webhooks", "webhookId", hook.ID, "deliveryId", delivery.ID, "attempt", delivery.Attempts, "retryIn", delay, "error", err)
+	d.save(delivery)
+	d.schedule(delivery, delay)
+}
+
+func (d *WebhookDispatcher) post(hook *Webhook, delivery *WebhookDelivery) (int, string, error) {
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(d.m.ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "prod-server-webhooks/1.0")
+	req.Header.Set("X-Webhook-Id", hook.ID)
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhookPayload(hook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, webhookMaxResponseBody))
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, string(responseBody), fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, string(responseBody), nil
+}
+
+func (d *WebhookDispatcher) save(delivery *WebhookDelivery) {
+	record := *delivery
+
+	err := errors.New("database connection unavailable")
+	if d.m.isMongoConnected() {
+		err = d.m.withRetry("saveWebhookDelivery", func() error {
+			return d.store(record)
+		})
+		if err == nil {
+			return
+		}
+	}
+
+	atomic.AddInt64(&d.saveFailures, 1)
+	if spoolErr := d.spool(record); spoolErr != nil {
+		d.m.Error("Failed to record webhook delivery", "component", "webhooks", "deliveryId", record.ID, "error", err, "spoolError", spoolErr)
+		return
+	}
+	d.m.Warn("Webhook delivery spooled to disk", "component", "webhooks", "deliveryId", record.ID, "pending", atomic.LoadInt64(&d.spooled), "error", err)
+}
+
+// store upserts a delivery unless the stored record has seen more attempts,
+// so replaying an older spooled state never overwrites a newer one.
+func (d *WebhookDispatcher) store(record WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(d.m.ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := d.m.deliveriesCollection.ReplaceOne(ctx,
+		bson.M{"_id": record.ID, "attempts": bson.M{"$lte": record.Attempts}},
+		record,
+		options.Replace().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (d *WebhookDispatcher) spoolPath() string {
+	return filepath.Join(d.m.config.LogJournalDir, webhookSpoolFile)
+}
+
+func (d *WebhookDispatcher) spool(records ...WebhookDelivery) error {
+	d.spoolMu.Lock()
+	defer d.spoolMu.Unlock()
+
+	if err := os.MkdirAll(d.m.config.LogJournalDir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(d.spoolPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	atomic.AddInt64(&d.spooled, int64(len(records)))
+	return nil
+}
+
+// takeSpool removes the spool file and returns the latest state of each
+// spooled delivery.
+func (d *WebhookDispatcher) takeSpool() ([]WebhookDelivery, error) {
+	d.spoolMu.Lock()
+	defer d.spoolMu.Unlock()
+
+	data, err := os.ReadFile(d.spoolPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []WebhookDelivery
+	index := make(map[string]int)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var record WebhookDelivery
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, fmt.Errorf("corrupt webhook spool entry: %w", err)
+		}
+		if i, ok := index[record.ID]; ok {
+			if record.Attempts >= records[i].Attempts {
+				records[i] = record
+			}
+			continue
+		}
+		index[record.ID] = len(records)
+		records = append(records, record)
+	}
+
+	if err := os.Remove(d.spoolPath()); err != nil {
+		return nil, err
+	}
+	atomic.StoreInt64(&d.spooled, 0)
+	return records, nil
+}
+
+func (d *WebhookDispatcher) replaySpool() error {
+	records, err := d.takeSpool()
+	if err != nil || len(records) == 0 {
+		return err
+	}
+
+	replayed := 0
+	for _, record := range records {
+		if err = d.store(record); err != nil {
+			break
+		}
+		replayed++
+	}
+
+	if replayed > 0 {
+		d.m.Info("Replayed spooled webhook deliveries", "component", "webhooks", "count", replayed)
+	}
+	if err != nil {
+		if spoolErr := d.spool(records[replayed:]...); spoolErr != nil {
+			return fmt.Errorf("failed to respool %d webhook deliveries: %w", len(records)-replayed, spoolErr)
+		}
+		return fmt.Errorf("webhook spool replay stopped with %d deliveries pending: %w", len(records)-replayed, err)
+	}
+	return nil
+}
+
+func (d *WebhookDispatcher) spoolReplayer() {
+	ticker := time.NewTicker(webhookSpoolReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		if d.m.isMongoConnected() {
+			if err := d.replaySpool(); err != nil {
+				d.m.Warn("Webhook spool replay failed", "component", "webhooks", "error", err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-d.m.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *WebhookDispatcher) Stats() WebhookStats {
+	d.mu.RLock()
+	hooks := len(d.hooks)
+	d.mu.RUnlock()
+
+	return WebhookStats{
+		Webhooks:     hooks,
+		Queued:       len(d.queue),
+		Delivered:    atomic.LoadInt64(&d.delivered),
+		Failed:       atomic.LoadInt64(&d.failed),
+		Dead:         atomic.LoadInt64(&d.dead),
+		SaveFailures: atomic.LoadInt64(&d.saveFailures),
+		Spooled:      atomic.LoadInt64(&d.spooled),
+	}
+}
+
+func (m *APIKeyManager) loadWebhooks() error {
+	if !m.isMongoConnected() {
+		return errors.New("database connection unavailable")
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 15*time.Second)
+	defer cancel()
+
+	cursor, err := m.webhooksCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to load webhooks: %w", err)
+	}
+	var hooks []Webhook
+	if err := cursor.All(ctx, &hooks); err != nil {
+		return fmt.Errorf("failed to decode webhooks: %w", err)
+	}
+	for i := range hooks {
+		m.webhooks.set(&hooks[i])
+	}
+
+	cursor, err = m.deliveriesCollection.Find(ctx, bson.M{"status": bson.M{"$in": []string{WebhookStatusPending, WebhookStatusRetrying}}})
+	if err != nil {
+		return fmt.Errorf("failed to load pending webhook deliveries: %w", err)
+	}
+	var pending []WebhookDelivery
+	if err := cursor.All(ctx, &pending); err != nil {
+		return fmt.Errorf("failed to decode pending webhook deliveries: %w", err)
+	}
+
+	now := time.Now().UTC()
+	for i := range pending {
+		delivery := &pending[i]
+		delay := time.Duration(0)
+		if delivery.NextAttemptAt != nil && delivery.NextAttemptAt.After(now) {
+			delay = delivery.NextAttemptAt.Sub(now)
+		}
+		m.webhooks.schedule(delivery, delay)
+	}
+
+	m.Info("Webhooks loaded", "component", "webhooks", "webhooks", len(hooks), "pendingDeliveries", len(pending))
+	return nil
+}
+
+func (m *APIKeyManager) listWebhooksHandler(c *gin.Context) {
+	m.respondWithSuccess(c, m.webhooks.list(), "")
+}
+
+func (m *APIKeyManager) getWebhookHandler(c *gin.Context) {
+	hook, ok := m.webhooks.get(c.Param("id"))
+	if !ok {
+		m.respondWithError(c, http.StatusNotFound, "Webhook not found", "WEBHOOK_NOT_FOUND", nil)
+		return
+	}
+	m.respondWithSuccess(c, hook.redacted(), "")
+}
+
+func (m *APIKeyManager) createWebhookHandler(c *gin.Context) {
+	var req CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		m.respondWithError(c, http.StatusBadRequest, "Invalid request data", "INVALID_REQUEST", err)
+		return
+	}
+
+	hookURL, err := validateWebhookURL(req.URL, m.webhooks.targets)
+	if err != nil {
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_WEBHOOK_URL", nil)
+		return
+	}
+	events, err := normalizeWebhookEvents(req.Events)
+	if err != nil {
+		m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_WEBHOOK_EVENTS", nil)
+		return
+	}
+
+	secret := strings.TrimSpace(req.Secret)
+	if secret == "" {
+		secret = webhookSecretPrefix + generateSecureKey(32)
+	}
+
+	if err := m.ensureMongoConnection(); err != nil {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	hook := &Webhook{
+		ID:          primitive.NewObjectID().Hex(),
+		URL:         hookURL,
+		Events:      events,
+		Secret:      secret,
+		Description: strings.TrimSpace(req.Description),
+		Active:      true,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	err = m.withRetry("createWebhook", func() error {
+		ctx, cancel := context.WithTimeout(m.ctx, 10*time.Second)
+		defer cancel()
+		_, err := m.webhooksCollection.InsertOne(ctx, hook)
+		return err
+	})
+	if err != nil {
+		m.ErrorContext(c, "Failed to create webhook", "error", err)
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to create webhook", "WEBHOOK_CREATE_FAILED", err)
+		return
+	}
+
+	m.webhooks.set(hook)
+	m.recordAudit(c, AuditActionWebhookCreate, "webhook", hook.ID, nil)
+	m.InfoContext(c, "Webhook created", "webhookId", hook.ID, "url", hook.URL, "events", hook.Events)
+
+	m.respondWithSuccess(c, hook, "Webhook created successfully")
+}
+
+func (m *APIKeyManager) updateWebhookHandler(c *gin.Context) {
+	var req UpdateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		m.respondWithError(c, http.StatusBadRequest, "Invalid request data", "INVALID_REQUEST", err)
+		return
+	}
+
+	hook, ok := m.webhooks.get(c.Param("id"))
+	if !ok {
+		m.respondWithError(c, http.StatusNotFound, "Webhook not found", "WEBHOOK_NOT_FOUND", nil)
+		return
+	}
+
+	changes := []string{}
+	if req.URL != nil {
+		hookURL, err := validateWebhookURL(*req.URL, m.webhooks.targets)
+		if err != nil {
+			m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_WEBHOOK_URL", nil)
+			return
+		}
+		if hookURL != hook.URL {
+			hook.URL = hookURL
+			changes = append(changes, "url")
+		}
+	}
+	if req.Events != nil {
+		events, err := normalizeWebhookEvents(*req.Events)
+		if err != nil {
+			m.respondWithError(c, http.StatusBadRequest, err.Error(), "INVALID_WEBHOOK_EVENTS", nil)
+			return
+		}
+		if strings.Join(events, ",") != strings.Join(hook.Events, ",") {
+			hook.Events = events
+			changes = append(changes, "events")
+		}
+	}
+	if req.Description != nil && strings.TrimSpace(*req.Description) != hook.Description {
+		hook.Description = strings.TrimSpace(*req.Description)
+		changes = append(changes, "description")
+	}
+	if req.Active != nil && *req.Active != hook.Active {
+		hook.Active = *req.Active
+		changes = append(changes, "active")
+	}
+	if req.RotateSecret {
+		hook.Secret = webhookSecretPrefix + generateSecureKey(32)
+		changes = append(changes, "secret")
+	}
+
+	if len(changes) == 0 {
+		m.respondWithSuccess(c, hook.redacted(), "No changes detected")
+		return
+	}
+
+	if err := m.ensureMongoConnection(); err != nil {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", err)
+		return
+	}
+
+	hook.UpdatedAt = time.Now().UTC()
+	err := m.withRetry("updateWebhook", func() error {
+		ctx, cancel := context.WithTimeout(m.ctx, 10*time.Second)
+		defer cancel()
+		_, err := m.webhooksCollection.ReplaceOne(ctx, bson.M{"_id": hook.ID}, hook)
+		return err
+	})
+	if err != nil {
+		m.ErrorContext(c, "Failed to update webhook", "webhookId", hook.ID, "error", err)
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to update webhook", "WEBHOOK_UPDATE_FAILED", err)
+		return
+	}
+
+	m.webhooks.set(hook)
+	m.recordAudit(c, AuditActionWebhookUpdate, "webhook", hook.ID, nil)
+	m.InfoContext(c, "Webhook updated", "webhookId", hook.ID, "changes", changes)
+
+	response := hook.redacted()
+	if req.RotateSecret {
+		response.Secret = hook.Secret
+	}
+	m.respondWithSuccess(c, response, fmt.Sprintf("Webhook updated successfully (%s)", strings.Join(changes, ", ")))
+}
+
+func (m *APIKeyManager) deleteWebhookHandler(c *gin.Context) {
+	id := c.Param("id")
+	if _, ok := m.webhooks.get(id); !ok {
+		m.respondWithError(c, http.StatusNotFound, "Webhook not found", "WEBHOOK_NOT_FOUND", nil)
+		return
+	}
+
+	if err := m.ensureMongoConnection(); err != nil {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", err)
+		return
+	}
+
+	err := m.withRetry("deleteWebhook", func() error {
+		ctx, cancel := context.WithTimeout(m.ctx, 10*time.Second)
+		defer cancel()
+		_, err := m.webhooksCollection.DeleteOne(ctx, bson.M{"_id": id})
+		return err
+	})
+	if err != nil {
+		m.ErrorContext(c, "Failed to delete webhook", "webhookId", id, "error", err)
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to delete webhook", "WEBHOOK_DELETE_FAILED", err)
+		return
+	}
+
+	m.webhooks.remove(id)
+	m.recordAudit(c, AuditActionWebhookDelete, "webhook", id, nil)
+	m.InfoContext(c, "Webhook deleted", "webhookId", id)
+
+	m.respondWithSuccess(c, nil, "Webhook deleted successfully")
+}
+
+func (m *APIKeyManager) listWebhookDeliveriesHandler(c *gin.Context) {
+	if !m.isMongoConnected() {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", nil)
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	filter := bson.M{"webhookId": c.Param("id")}
+	if statuses := queryList(c, "status"); len(statuses) > 0 {
+		filter["status"] = bson.M{"$in": statuses}
+	}
+	if eventType := strings.TrimSpace(c.Query("eventType")); eventType != "" {
+		filter["eventType"] = eventType
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 15*time.Second)
+	defer cancel()
+
+	totalCount, err := m.deliveriesCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to count webhook deliveries", "COUNT_FAILED", err)
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+
+	cursor, err := m.deliveriesCollection.Find(ctx, filter, opts)
+	if err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to retrieve webhook deliveries", "RETRIEVAL_FAILED", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to decode webhook deliveries", "DECODE_FAILED", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ApiResponse{
+		Data: deliveries,
+		Pagination: &PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      totalCount,
+			TotalPages: int((totalCount + int64(limit) - 1) / int64(limit)),
+		},
+		Success:   true,
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+func (m *APIKeyManager) redeliverWebhookHandler(c *gin.Context) {
+	hookID := c.Param("id")
+	if _, ok := m.webhooks.get(hookID); !ok {
+		m.respondWithError(c, http.StatusNotFound, "Webhook not found", "WEBHOOK_NOT_FOUND", nil)
+		return
+	}
+	if !m.isMongoConnected() {
+		m.respondWithError(c, http.StatusServiceUnavailable, "Database connection unavailable", "DB_UNAVAILABLE", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 10*time.Second)
+	defer cancel()
+
+	var original WebhookDelivery
+	err := m.deliveriesCollection.FindOne(ctx, bson.M{"_id": c.Param("deliveryId"), "webhookId": hookID}).Decode(&original)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		m.respondWithError(c, http.StatusNotFound, "Webhook delivery not found", "DELIVERY_NOT_FOUND", nil)
+		return
+	}
+	if err != nil {
+		m.respondWithError(c, http.StatusInternalServerError, "Failed to retrieve webhook delivery", "RETRIEVAL_FAILED", err)
+		return
+	}
+
+	delivery := &WebhookDelivery{
+		ID:           primitive.NewObjectID().Hex(),
+		WebhookID:    original.WebhookID,
+		EventID:      original.EventID,
+		EventType:    original.EventType,
+		Payload:      original.Payload,
+		Status:       WebhookStatusPending,
+		RedeliveryOf: original.ID,
+		CreatedAt:    time.Now().UTC(),
+	}
+	m.webhooks.save(delivery)
+	m.webhooks.enqueue(delivery)
+
+	m.recordAudit(c, AuditActionWebhookRedeliver, "webhookDelivery", original.ID, nil)
+	m.InfoContext(c, "Webhook delivery requeued", "webhookId", hookID, "deliveryId", original.ID, "redeliveryId", delivery.ID)
+
+	c.JSON(http.StatusAccepted, ApiResponse{
+		Data:      delivery,
+		Message:   "Webhook delivery queued",
+		Success:   true,
+		Timestamp: time.Now().UTC(),
+	})
+}
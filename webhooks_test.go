@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	body := []byte(`{"id":"evt_1","type":"key_created"}`)
+	got := signWebhookPayload("whsec_test", "1700000000", body)
+	if want := "79c61026c0bfe9a8480bad572da053b6468dfde0100941187b4e2bc5c2029378"; got != want {
+		t.Fatalf("signWebhookPayload() = %q, want %q", got, want)
+	}
+	if got == signWebhookPayload("whsec_other", "1700000000", body) {
+		t.Fatal("signature does not depend on the secret")
+	}
+	if got == signWebhookPayload("whsec_test", "1700000001", body) {
+		t.Fatal("signature does not depend on the timestamp")
+	}
+}
+
+func TestWebhookEventDataMasksKey(t *testing.T) {
+	const rawKey = "sk_live_0123456789abcdef"
+	m := &APIKeyManager{config: &Config{KeyRefSecret: "webhook-secret"}}
+	for _, data := range []interface{}{
+		APIKeyResponse{ID: rawKey, MaskedKey: maskAPIKey(rawKey), Name: "ci"},
+		gin.H{"id": rawKey},
+	} {
+		encoded, err := json.Marshal(m.webhookEventData(data))
+		if err != nil {
+			t.Fatalf("json.Marshal() error = %v", err)
+		}
+		if strings.Contains(string(encoded), rawKey) {
+			t.Fatalf("webhook payload %s contains the raw key", encoded)
+		}
+		if !strings.Contains(string(encoded), maskAPIKey(rawKey)) {
+			t.Fatalf("webhook payload %s is missing the masked key", encoded)
+		}
+		if !strings.Contains(string(encoded), `"keyRef":"`+m.keyRef(rawKey)+`"`) {
+			t.Fatalf("webhook payload %s is missing the keyRef", encoded)
+		}
+	}
+}
+
+func TestWebhookAttemptRetriesThenDeadLetters(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		requests  int
+		signature string
+		timestamp string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		signature = r.Header.Get("X-Webhook-Signature")
+		timestamp = r.Header.Get("X-Webhook-Timestamp")
+		mu.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "boom")
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := &APIKeyManager{
+		ctx:    ctx,
+		config: &Config{WebhookMaxAttempts: 3, LogJournalDir: t.TempDir()},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	targets, _ := newWebhookTargetPolicy([]string{"127.0.0.1"})
+	d := NewWebhookDispatcher(m, 5*time.Second, targets)
+	d.set(&Webhook{ID: "hook-1", URL: server.URL, Secret: "whsec_test", Active: true, Events: []string{"*"}})
+
+	delivery := &WebhookDelivery{ID: "delivery-1", WebhookID: "hook-1", EventType: "key_created", Payload: `{"type":"key_created"}`, Status: WebhookStatusPending}
+	for attempt := 1; attempt <= 3; attempt++ {
+		d.attempt(delivery)
+		if attempt < 3 && (delivery.Status != WebhookStatusRetrying || delivery.NextAttemptAt == nil) {
+			t.Fatalf("attempt %d status = %s, want %s with next attempt scheduled", attempt, delivery.Status, WebhookStatusRetrying)
+		}
+	}
+
+	if delivery.Status != WebhookStatusDead || delivery.Attempts != 3 {
+		t.Fatalf("delivery = %s after %d attempts, want %s after 3", delivery.Status, delivery.Attempts, WebhookStatusDead)
+	}
+	if delivery.ResponseStatus != http.StatusInternalServerError || delivery.ResponseBody != "boom" {
+		t.Fatalf("response = %d %q, want 500 \"boom\"", delivery.ResponseStatus, delivery.ResponseBody)
+	}
+	if stats := d.Stats(); stats.Failed != 3 || stats.Dead != 1 || stats.Delivered != 0 {
+		t.Fatalf("Stats() = %+v, want 3 failed, 1 dead", stats)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 3 {
+		t.Fatalf("endpoint received %d requests, want 3", requests)
+	}
+	if want := "sha256=" + signWebhookPayload("whsec_test", timestamp, []byte(delivery.Payload)); signature != want {
+		t.Fatalf("X-Webhook-Signature = %q, want %q", signature, want)
+	}
+}
+
+func TestValidateWebhookURLRejectsInternalTargets(t *testing.T) {
+	allowed, err := newWebhookTargetPolicy([]string{"10.1.0.0/16", "hooks.internal", "::1"})
+	if err != nil {
+		t.Fatalf("newWebhookTargetPolicy() error = %v", err)
+	}
+
+	tests := []struct {
+		url     string
+		targets *webhookTargetPolicy
+		wantErr bool
+	}{
+		{url: "https://hooks.example.com/in", wantErr: false},
+		{url: "https://203.0.113.10/in", wantErr: false},
+		{url: "ftp://hooks.example.com/in", wantErr: true},
+		{url: "http://127.0.0.1:8080/in", wantErr: true},
+		{url: "http://localhost/in", wantErr: true},
+		{url: "http://api.localhost/in", wantErr: true},
+		{url: "http://169.254.169.254/latest/meta-data", wantErr: true},
+		{url: "http://10.1.2.3/in", wantErr: true},
+		{url: "http://192.168.1.10/in", wantErr: true},
+		{url: "http://[::1]/in", wantErr: true},
+		{url: "http://[fe80::1]/in", wantErr: true},
+		{url: "http://0.0.0.0/in", wantErr: true},
+		{url: "http://10.1.2.3/in", targets: allowed, wantErr: false},
+		{url: "http://10.2.0.1/in", targets: allowed, wantErr: true},
+		{url: "http://[::1]/in", targets: allowed, wantErr: false},
+		{url: "http://hooks.internal/in", targets: allowed, wantErr: false},
+	}
+
+	for _, tt := range tests {
+		_, err := validateWebhookURL(tt.url, tt.targets)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateWebhookURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWebhookClientRefusesInternalAddressesAtDial(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	var blocked *webhookTargetPolicy
+	if _, err := blocked.client(time.Second).Get(server.URL); err == nil {
+		t.Fatal("client reached a loopback address without an allowlist entry")
+	}
+
+	allowed, _ := newWebhookTargetPolicy([]string{"127.0.0.0/8"})
+	resp, err := allowed.client(time.Second).Get(server.URL)
+	if err != nil {
+		t.Fatalf("allowlisted client error = %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestWebhookSaveSpoolsWhileStoreUnavailable(t *testing.T) {
+	m := &APIKeyManager{
+		ctx:    context.Background(),
+		config: &Config{LogJournalDir: t.TempDir()},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	d := NewWebhookDispatcher(m, time.Second, nil)
+
+	delivery := &WebhookDelivery{ID: "delivery-1", WebhookID: "hook-1", Status: WebhookStatusPending}
+	d.save(delivery)
+	delivery.Attempts = 1
+	delivery.Status = WebhookStatusRetrying
+	d.save(delivery)
+	d.save(&WebhookDelivery{ID: "delivery-2", WebhookID: "hook-1", Status: WebhookStatusPending})
+
+	if stats := d.Stats(); stats.SaveFailures != 3 || stats.Spooled != 3 {
+		t.Fatalf("Stats() = %+v, want 3 save failures and 3 spooled", stats)
+	}
+
+	records, err := d.takeSpool()
+	if err != nil {
+		t.Fatalf("takeSpool() error = %v", err)
+	}
+	if len(records) != 2 || records[0].ID != "delivery-1" || records[0].Status != WebhookStatusRetrying || records[1].ID != "delivery-2" {
+		t.Fatalf("takeSpool() = %+v, want the latest state of each delivery", records)
+	}
+	if stats := d.Stats(); stats.Spooled != 0 {
+		t.Fatalf("Stats().Spooled = %d after takeSpool, want 0", stats.Spooled)
+	}
+	if records, _ := d.takeSpool(); len(records) != 0 {
+		t.Fatalf("second takeSpool() = %+v, want empty", records)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	TopicKeys     = "keys"
+	TopicLogs     = "logs"
+	TopicSystem   = "system"
+	TopicPresence = "presence"
+
+	eventHistorySize = 1000
+)
+
+var eventTopicFamilies = []string{TopicKeys, TopicLogs, TopicSystem, TopicPresence}
+
+func topicFamily(topic string) string {
+	if i := strings.IndexByte(topic, ':'); i >= 0 {
+		return topic[:i]
+	}
+	return topic
+}
+
+func validateTopic(topic string) error {
+	family := topicFamily(topic)
+	switch {
+	case topic == TopicKeys || topic == TopicLogs || topic == TopicSystem || topic == TopicPresence:
+		return nil
+	case family == TopicKeys && len(topic) > len(TopicKeys)+1:
+		return nil
+	default:
+		return fmt.Errorf("invalid topic '%s': supported topics are keys, logs, system, presence, keys:<id>", topic)
+	}
+}
+
+type eventRing struct {
+	events  []WSMessage
+	next    int
+	full    bool
+	evicted uint64
+}
+
+func (r *eventRing) add(event WSMessage) {
+	if r.full {
+		r.evicted = r.events[r.next].Seq
+	}
+	r.events[r.next] = event
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *eventRing) since(after uint64, accept func(WSMessage) bool) []WSMessage {
+	count := r.next
+	start := 0
+	if r.full {
+		count = len(r.events)
+		start = r.next
+	}
+
+	var events []WSMessage
+	for i := 0; i < count; i++ {
+		event := r.events[(start+i)%len(r.events)]
+		if event.Seq > after && accept(event) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+type EventHistory struct {
+	mu    sync.RWMutex
+	epoch string
+	seq   uint64
+	rings map[string]*eventRing
+}
+
+func NewEventHistory(size int) *EventHistory {
+	h := &EventHistory{
+		epoch: generateRequestID(),
+		rings: make(map[string]*eventRing, len(eventTopicFamilies)),
+	}
+	for _, family := range eventTopicFamilies {
+		h.rings[family] = &eventRing{events: make([]WSMessage, size)}
+	}
+	return h
+}
+
+func (h *EventHistory) Append(event *WSMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	event.Seq = h.seq
+	if ring, ok := h.rings[topicFamily(event.Topic)]; ok {
+		ring.add(*event)
+	}
+}
+
+func (h *EventHistory) CurrentSeq() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.seq
+}
+
+func (h *EventHistory) Since(after uint64, families []string, accept func(WSMessage) bool) ([]WSMessage, uint64, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var (
+		events []WSMessage
+		oldest uint64
+		ok     = true
+	)
+	for _, family := range families {
+		ring, exists := h.rings[family]
+		if !exists {
+			continue
+		}
+		if ring.evicted > after {
+			ok = false
+			if oldest == 0 || ring.evicted+1 > oldest {
+				oldest = ring.evicted + 1
+			}
+			continue
+		}
+		events = append(events, ring.since(after, accept)...)
+	}
+
+	if !ok {
+		return nil, oldest, false
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Seq < events[j].Seq
+	})
+	return events, oldest, true
+}
+
+func (wsc *WSClient) subscribedTo(topic string) bool {
+	if wsc.topics == nil {
+		return true
+	}
+	return wsc.topics[topic] || wsc.topics[topicFamily(topic)]
+}
+
+func (wsc *WSClient) wants(event WSMessage) bool {
+	wsc.subMu.RLock()
+	defer wsc.subMu.RUnlock()
+
+	if !wsc.subscribedTo(event.Topic) {
+		return false
+	}
+	if entry, ok := event.Data.(LogEntry); ok {
+		return wsc.logFilter.Matches(entry)
+	}
+	return true
+}
+
+func (wsc *WSClient) Topics() []string {
+	wsc.subMu.RLock()
+	defer wsc.subMu.RUnlock()
+
+	if wsc.topics == nil {
+		return append([]string(nil), eventTopicFamilies...)
+	}
+	topics := make([]string, 0, len(wsc.topics))
+	for topic := range wsc.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func (wsc *WSClient) subscribedFamilies() []string {
+	wsc.subMu.RLock()
+	defer wsc.subMu.RUnlock()
+
+	if wsc.topics == nil {
+		return eventTopicFamilies
+	}
+	seen := make(map[string]bool)
+	var families []string
+	for topic := range wsc.topics {
+		family := topicFamily(topic)
+		if !seen[family] {
+			seen[family] = true
+			families = append(families, family)
+		}
+	}
+	return families
+}
+
+func (wsc *WSClient) updateTopics(add, remove []string) {
+	wsc.subMu.Lock()
+	defer wsc.subMu.Unlock()
+
+	if wsc.topics == nil {
+		if add != nil {
+			wsc.topics = make(map[string]bool)
+		} else {
+			wsc.topics = make(map[string]bool, len(eventTopicFamilies))
+			for _, family := range eventTopicFamilies {
+				wsc.topics[family] = true
+			}
+		}
+	}
+
+	for _, topic := range add {
+		wsc.topics[topic] = true
+	}
+	for _, topic := range remove {
+		delete(wsc.topics, topic)
+		if topicFamily(topic) == topic {
+			for existing := range wsc.topics {
+				if topicFamily(existing) == topic {
+					delete(wsc.topics, existing)
+				}
+			}
+		}
+	}
+}
+
+func (m *APIKeyManager) sendClientError(wsClient *WSClient, message string) {
+	wsClient.Send(WSMessage{
+		Type:      "error",
+		Data:      map[string]interface{}{"message": message},
+		Timestamp: time.Now().UTC(),
+		ID:        generateRequestID(),
+	})
+}
+
+func (m *APIKeyManager) handleSubscribe(wsClient *WSClient, msg WSClientMessage) {
+	for _, topic := range msg.Topics {
+		if err := validateTopic(topic); err != nil {
+			m.sendClientError(wsClient, err.Error())
+			return
+		}
+	}
+
+	if msg.Topics != nil {
+		wsClient.updateTopics(msg.Topics, nil)
+	}
+	if msg.Filter != nil || msg.Topics == nil {
+		filter := msg.Filter
+		if filter == nil {
+			filter = &LogTailFilter{}
+		}
+		wsClient.setLogFilter(filter)
+	}
+
+	wsClient.subMu.RLock()
+	filter := wsClient.logFilter
+	logs := wsClient.subscribedTo(TopicLogs)
+	wsClient.subMu.RUnlock()
+
+	wsClient.Send(WSMessage{
+		Type: "subscribed",
+		Data: map[string]interface{}{
+			"topics": wsClient.Topics(),
+			"filter": filter,
+			"epoch":  m.eventHistory.epoch,
+			"seq":    m.eventHistory.CurrentSeq(),
+		},
+		Timestamp: time.Now().UTC(),
+		ID:        generateRequestID(),
+	})
+
+	if logs {
+		m.sendLogBacklog(wsClient, msg.Backlog)
+	}
+
+	if msg.ResumeFrom != nil {
+		replay := &wsReplay{resumeFrom: *msg.ResumeFrom, epoch: msg.Epoch, families: wsClient.resumeFamilies(msg.Topics)}
+		if wsClient.beginReplay(replay) {
+			if err := wsClient.SendWait(WSMessage{replay: replay}, wsClient.writeTimeout); err != nil {
+				m.Warn("WebSocket replay aborted", "clientId", wsClient.clientID, "error", err)
+				wsClient.CloseWith(websocket.ClosePolicyViolation, "slow consumer")
+				return
+			}
+		}
+	}
+
+	m.Debug("WebSocket subscription updated", "clientId", wsClient.clientID, "topics", wsClient.Topics(), "resumeFrom", msg.ResumeFrom)
+}
+
+func (m *APIKeyManager) handleUnsubscribe(wsClient *WSClient, msg WSClientMessage) {
+	for _, topic := range msg.Topics {
+		if err := validateTopic(topic); err != nil {
+			m.sendClientError(wsClient, err.Error())
+			return
+		}
+	}
+
+	wsClient.updateTopics(nil, msg.Topics)
+
+	wsClient.Send(WSMessage{
+		Type:      "unsubscribed",
+		Data:      map[string]interface{}{"topics": wsClient.Topics()},
+		Timestamp: time.Now().UTC(),
+		ID:        generateRequestID(),
+	})
+}
+
+type wsReplay struct {
+	resumeFrom uint64
+	epoch      string
+	families   []string
+	held       []WSMessage
+}
+
+func (r *wsReplay) covers(event WSMessage) bool {
+	return event.Seq > 0 && containsString(r.families, topicFamily(event.Topic))
+}
+
+func (wsc *WSClient) resumeFamilies(topics []string) []string {
+	if len(topics) == 0 {
+		return wsc.subscribedFamilies()
+	}
+	var families []string
+	for _, topic := range topics {
+		if family := topicFamily(topic); !containsString(families, family) {
+			families = append(families, family)
+		}
+	}
+	return families
+}
+
+func (wsc *WSClient) beginReplay(replay *wsReplay) bool {
+	wsc.replayMu.Lock()
+	defer wsc.replayMu.Unlock()
+
+	if active := wsc.replay; active != nil {
+		for _, family := range replay.families {
+			if !containsString(active.families, family) {
+				active.families = append(active.families, family)
+			}
+		}
+		if replay.resumeFrom < active.resumeFrom {
+			active.resumeFrom = replay.resumeFrom
+		}
+		return false
+	}
+	wsc.replay = replay
+	return true
+}
+
+func (wsc *WSClient) Deliver(event WSMessage) error {
+	wsc.replayMu.Lock()
+	if replay := wsc.replay; replay != nil && replay.covers(event) {
+		defer wsc.replayMu.Unlock()
+		if len(replay.held) >= cap(wsc.send) {
+			atomic.AddInt64(&wsc.dropped, 1)
+			return errSlowConsumer
+		}
+		replay.held = append(replay.held, event)
+		return nil
+	}
+	wsc.replayMu.Unlock()
+	return wsc.Send(event)
+}
+
+func (wsc *WSClient) replayCovers(event WSMessage) bool {
+	wsc.replayMu.Lock()
+	defer wsc.replayMu.Unlock()
+	return wsc.replay != nil && wsc.replay.covers(event)
+}
+
+func (m *APIKeyManager) outgoing(wsClient *WSClient, message WSMessage, replayed map[string]uint64) []WSMessage {
+	if message.replay != nil {
+		events, upTo := m.finishReplay(wsClient, message.replay)
+		for _, family := range message.replay.families {
+			replayed[family] = upTo
+		}
+		return events
+	}
+	if message.Seq > 0 && (wsClient.replayCovers(message) || message.Seq <= replayed[topicFamily(message.Topic)]) {
+		return nil
+	}
+	return []WSMessage{message}
+}
+
+func (m *APIKeyManager) finishReplay(wsClient *WSClient, replay *wsReplay) ([]WSMessage, uint64) {
+	wsClient.replayMu.Lock()
+	defer wsClient.replayMu.Unlock()
+	wsClient.replay = nil
+
+	current := m.eventHistory.CurrentSeq()
+	var events []WSMessage
+	if replay.resumeFrom > current || (replay.epoch != "" && replay.epoch != m.eventHistory.epoch) {
+		events = []WSMessage{m.resyncRequired(replay.resumeFrom, 0, current)}
+	} else if history, oldest, ok := m.eventHistory.Since(replay.resumeFrom, replay.families, wsClient.wants); !ok {
+		events = []WSMessage{m.resyncRequired(replay.resumeFrom, oldest, current)}
+	} else {
+		events = history
+		m.Debug("Replayed WebSocket events", "clientId", wsClient.clientID, "resumeFrom", replay.resumeFrom, "count", len(history))
+	}
+
+	for _, event := range replay.held {
+		if event.Seq > current {
+			events = append(events, event)
+		}
+	}
+	return events, current
+}
+
+func (m *APIKeyManager) resyncRequired(resumeFrom, oldest, current uint64) WSMessage {
+	return WSMessage{
+		Type: "resync_required",
+		Data: map[string]interface{}{
+			"resumeFrom":      resumeFrom,
+			"oldestAvailable": oldest,
+			"epoch":           m.eventHistory.epoch,
+			"seq":             current,
+		},
+		Timestamp: time.Now().UTC(),
+		ID:        generateRequestID(),
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestEventHistorySince(t *testing.T) {
+	history := NewEventHistory(3)
+	for _, topic := range []string{TopicKeys, TopicSystem, TopicKeys, "keys:abc", TopicSystem} {
+		event := WSMessage{Type: "test", Topic: topic}
+		history.Append(&event)
+	}
+	acceptAll := func(WSMessage) bool { return true }
+
+	events, _, ok := history.Since(1, []string{TopicSystem, TopicKeys}, acceptAll)
+	if !ok {
+		t.Fatal("Since(1) reported eviction, want all events after 1 retained")
+	}
+	var seqs []uint64
+	for _, event := range events {
+		seqs = append(seqs, event.Seq)
+	}
+	if len(seqs) != 4 || seqs[0] != 2 || seqs[3] != 5 {
+		t.Fatalf("Since(1) seqs = %v, want [2 3 4 5] in order", seqs)
+	}
+
+	event := WSMessage{Type: "test", Topic: TopicKeys}
+	history.Append(&event)
+
+	if _, oldest, ok := history.Since(0, []string{TopicKeys}, acceptAll); ok || oldest != 2 {
+		t.Fatalf("Since(0) = ok %v oldest %d, want eviction with oldest 2", ok, oldest)
+	}
+	if events, _, ok := history.Since(1, []string{TopicKeys}, acceptAll); !ok || len(events) != 3 {
+		t.Fatalf("Since(1) = %d events ok %v, want 3 retained keys events", len(events), ok)
+	}
+	if _, _, ok := history.Since(0, []string{TopicSystem}, acceptAll); !ok {
+		t.Fatal("Since(0) on system ring reported eviction, want retained")
+	}
+}
+
+func TestFinishReplayResyncRequired(t *testing.T) {
+	m := &APIKeyManager{
+		eventHistory: NewEventHistory(2),
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	for i := 0; i < 3; i++ {
+		event := WSMessage{Type: "test", Topic: TopicKeys}
+		m.eventHistory.Append(&event)
+	}
+	client := &WSClient{}
+
+	tests := []struct {
+		name       string
+		replay     wsReplay
+		wantType   string
+		wantOldest uint64
+	}{
+		{name: "evicted", replay: wsReplay{resumeFrom: 0}, wantType: "resync_required", wantOldest: 2},
+		{name: "future seq", replay: wsReplay{resumeFrom: 9}, wantType: "resync_required"},
+		{name: "other epoch", replay: wsReplay{resumeFrom: 1, epoch: "stale"}, wantType: "resync_required"},
+		{name: "retained", replay: wsReplay{resumeFrom: 1}, wantType: "test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replay := tt.replay
+			replay.families = []string{TopicKeys}
+			written, _ := m.finishReplay(client, &replay)
+			if len(written) == 0 || written[0].Type != tt.wantType {
+				t.Fatalf("finishReplay() = %+v, want first message %q", written, tt.wantType)
+			}
+			if tt.wantType == "resync_required" {
+				data := written[0].Data.(map[string]interface{})
+				if data["oldestAvailable"] != tt.wantOldest {
+					t.Fatalf("oldestAvailable = %v, want %d", data["oldestAvailable"], tt.wantOldest)
+				}
+			} else if len(written) != 2 || written[0].Seq != 2 || written[1].Seq != 3 {
+				t.Fatalf("finishReplay() = %+v, want seqs 2 and 3", written)
+			}
+		})
+	}
+}
+
+func newReplayTestManager() *APIKeyManager {
+	return &APIKeyManager{
+		eventHistory: NewEventHistory(eventHistorySize),
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+func appendEvent(m *APIKeyManager, topic string) WSMessage {
+	event := WSMessage{Type: "test", Topic: topic}
+	m.eventHistory.Append(&event)
+	return event
+}
+
+func seqsOf(events []WSMessage) []uint64 {
+	seqs := make([]uint64, 0, len(events))
+	for _, event := range events {
+		seqs = append(seqs, event.Seq)
+	}
+	return seqs
+}
+
+func TestResubscribeWithResumeReplaysOnlyResumedTopics(t *testing.T) {
+	m := newReplayTestManager()
+	client := &WSClient{send: make(chan WSMessage, 16)}
+	client.updateTopics([]string{TopicLogs}, nil)
+	replayed := make(map[string]uint64)
+
+	var keyEvents []uint64
+	for i := 0; i < 20; i++ {
+		topic := TopicLogs
+		if i%4 == 0 {
+			topic = TopicKeys
+		}
+		event := appendEvent(m, topic)
+		if topic == TopicKeys {
+			keyEvents = append(keyEvents, event.Seq)
+			continue
+		}
+		if out := m.outgoing(client, event, replayed); len(out) != 1 {
+			t.Fatalf("live log event %d was not written", event.Seq)
+		}
+	}
+
+	client.updateTopics([]string{TopicKeys}, nil)
+	replay := &wsReplay{resumeFrom: 5, families: client.resumeFamilies([]string{TopicKeys})}
+	if !client.beginReplay(replay) {
+		t.Fatal("beginReplay() = false with no replay in progress")
+	}
+	out := m.outgoing(client, WSMessage{replay: replay}, replayed)
+
+	want := []uint64{}
+	for _, seq := range keyEvents {
+		if seq > 5 {
+			want = append(want, seq)
+		}
+	}
+	if got := seqsOf(out); len(got) != len(want) || got[0] != want[0] || got[len(got)-1] != want[len(want)-1] {
+		t.Fatalf("replay = %v, want keys events %v", got, want)
+	}
+	for _, event := range out {
+		if event.Topic != TopicKeys {
+			t.Fatalf("replay included %s event %d, want only resumed keys events", event.Topic, event.Seq)
+		}
+	}
+
+	if next := appendEvent(m, TopicLogs); len(m.outgoing(client, next, replayed)) != 1 {
+		t.Fatal("live log event after replay was dropped")
+	}
+}
+
+func TestLiveEventsRacingReplayStayOrdered(t *testing.T) {
+	m := newReplayTestManager()
+	client := &WSClient{send: make(chan WSMessage, 16)}
+	replayed := make(map[string]uint64)
+	for i := 0; i < 5; i++ {
+		appendEvent(m, TopicKeys)
+	}
+
+	queued := appendEvent(m, TopicKeys)
+	client.Deliver(queued)
+
+	replay := &wsReplay{resumeFrom: 2, families: []string{TopicKeys}}
+	client.beginReplay(replay)
+	client.send <- WSMessage{replay: replay}
+
+	held := appendEvent(m, TopicKeys)
+	if err := client.Deliver(held); err != nil {
+		t.Fatalf("Deliver() during replay error = %v", err)
+	}
+	system := appendEvent(m, TopicSystem)
+	client.Deliver(system)
+
+	var written []WSMessage
+	for len(client.send) > 0 {
+		written = append(written, m.outgoing(client, <-client.send, replayed)...)
+	}
+
+	late := appendEvent(m, TopicKeys)
+	client.Deliver(held)
+	client.Deliver(late)
+	for len(client.send) > 0 {
+		written = append(written, m.outgoing(client, <-client.send, replayed)...)
+	}
+
+	got := seqsOf(written)
+	want := []uint64{3, 4, 5, 6, 7, 8, 9}
+	if len(got) != len(want) {
+		t.Fatalf("written seqs = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("written seqs = %v, want %v", got, want)
+		}
+	}
+}